@@ -0,0 +1,214 @@
+// imcapture 抓包与回放工具
+//
+// 录制：作为TCP代理转发客户端与上游服务之间的流量，每个客户端连接写入一个抓包文件
+//
+//	imcapture record -listen :9000 -upstream 127.0.0.1:8000 -dir ./captures
+//
+// 回放：按原始时间间隔解码并打印某一方向的帧，或写入一个实时连接并打印收到的回复
+//
+//	imcapture replay -direction read captures/conn-1.imcap
+//	imcapture replay -direction read -speed 2 -addr 127.0.0.1:8000 captures/conn-1.imcap
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/myqiao/im-protocol/protocol"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(ctx, os.Args[2:])
+	case "replay":
+		err = replay(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+}
+
+// usage 打印用法并退出
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: imcapture record -listen addr -upstream addr [-dir dir]")
+	fmt.Fprintln(os.Stderr, "       imcapture replay [-direction read|write] [-speed n] [-addr host:port] file")
+	os.Exit(2)
+}
+
+// record 启动录制代理
+func record(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	listen := fs.String("listen", ":9000", "address to accept client connections on")
+	upstream := fs.String("upstream", "", "address of the server to forward traffic to")
+	dir := fs.String("dir", ".", "directory to write capture files to")
+	fs.Parse(args)
+	if *upstream == "" {
+		return errors.New("record: -upstream is required")
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	log.Printf("recording %s -> %s into %s", ln.Addr(), *upstream, *dir)
+
+	var seq atomic.Int64
+	for {
+		client, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		path := filepath.Join(*dir, fmt.Sprintf("conn-%d.imcap", seq.Add(1)))
+		go func() {
+			if err := proxy(client, *upstream, path); err != nil {
+				log.Printf("%s: %v", path, err)
+			}
+		}()
+	}
+}
+
+// proxy 在客户端与上游之间双向转发，并以客户端连接为视角记录流量
+func proxy(client net.Conn, upstream, path string) error {
+	defer client.Close()
+	server, err := net.Dial("tcp", upstream)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	capture, err := protocol.NewCaptureWriter(file)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s: recording %s", path, client.RemoteAddr())
+
+	recorded := protocol.NewCaptureConn(client, capture)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(server, recorded)
+		server.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(recorded, server)
+		client.Close()
+	}()
+	wg.Wait()
+	log.Printf("%s: closed", path)
+	return nil
+}
+
+// replay 回放抓包文件
+func replay(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	direction := fs.String("direction", "read", "direction to replay: read (sent by the client) or write (sent by the server)")
+	speed := fs.Float64("speed", 1, "playback speed multiplier, 0 replays without waiting")
+	addr := fs.String("addr", "", "send the replayed bytes to this address and print the decoded replies")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	player := &protocol.CapturePlayer{Speed: *speed}
+	switch *direction {
+	case "read":
+		player.Direction = protocol.CaptureRead
+	case "write":
+		player.Direction = protocol.CaptureWrite
+	default:
+		return fmt.Errorf("replay: unknown direction %q", *direction)
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := protocol.NewCaptureReader(file)
+	if err != nil {
+		return err
+	}
+
+	if *addr == "" {
+		decoder := protocol.NewStreamDecoder()
+		defer decoder.Release()
+		return player.PlayToDecoder(ctx, reader, decoder, func(record *protocol.CaptureRecord, f *protocol.Frame) error {
+			fmt.Printf("%s %s %s\n", record.Time.Format(time.RFC3339Nano), record.Direction, f)
+			return nil
+		})
+	}
+
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		decoder := protocol.NewStreamDecoder()
+		defer decoder.Release()
+		for {
+			f, err := decoder.ReadFrame(conn)
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+					log.Printf("reply: %v", err)
+				}
+				return
+			}
+			fmt.Printf("%s reply %s\n", time.Now().Format(time.RFC3339Nano), f)
+		}
+	}()
+	if err := player.PlayTo(ctx, reader, conn); err != nil {
+		return err
+	}
+
+	// 回放结束后关闭写端，等待服务端处理完剩余请求并返回回复
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+	}
+	return nil
+}
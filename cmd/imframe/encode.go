@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/myqiao/im-protocol/protocol"
+)
+
+// readBody 解析-body参数：以@开头时从文件读取（@-为标准输入），否则为字面文本
+func readBody(spec string, stdin io.Reader) ([]byte, error) {
+	path, ok := strings.CutPrefix(spec, "@")
+	if !ok {
+		return []byte(spec), nil
+	}
+	if path == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// encode 实现encode子命令：根据参数构造帧，输出编码结果或发送到服务端并打印回复
+func encode(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("imframe encode", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: imframe encode [flags]")
+		fs.PrintDefaults()
+	}
+	version := fs.Uint("version", uint(protocol.CurrentProtocolVersion), "protocol version")
+	subVersion := fs.Uint("subversion", 0, "protocol subversion")
+	frameType := fs.String("type", "json", "frame type: json, protobuf, msgpack or a number")
+	body := fs.String("body", "", "frame body text, or @file to read it from a file (@- for stdin)")
+	sequence := fs.Uint64("sequence", 0, "sender sequence number, 0 to omit")
+	key := fs.String("key", "", "sign the frame with keyID:hexkey")
+	format := fs.String("out", "hex", "output format: bin, hex or base64")
+	addr := fs.String("addr", "", "send the frame to this TCP address and print the decoded replies instead of the encoding")
+	wait := fs.Duration("wait", 2*time.Second, "how long to wait for replies with -addr")
+	jsonOut := fs.Bool("json", false, "print replies as JSON")
+	pretty := fs.Bool("pretty", false, "pretty-print JSON and MsgPack reply bodies")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("too many arguments")
+	}
+	if *version > 255 || *subVersion > 255 {
+		return errors.New("-version and -subversion must be 0-255")
+	}
+	t, ok := frameTypeNames[strings.ToLower(*frameType)]
+	if !ok {
+		v, err := strconv.ParseUint(*frameType, 10, 8)
+		if err != nil {
+			return fmt.Errorf("-type: unknown frame type %q", *frameType)
+		}
+		t = uint8(v)
+	}
+
+	data, err := readBody(*body, stdin)
+	if err != nil {
+		return fmt.Errorf("-body: %w", err)
+	}
+	frame, err := protocol.NewFrame(t, data, protocol.WithVersion(uint8(*version)), protocol.WithSubVersion(uint8(*subVersion)))
+	if err != nil {
+		return err
+	}
+	frame.Sequence = *sequence
+	var options []protocol.EncodeOption
+	var signer *protocol.FrameSigner
+	if *key != "" {
+		if signer, err = parseSigner(*key); err != nil {
+			return fmt.Errorf("-key: %w", err)
+		}
+		options = append(options, protocol.WithSigner(signer))
+	}
+	encoded, err := frame.Encode(options...)
+	if err != nil {
+		return err
+	}
+
+	if *addr != "" {
+		return send(*addr, encoded, *wait, &inspector{out: stdout, json: *jsonOut, pretty: *pretty}, signer)
+	}
+	switch *format {
+	case "bin":
+		_, err = stdout.Write(encoded)
+	case "hex":
+		_, err = fmt.Fprintln(stdout, hex.EncodeToString(encoded))
+	case "base64":
+		_, err = fmt.Fprintln(stdout, base64.StdEncoding.EncodeToString(encoded))
+	default:
+		err = fmt.Errorf("-out: unknown format %q", *format)
+	}
+	return err
+}
+
+// send 发送帧并在wait时间内打印服务端的回复
+// 使用签名密钥时回复也按同一密钥校验
+func send(addr string, encoded []byte, wait time.Duration, ins *inspector, signer *protocol.FrameSigner) error {
+	conn, err := net.DialTimeout("tcp", addr, wait)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(encoded); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(wait))
+
+	decoder := protocol.NewStreamDecoder()
+	defer decoder.Release()
+	if signer != nil {
+		decoder.SetDecodeOptions(protocol.WithSignatureVerifier(signer))
+	}
+	err = ins.run(decoder, conn)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/myqiao/im-protocol/protocol"
+)
+
+// TestEncode tests building frames from flags in each output format
+func TestEncode(t *testing.T) {
+	var out bytes.Buffer
+	if err := encode([]string{"-version", "2", "-subversion", "3", "-type", "msgpack", "-body", "@-", "-sequence", "7"}, strings.NewReader("\x81\xa1a\x01"), &out); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("Failed to decode hex output: %v", err)
+	}
+	frame, err := protocol.Decode(data)
+	if err != nil {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+	if frame.Version != 2 || frame.SubVersion != 3 || frame.Type != protocol.FrameTypeMsgPack || frame.Sequence != 7 || string(frame.Body) != "\x81\xa1a\x01" {
+		t.Errorf("Unexpected frame: %v", frame)
+	}
+
+	path := filepath.Join(t.TempDir(), "body.json")
+	os.WriteFile(path, []byte(`{"msg":"hi"}`), 0o644)
+	key := "1:" + hex.EncodeToString([]byte("secret"))
+	out.Reset()
+	if err := encode([]string{"-body", "@" + path, "-key", key, "-out", "base64"}, nil, &out); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	data, _ = base64.StdEncoding.DecodeString(strings.TrimSpace(out.String()))
+	signer, _ := protocol.NewFrameSigner(1, []byte("secret"))
+	frame, err = protocol.Decode(data, protocol.WithSignatureVerifier(signer))
+	if err != nil || !frame.Signed || string(frame.Body) != `{"msg":"hi"}` {
+		t.Errorf("Unexpected signed frame: %v, %v", frame, err)
+	}
+
+	out.Reset()
+	encode([]string{"-type", "protobuf", "-body", "x", "-out", "bin"}, nil, &out)
+	if out.Len() != protocol.FrameHeaderLength+1 {
+		t.Errorf("Expected raw binary output, got %q", out.Bytes())
+	}
+
+	if err := encode([]string{"-type", "99"}, nil, &out); err == nil {
+		t.Error("Expected error for an unsupported frame type")
+	}
+	if err := encode([]string{"-out", "yaml"}, nil, &out); err == nil {
+		t.Error("Expected error for an unknown output format")
+	}
+}
+
+// TestEncodeSend tests sending a frame and printing the decoded replies
+func TestEncodeSend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Echo the request twice, then keep the connection open until the client gives up
+		frame, err := protocol.NewStreamDecoder().ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		frame.EncodeTo(conn)
+		frame.EncodeTo(conn)
+		io.Copy(io.Discard, conn)
+	}()
+
+	var out bytes.Buffer
+	start := time.Now()
+	if err := encode([]string{"-body", `{"n":1}`, "-addr", ln.Addr().String(), "-wait", "200ms", "-pretty"}, nil, &out); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected to stop waiting after -wait, took %v", elapsed)
+	}
+	if strings.Count(out.String(), `"n": 1`) != 2 {
+		t.Errorf("Expected two pretty-printed replies, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/myqiao/im-protocol/protocol"
+)
+
+// frameTypeNames 帧类型名称，用于-type过滤和输出
+var frameTypeNames = map[string]uint8{
+	"json":     protocol.FrameTypeJSON,
+	"protobuf": protocol.FrameTypeProtobuf,
+	"msgpack":  protocol.FrameTypeMsgPack,
+}
+
+// typeName 返回帧类型名称，未知类型返回数字
+func typeName(frameType uint8) string {
+	for name, t := range frameTypeNames {
+		if t == frameType {
+			return name
+		}
+	}
+	return strconv.Itoa(int(frameType))
+}
+
+// frameFilter 按类型和版本过滤帧，集合为空表示不过滤
+type frameFilter struct {
+	types    map[uint8]bool
+	versions map[uint8]bool
+}
+
+// match 判断帧是否需要输出
+func (ff *frameFilter) match(f *protocol.Frame) bool {
+	if len(ff.types) > 0 && !ff.types[f.Type] {
+		return false
+	}
+	if len(ff.versions) > 0 && !ff.versions[f.Version] {
+		return false
+	}
+	return true
+}
+
+// parseByteSet 解析逗号分隔的列表，每项可以是名称（names中的键）或0-255的数字
+func parseByteSet(list string, names map[string]uint8) (map[uint8]bool, error) {
+	set := make(map[uint8]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if v, ok := names[item]; ok {
+			set[v] = true
+			continue
+		}
+		v, err := strconv.ParseUint(item, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", item)
+		}
+		set[uint8(v)] = true
+	}
+	return set, nil
+}
+
+// parseSigner 解析"keyID:hexkey"形式的签名密钥
+func parseSigner(spec string) (*protocol.FrameSigner, error) {
+	id, key, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, errors.New("key must be keyID:hexkey")
+	}
+	keyID, err := strconv.ParseUint(id, 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key ID %q", id)
+	}
+	secret, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return protocol.NewFrameSigner(uint8(keyID), secret)
+}
+
+// decodeHexText 解码十六进制文本，忽略空白和可选的0x前缀
+func decodeHexText(text string) ([]byte, error) {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")
+	return hex.DecodeString(text)
+}
+
+// frameOutput -json模式下单个帧的输出格式
+type frameOutput struct {
+	// Offset 帧在输入流中的字节偏移
+	Offset int64 `json:"offset"`
+	// Length 帧在线路上的总长度
+	Length int `json:"length"`
+	// Version 版本号
+	Version uint8 `json:"version"`
+	// SubVersion 子版本号
+	SubVersion uint8 `json:"subversion"`
+	// Type 帧类型名称
+	Type string `json:"type"`
+	// Signed 是否通过了签名校验
+	Signed bool `json:"signed,omitempty"`
+	// KeyID 签名密钥ID
+	KeyID uint8 `json:"key_id,omitempty"`
+	// Sequence 序列号
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Body 消息体文本，非UTF-8时省略
+	Body *string `json:"body,omitempty"`
+	// BodyHex 消息体十六进制
+	BodyHex string `json:"body_hex"`
+	// Decoded 按帧类型解析后的消息体，仅-pretty时输出
+	Decoded any `json:"decoded,omitempty"`
+}
+
+// errorOutput -json模式下解码错误的输出格式
+type errorOutput struct {
+	// Offset 出错位置在输入流中的字节偏移
+	Offset int64 `json:"offset"`
+	// Error 错误描述
+	Error string `json:"error"`
+	// Code 协议错误码，非协议错误时为0
+	Code protocol.ErrorCode `json:"code,omitempty"`
+}
+
+// inspector 解码输入流并按选项输出帧
+type inspector struct {
+	out    io.Writer
+	filter frameFilter
+	json   bool
+	pretty bool
+	dump   bool
+}
+
+// inspect 实现检查命令
+func inspect(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("imframe", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: imframe [flags] [file|-]")
+		fs.PrintDefaults()
+	}
+	hexInput := fs.String("hex", "", "decode frames from this hex string instead of a file")
+	hexText := fs.Bool("x", false, "treat the file or stdin as hex text")
+	types := fs.String("type", "", "only print these frame types (comma separated names or numbers)")
+	versions := fs.String("version", "", "only print these protocol versions (comma separated)")
+	key := fs.String("key", "", "verify signed frames with keyID:hexkey")
+	jsonOut := fs.Bool("json", false, "print one JSON object per frame")
+	pretty := fs.Bool("pretty", false, "pretty-print JSON and MsgPack bodies")
+	dump := fs.Bool("dump", false, "print a hex dump of each frame")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ins := &inspector{out: stdout, json: *jsonOut, pretty: *pretty, dump: *dump}
+	var err error
+	if ins.filter.types, err = parseByteSet(*types, frameTypeNames); err != nil {
+		return fmt.Errorf("-type: %w", err)
+	}
+	if ins.filter.versions, err = parseByteSet(*versions, nil); err != nil {
+		return fmt.Errorf("-version: %w", err)
+	}
+	decoder := protocol.NewStreamDecoder()
+	defer decoder.Release()
+	if *key != "" {
+		signer, err := parseSigner(*key)
+		if err != nil {
+			return fmt.Errorf("-key: %w", err)
+		}
+		decoder.SetDecodeOptions(protocol.WithSignatureVerifier(signer))
+	}
+
+	var input io.Reader
+	switch {
+	case *hexInput != "":
+		data, err := decodeHexText(*hexInput)
+		if err != nil {
+			return fmt.Errorf("-hex: %w", err)
+		}
+		input = bytes.NewReader(data)
+	case fs.NArg() > 1:
+		fs.Usage()
+		return errors.New("too many arguments")
+	case fs.NArg() == 1 && fs.Arg(0) != "-":
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	default:
+		input = stdin
+	}
+	if *hexText && *hexInput == "" {
+		text, err := io.ReadAll(input)
+		if err != nil {
+			return err
+		}
+		data, err := decodeHexText(string(text))
+		if err != nil {
+			return fmt.Errorf("hex input: %w", err)
+		}
+		input = bytes.NewReader(data)
+	}
+	return ins.run(decoder, input)
+}
+
+// run 分块读取输入并解码，边读边输出，适合处理管道中的实时流
+// 帧级错误（如签名错误）跳过该帧继续；帧头错误无法确定帧边界，停止解码
+func (ins *inspector) run(decoder *protocol.StreamDecoder, input io.Reader) error {
+	w := bufio.NewWriter(ins.out)
+	defer w.Flush()
+	ins.out = w
+
+	maxBuffered := protocol.MaxMessageLength + protocol.FrameHeaderLength
+	buf := make([]byte, 64*1024)
+	var fed int64
+	for {
+		room := min(len(buf), maxBuffered-decoder.Buffered())
+		n, readErr := input.Read(buf[:room])
+		if n > 0 {
+			decoder.Feed(buf[:n])
+			fed += int64(n)
+		}
+		for {
+			offset := fed - int64(decoder.Buffered())
+			frame, err := decoder.TryDecode()
+			length := int(fed - int64(decoder.Buffered()) - offset)
+			if err != nil {
+				ins.printError(offset, err)
+				if length == 0 {
+					return fmt.Errorf("cannot resynchronize after error at offset %d", offset)
+				}
+				continue
+			}
+			if frame == nil {
+				break
+			}
+			if ins.filter.match(frame) {
+				if err := ins.printFrame(offset, length, frame); err != nil {
+					return err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			if rest := decoder.Buffered(); rest > 0 {
+				ins.printError(fed-int64(rest), fmt.Errorf("%d trailing bytes do not form a complete frame", rest))
+			}
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// printFrame 输出一个帧
+func (ins *inspector) printFrame(offset int64, length int, f *protocol.Frame) error {
+	var decoded any
+	if ins.pretty {
+		decoded = decodeBody(f)
+	}
+
+	if ins.json {
+		out := frameOutput{
+			Offset:     offset,
+			Length:     length,
+			Version:    f.Version,
+			SubVersion: f.SubVersion,
+			Type:       typeName(f.Type),
+			Signed:     f.Signed,
+			KeyID:      f.KeyID,
+			Sequence:   f.Sequence,
+			BodyHex:    hex.EncodeToString(f.Body),
+			Decoded:    decoded,
+		}
+		if utf8.Valid(f.Body) {
+			body := string(f.Body)
+			out.Body = &body
+		}
+		return json.NewEncoder(ins.out).Encode(out)
+	}
+
+	fmt.Fprintf(ins.out, "@%d %s", offset, f)
+	if f.Signed {
+		fmt.Fprintf(ins.out, " signed(key %d)", f.KeyID)
+	}
+	if f.Sequence != 0 {
+		fmt.Fprintf(ins.out, " seq=%d", f.Sequence)
+	}
+	fmt.Fprintln(ins.out)
+	if decoded != nil {
+		text, _ := json.MarshalIndent(decoded, "  ", "  ")
+		fmt.Fprintf(ins.out, "  %s\n", text)
+	}
+	if ins.dump {
+		return f.PrettyPrint(ins.out)
+	}
+	return nil
+}
+
+// printError 输出解码错误
+func (ins *inspector) printError(offset int64, err error) {
+	if ins.json {
+		json.NewEncoder(ins.out).Encode(errorOutput{Offset: offset, Error: err.Error(), Code: protocol.GetErrorCode(err)})
+		return
+	}
+	fmt.Fprintf(ins.out, "@%d error: %v\n", offset, err)
+}
+
+// decodeBody 按帧类型解析消息体，无法解析或无法以JSON输出（如MsgPack中的NaN）时返回nil
+func decodeBody(f *protocol.Frame) any {
+	var v any
+	var err error
+	switch f.Type {
+	case protocol.FrameTypeJSON:
+		err = json.Unmarshal(f.Body, &v)
+	case protocol.FrameTypeMsgPack:
+		v, err = decodeMsgPack(f.Body)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/myqiao/im-protocol/protocol"
+)
+
+// encodeTestFrame encodes a frame or fails the test
+func encodeTestFrame(t *testing.T, frameType uint8, body []byte, options ...protocol.ConstructorOption) []byte {
+	t.Helper()
+	frame, err := protocol.NewFrame(frameType, body, options...)
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+	return data
+}
+
+// TestInspect tests decoding a mixed stream with filters, JSON output and error recovery
+func TestInspect(t *testing.T) {
+	signer, _ := protocol.NewFrameSigner(1, []byte("secret"))
+	signedFrame, _ := protocol.NewFrame(protocol.FrameTypeJSON, []byte(`{}`))
+	signed, _ := signedFrame.Encode(protocol.WithSigner(signer))
+
+	var stream []byte
+	stream = append(stream, encodeTestFrame(t, protocol.FrameTypeJSON, []byte(`{"msg":"hi"}`))...)
+	// {"n": 1, "ok": true} in MsgPack
+	stream = append(stream, encodeTestFrame(t, protocol.FrameTypeMsgPack, []byte{0x82, 0xa1, 'n', 0x01, 0xa2, 'o', 'k', 0xc3}, protocol.WithVersion(protocol.ProtocolVersionV2))...)
+	stream = append(stream, signed...)
+	stream = append(stream, encodeTestFrame(t, protocol.FrameTypeProtobuf, []byte{0x08, 0x01})...)
+	stream = append(stream, 0x01, 0x00)
+
+	var out bytes.Buffer
+	if err := inspect([]string{"-json", "-pretty", "-hex", hex.EncodeToString(stream)}, nil, &out); err != nil {
+		t.Fatalf("Failed to inspect: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 3 frames and 2 errors, got:\n%s", out.String())
+	}
+	var msgpack frameOutput
+	json.Unmarshal([]byte(lines[1]), &msgpack)
+	if msgpack.Type != "msgpack" || msgpack.Version != 2 || fmt.Sprint(msgpack.Decoded) != "map[n:1 ok:true]" {
+		t.Errorf("Unexpected MsgPack frame: %s", lines[1])
+	}
+	var signedErr errorOutput
+	json.Unmarshal([]byte(lines[2]), &signedErr)
+	if signedErr.Code != protocol.ErrCodeInvalidSignature || signedErr.Offset != int64(len(stream)-len(signed)-9-2) {
+		t.Errorf("Unexpected signature error: %s", lines[2])
+	}
+	var protobuf frameOutput
+	json.Unmarshal([]byte(lines[3]), &protobuf)
+	if protobuf.Type != "protobuf" || protobuf.BodyHex != "0801" || protobuf.Decoded != nil {
+		t.Errorf("Unexpected Protobuf frame: %s", lines[3])
+	}
+	if !strings.Contains(lines[4], "trailing bytes") {
+		t.Errorf("Expected trailing bytes error, got %s", lines[4])
+	}
+
+	// Filters and signature verification
+	out.Reset()
+	args := []string{"-x", "-type", "json", "-version", "1", "-key", "1:" + hex.EncodeToString([]byte("secret"))}
+	if err := inspect(args, strings.NewReader(hex.EncodeToString(signed)), &out); err != nil {
+		t.Fatalf("Failed to inspect: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "@0 Frame{") || !strings.Contains(out.String(), "signed(key 1)") {
+		t.Errorf("Unexpected text output: %q", out.String())
+	}
+
+	// An unsupported version cannot be skipped
+	if err := inspect([]string{"-hex", "09000100000000"}, nil, &out); err == nil {
+		t.Error("Expected error for an unsupported version")
+	}
+}
+
+// TestDecodeMsgPack tests the MsgPack reader used for pretty-printing
+func TestDecodeMsgPack(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want string
+	}{
+		{"c0", "<nil>"},
+		{"ff", "-1"},
+		{"d0 80", "-128"},
+		{"d1 ff 00", "-256"},
+		{"cd 01 00", "256"},
+		{"cb 3f f8 00 00 00 00 00 00", "1.5"},
+		{"a3 61 62 63", "abc"},
+		{"c4 02 01 02", "[1 2]"},
+		{"92 01 a1 78", "[1 x]"},
+		{"dc 00 01 c2", "[false]"},
+		{"81 01 c3", "map[1:true]"},
+		{"d4 05 aa", "map[data:[170] ext:5]"},
+	}
+	for _, tt := range tests {
+		data, _ := decodeHexText(tt.hex)
+		v, err := decodeMsgPack(data)
+		if err != nil {
+			t.Errorf("Failed to decode %s: %v", tt.hex, err)
+			continue
+		}
+		if got := fmt.Sprint(v); got != tt.want {
+			t.Errorf("Decode %s: expected %s, got %s", tt.hex, tt.want, got)
+		}
+	}
+
+	for _, bad := range []string{"", "a3 61", "c1", "dd ff ff ff ff", "01 02", strings.Repeat("91", 100) + "c0"} {
+		data, _ := decodeHexText(bad)
+		if _, err := decodeMsgPack(data); err == nil {
+			t.Errorf("Expected error decoding %q", bad)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// imframe 帧检查与构造工具
+//
+// 默认从十六进制字符串、二进制文件或标准输入读取字节流，用StreamDecoder解码出所有帧并打印
+//
+//	imframe -hex 0100010000000c7b226d7367223a226869227d
+//	imframe -pretty -type json,msgpack capture.bin
+//	nc 127.0.0.1 8000 | imframe -json
+//
+// encode子命令根据参数构造帧，输出编码结果，或发送到服务端并打印解码后的回复
+//
+//	imframe encode -type json -body '{"msg":"hi"}'
+//	imframe encode -version 2 -type msgpack -body @body.bin -out base64
+//	imframe encode -body @request.json -addr 127.0.0.1:8000 -pretty
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	run := inspect
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "encode" {
+		run, args = encode, args[1:]
+	}
+	if err := run(args, os.Stdin, os.Stdout); errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "imframe:", err)
+		os.Exit(1)
+	}
+}
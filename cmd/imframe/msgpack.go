@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// 本库不引入第三方依赖，这里实现一个只读的MsgPack解析器，仅用于把消息体转换为可JSON输出的值：
+// map的键转换为字符串，bin转换为[]byte（JSON中为base64），ext转换为{"ext":类型,"data":数据}
+
+// maxMsgPackDepth 最大嵌套深度，防止恶意数据导致栈溢出
+const maxMsgPackDepth = 64
+
+// errMsgPackShort 数据不完整
+var errMsgPackShort = errors.New("msgpack: unexpected end of data")
+
+// decodeMsgPack 解析单个MsgPack值，数据必须恰好包含一个值
+func decodeMsgPack(data []byte) (any, error) {
+	d := &msgpackDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(data)-d.pos)
+	}
+	return v, nil
+}
+
+// msgpackDecoder MsgPack解析状态
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next 读取n个字节
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgPackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint 读取n字节的大端无符号整数
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// value 解析一个值
+func (d *msgpackDecoder) value(depth int) (any, error) {
+	if depth > maxMsgPackDepth {
+		return nil, errors.New("msgpack: nesting too deep")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapValue(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.array(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.bin(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		v, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (c - 0xcc))
+		return v, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		v, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// 符号扩展
+		shift := 64 - 8*size
+		return int64(v<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(min(n, math.MaxInt32)))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%02x", c)
+}
+
+// str 读取长度为n的字符串
+func (d *msgpackDecoder) str(n int) (any, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// bin 读取长度为n的二进制数据
+func (d *msgpackDecoder) bin(n uint64) (any, error) {
+	b, err := d.next(int(min(n, math.MaxInt32)))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// ext 读取数据长度为n的扩展类型
+func (d *msgpackDecoder) ext(n uint64) (any, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.bin(n)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"ext": int8(t[0]), "data": data}, nil
+}
+
+// array 读取n个元素的数组
+func (d *msgpackDecoder) array(n, depth int) (any, error) {
+	// 每个元素至少1字节，避免按伪造的长度预分配
+	if n > len(d.data)-d.pos {
+		return nil, errMsgPackShort
+	}
+	items := make([]any, n)
+	for i := range items {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+// mapValue 读取n个键值对的map，键转换为字符串
+func (d *msgpackDecoder) mapValue(n, depth int) (any, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, errMsgPackShort
+	}
+	m := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		m[key] = v
+	}
+	return m, nil
+}
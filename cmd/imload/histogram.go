@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+// 延迟直方图采用对数分桶：按2的幂分组，每组再等分为histogramSubBuckets个子桶，
+// 相对误差不超过1/histogramSubBuckets，内存固定，适合记录数百万个样本
+const (
+	// histogramSubBits 每组子桶数的位数
+	histogramSubBits = 3
+	// histogramSubBuckets 每组子桶数
+	histogramSubBuckets = 1 << histogramSubBits
+	// histogramBuckets 桶总数，覆盖全部int64纳秒范围
+	histogramBuckets = (64 - histogramSubBits + 1) * histogramSubBuckets
+)
+
+// histogram 纳秒级延迟直方图
+//
+// 并发安全说明：
+// 非并发安全，每个客户端使用自己的直方图，结束后用merge合并
+type histogram struct {
+	// counts 各桶的样本数
+	counts [histogramBuckets]uint64
+	// total 样本总数
+	total uint64
+	// sum 样本总和
+	sum time.Duration
+	// min 最小值
+	min time.Duration
+	// max 最大值
+	max time.Duration
+}
+
+// bucketOf 返回d所在的桶
+func bucketOf(d time.Duration) int {
+	v := uint64(max(d, 0))
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	// 最高位所在的组，加上紧随最高位之后的histogramSubBits位作为子桶
+	shift := bits.Len64(v) - histogramSubBits - 1
+	return (shift+1)*histogramSubBuckets + int(v>>shift)&(histogramSubBuckets-1)
+}
+
+// bucketUpper 返回桶的上界（不含）
+func bucketUpper(b int) time.Duration {
+	if b < histogramSubBuckets {
+		return time.Duration(b + 1)
+	}
+	shift := b/histogramSubBuckets - 1
+	sub := uint64(b%histogramSubBuckets) + 1
+	return time.Duration((histogramSubBuckets + sub) << shift)
+}
+
+// record 记录一个样本
+func (h *histogram) record(d time.Duration) {
+	h.counts[bucketOf(d)]++
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+	h.sum += d
+}
+
+// merge 合并另一个直方图
+func (h *histogram) merge(o *histogram) {
+	if o.total == 0 {
+		return
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.total == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	h.max = max(h.max, o.max)
+	h.total += o.total
+	h.sum += o.sum
+}
+
+// mean 返回平均值
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// percentile 返回第p百分位（0-100）的近似值，取所在桶的上界且不超过最大值
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(p / 100 * float64(h.total))
+	if rank >= h.total {
+		return h.max
+	}
+	var seen uint64
+	for b, c := range h.counts {
+		seen += c
+		if seen > rank {
+			return min(bucketUpper(b), h.max)
+		}
+	}
+	return h.max
+}
+
+// print 输出百分位统计和按2的幂合并的分布图
+func (h *histogram) print(w io.Writer) {
+	fmt.Fprintf(w, "latency: min %v  mean %v  p50 %v  p90 %v  p99 %v  p99.9 %v  max %v\n",
+		h.min, h.mean(), h.percentile(50), h.percentile(90), h.percentile(99), h.percentile(99.9), h.max)
+	if h.total == 0 {
+		return
+	}
+
+	// 每组的子桶合并为一行
+	groups := histogramBuckets / histogramSubBuckets
+	counts := make([]uint64, groups)
+	var peak uint64
+	for b, c := range h.counts {
+		counts[b/histogramSubBuckets] += c
+		peak = max(peak, counts[b/histogramSubBuckets])
+	}
+	first, last := groups, 0
+	for g, c := range counts {
+		if c > 0 {
+			first, last = min(first, g), g
+		}
+	}
+	const width = 40
+	for g := first; g <= last; g++ {
+		upper := bucketUpper((g+1)*histogramSubBuckets - 1)
+		bar := strings.Repeat("#", int(counts[g]*width/peak))
+		fmt.Fprintf(w, "  < %-10v %10d %6.2f%% %s\n", upper, counts[g], float64(counts[g])*100/float64(h.total), bar)
+	}
+}
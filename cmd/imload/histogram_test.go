@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHistogramBuckets tests that bucket boundaries are contiguous and bound the relative error
+func TestHistogramBuckets(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, 7, 8, 15, 16, 1000, time.Millisecond, 1234567, time.Minute} {
+		b := bucketOf(d)
+		if d >= bucketUpper(b) || (b > 0 && d < bucketUpper(b-1)) {
+			t.Errorf("%v is outside bucket %d [%v, %v)", d, b, bucketUpper(b-1), bucketUpper(b))
+		}
+		if d >= histogramSubBuckets && float64(bucketUpper(b)-d) > float64(d)/histogramSubBuckets {
+			t.Errorf("Bucket upper bound %v too far from %v", bucketUpper(b), d)
+		}
+	}
+}
+
+// TestHistogramPercentiles tests percentiles, merging and the printed report
+func TestHistogramPercentiles(t *testing.T) {
+	var a, b histogram
+	for i := 1; i <= 900; i++ {
+		a.record(time.Duration(i) * time.Microsecond)
+	}
+	for i := 0; i < 100; i++ {
+		b.record(50 * time.Millisecond)
+	}
+	a.merge(&b)
+
+	if a.total != 1000 || a.min != time.Microsecond || a.max != 50*time.Millisecond {
+		t.Fatalf("Unexpected merged stats: total %d min %v max %v", a.total, a.min, a.max)
+	}
+	if p50 := a.percentile(50); p50 < 500*time.Microsecond || p50 > 563*time.Microsecond {
+		t.Errorf("Expected p50 near 500µs, got %v", p50)
+	}
+	if p99 := a.percentile(99); p99 != 50*time.Millisecond {
+		t.Errorf("Expected p99 capped at the max, got %v", p99)
+	}
+
+	var out strings.Builder
+	a.print(&out)
+	if !strings.Contains(out.String(), "p99 50ms") || strings.Count(out.String(), "\n") < 3 {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+}
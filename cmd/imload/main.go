@@ -0,0 +1,283 @@
+// imload 本地回环压测工具
+//
+// 在进程内启动一个回显服务端（也可以用-addr指定外部服务端），再启动N个客户端通过回环地址发送帧，
+// 统计端到端的吞吐量、往返延迟分布和内存分配，用于复现README中的性能数据并评估改动的影响
+//
+//	imload -clients 16 -size 256 -duration 10s
+//	imload -clients 4 -rate 1000 -type msgpack -version 2
+//	imload -addr 10.0.0.5:9000 -clients 64 -pipeline 8
+//
+// 说明：
+//   - -rate为0时每个客户端以闭环方式尽快发送，最多有-pipeline个帧在途
+//   - -rate大于0时按固定间隔发送，延迟从计划发送时间算起，避免服务端变慢时少算排队时间
+//   - 使用内置服务端时，分配统计同时包含服务端和客户端
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/myqiao/im-protocol/protocol"
+)
+
+// loadConfig 压测参数
+type loadConfig struct {
+	// addr 服务端地址，为空时启动内置回显服务端
+	addr string
+	// clients 客户端连接数
+	clients int
+	// duration 压测时长
+	duration time.Duration
+	// size 消息体大小
+	size int
+	// frameType 帧类型
+	frameType uint8
+	// version 协议版本
+	version uint8
+	// rate 每个客户端每秒发送的帧数，0表示尽快发送
+	rate float64
+	// pipeline 每个客户端最多在途的帧数
+	pipeline int
+}
+
+// clientResult 单个客户端的统计
+type clientResult struct {
+	// latency 往返延迟
+	latency histogram
+	// sent 发送的帧数
+	sent uint64
+	// err 导致客户端提前退出的错误
+	err error
+}
+
+func main() {
+	var cfg loadConfig
+	var frameType string
+	var version uint
+	flag.StringVar(&cfg.addr, "addr", "", "echo server to load, empty to start one in-process on loopback")
+	flag.IntVar(&cfg.clients, "clients", runtime.GOMAXPROCS(0), "number of client connections")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to send for")
+	flag.IntVar(&cfg.size, "size", 256, "frame body size in bytes")
+	flag.StringVar(&frameType, "type", "json", "frame type: json, protobuf, msgpack or a number")
+	flag.UintVar(&version, "version", uint(protocol.CurrentProtocolVersion), "protocol version")
+	flag.Float64Var(&cfg.rate, "rate", 0, "frames per second per client, 0 sends as fast as possible")
+	flag.IntVar(&cfg.pipeline, "pipeline", 1, "maximum frames in flight per client")
+	flag.Parse()
+
+	switch strings.ToLower(frameType) {
+	case "json":
+		cfg.frameType = protocol.FrameTypeJSON
+	case "protobuf":
+		cfg.frameType = protocol.FrameTypeProtobuf
+	case "msgpack":
+		cfg.frameType = protocol.FrameTypeMsgPack
+	default:
+		v, err := strconv.ParseUint(frameType, 10, 8)
+		if err != nil {
+			log.Fatalf("unknown frame type %q", frameType)
+		}
+		cfg.frameType = uint8(v)
+	}
+	cfg.version = uint8(version)
+	if cfg.clients < 1 || cfg.pipeline < 1 || cfg.size < 0 || cfg.size > protocol.MaxMessageLength || version > 255 {
+		log.Fatal("invalid -clients, -pipeline, -size or -version")
+	}
+
+	if err := runLoad(cfg, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runLoad 执行压测并输出报告
+func runLoad(cfg loadConfig, out io.Writer) error {
+	frame, err := protocol.NewFrame(cfg.frameType, makeBody(cfg.frameType, cfg.size), protocol.WithVersion(cfg.version))
+	if err != nil {
+		return err
+	}
+	encoded, err := frame.Encode()
+	if err != nil {
+		return err
+	}
+	wireSize := len(encoded)
+
+	addr := cfg.addr
+	if addr == "" {
+		srv, ln, err := startEchoServer()
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+		addr = ln.Addr().String()
+	}
+
+	conns := make([]*protocol.Conn, cfg.clients)
+	for i := range conns {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conns[i], err = protocol.Dial(ctx, addr, nil)
+		cancel()
+		if err != nil {
+			return err
+		}
+		defer conns[i].Close()
+	}
+
+	fmt.Fprintf(out, "%d clients -> %s, %d byte frames (type %d, v%d), ", cfg.clients, addr, wireSize, cfg.frameType, cfg.version)
+	if cfg.rate > 0 {
+		fmt.Fprintf(out, "%.0f frames/s per client, %v\n", cfg.rate, cfg.duration)
+	} else {
+		fmt.Fprintf(out, "closed loop with %d in flight, %v\n", cfg.pipeline, cfg.duration)
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	deadline := start.Add(cfg.duration)
+
+	results := make([]*clientResult, cfg.clients)
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		results[i] = &clientResult{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runClient(conn, frame, cfg, deadline, results[i])
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	var total histogram
+	var sent uint64
+	for i, r := range results {
+		total.merge(&r.latency)
+		sent += r.sent
+		if r.err != nil {
+			fmt.Fprintf(out, "client %d: %v\n", i, r.err)
+		}
+	}
+	received := total.total
+	seconds := elapsed.Seconds()
+	fmt.Fprintf(out, "sent %d, received %d in %v\n", sent, received, elapsed.Round(time.Millisecond))
+	fmt.Fprintf(out, "throughput: %.0f frames/s, %.2f MB/s each way\n",
+		float64(received)/seconds, float64(received)*float64(wireSize)/seconds/1e6)
+	total.print(out)
+	if received > 0 {
+		mallocs := after.Mallocs - before.Mallocs
+		bytes := after.TotalAlloc - before.TotalAlloc
+		fmt.Fprintf(out, "allocations: %.1f allocs/frame, %.0f B/frame, %d GCs (%v pause)\n",
+			float64(mallocs)/float64(received), float64(bytes)/float64(received),
+			after.NumGC-before.NumGC, time.Duration(after.PauseTotalNs-before.PauseTotalNs))
+	}
+	return nil
+}
+
+// startEchoServer 在回环地址上启动回显服务端
+func startEchoServer() (*protocol.Server, *protocol.Listener, error) {
+	ln, err := protocol.Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv := protocol.NewServer(protocol.ServerConfig{})
+	srv.HandleDefault(protocol.HandlerFunc(func(ctx context.Context, w protocol.FrameWriter, f *protocol.Frame) error {
+		return w.WriteFrame(f)
+	}))
+	go srv.Serve(ln)
+	return srv, ln, nil
+}
+
+// makeBody 生成指定大小的消息体，JSON类型生成合法的JSON文本
+func makeBody(frameType uint8, size int) []byte {
+	body := make([]byte, size)
+	for i := range body {
+		body[i] = 'a' + byte(i%26)
+	}
+	if frameType == protocol.FrameTypeJSON && size >= 8 {
+		copy(body, `{"p":"`)
+		copy(body[size-2:], `"}`)
+	}
+	return body
+}
+
+// runClient 在一个连接上发送帧直到deadline，并记录每个回复的往返延迟
+// 服务端按顺序回显，因此用先进先出的队列匹配发送时间
+func runClient(conn *protocol.Conn, frame *protocol.Frame, cfg loadConfig, deadline time.Time, result *clientResult) {
+	// slots 限制在途帧数，接收方记录完回复的延迟后归还
+	slots := make(chan struct{}, cfg.pipeline)
+	inflight := make(chan time.Time, cfg.pipeline)
+	done := make(chan struct{})
+	senderDone := make(chan struct{})
+
+	go func() {
+		defer close(senderDone)
+		defer close(inflight)
+		var interval time.Duration
+		if cfg.rate > 0 {
+			interval = time.Duration(float64(time.Second) / cfg.rate)
+		}
+		next := time.Now()
+		for {
+			var sent time.Time
+			if interval > 0 {
+				if !next.Before(deadline) {
+					return
+				}
+				if wait := time.Until(next); wait > 0 {
+					time.Sleep(wait)
+				}
+				// 以计划发送时间计算延迟
+				sent = next
+				next = next.Add(interval)
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			if interval == 0 {
+				// 闭环模式在取得在途名额后、写入前计时，不把等待回复的时间算入下一帧的延迟
+				if sent = time.Now(); !sent.Before(deadline) {
+					return
+				}
+			}
+			inflight <- sent
+			if err := conn.WriteFrame(frame); err != nil {
+				return
+			}
+			result.sent++
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-senderDone
+	}()
+	for sent := range inflight {
+		reply, err := conn.ReadFrame()
+		if err == nil && len(reply.Body) != len(frame.Body) {
+			err = fmt.Errorf("unexpected reply body length %d: %.80q", len(reply.Body), reply.Body)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				result.err = err
+			}
+			// 关闭连接使阻塞在写入上的发送协程退出
+			conn.Close()
+			return
+		}
+		result.latency.record(time.Since(sent))
+		<-slots
+	}
+}
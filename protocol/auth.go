@@ -0,0 +1,523 @@
+package protocol
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 认证消息种类
+const (
+	// AuthKindRequest 客户端发起认证请求
+	AuthKindRequest = "request"
+	// AuthKindChallenge 服务端下发挑战
+	AuthKindChallenge = "challenge"
+	// AuthKindResponse 客户端响应挑战
+	AuthKindResponse = "response"
+	// AuthKindSuccess 认证成功
+	AuthKindSuccess = "success"
+	// AuthKindFailure 认证失败
+	AuthKindFailure = "failure"
+)
+
+// 内置认证方式
+const (
+	// AuthMethodToken 令牌认证
+	AuthMethodToken = "token"
+	// AuthMethodPassword 用户名/密码认证
+	AuthMethodPassword = "password"
+	// AuthMethodHMAC HMAC挑战-响应认证
+	AuthMethodHMAC = "hmac"
+	// AuthMethodSession 会话令牌恢复，见SessionStore
+	AuthMethodSession = "session"
+)
+
+// AuthMessage 认证阶段的消息体
+// 以JSON编码承载于FrameTypeJSON帧中，通过"auth"字段与业务帧区分
+//
+// 交互流程：
+//
+//	令牌/密码：  request → success | failure
+//	HMAC挑战：   request → challenge → response → success | failure
+type AuthMessage struct {
+	// Kind 消息种类，取值为AuthKind*常量
+	Kind string `json:"auth"`
+	// Method 认证方式，取值为AuthMethod*常量或自定义方式
+	Method string `json:"method,omitempty"`
+	// Token 令牌认证使用的令牌
+	Token string `json:"token,omitempty"`
+	// Username 用户名
+	Username string `json:"username,omitempty"`
+	// Password 密码认证使用的密码
+	Password string `json:"password,omitempty"`
+	// Challenge 服务端下发的挑战数据
+	Challenge []byte `json:"challenge,omitempty"`
+	// Response 客户端对挑战的响应数据
+	Response []byte `json:"response,omitempty"`
+	// Principal 认证成功后的主体ID
+	Principal string `json:"principal,omitempty"`
+	// Reason 认证失败原因
+	Reason string `json:"reason,omitempty"`
+	// Session 会话令牌：成功消息中为服务端签发的令牌，请求中为待恢复的令牌
+	Session string `json:"session,omitempty"`
+}
+
+// Frame 将认证消息编码为FrameTypeJSON帧
+func (m *AuthMessage) Frame() (*Frame, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return NewFrame(FrameTypeJSON, body, WithZeroCopy(true))
+}
+
+// ParseAuthMessage 从帧中解析认证消息
+// 帧不是认证帧时返回ErrCodeUnauthenticated错误
+func ParseAuthMessage(f *Frame) (*AuthMessage, error) {
+	if f.Type != FrameTypeJSON {
+		return nil, NewUnauthenticatedError(fmt.Sprintf("frame type %d is not an auth frame", f.Type))
+	}
+
+	msg := &AuthMessage{}
+	if err := json.Unmarshal(f.Body, msg); err != nil || msg.Kind == "" {
+		return nil, NewUnauthenticatedError("frame body is not an auth message")
+	}
+	return msg, nil
+}
+
+// Principal 认证成功后的主体，附加在连接上供后续处理使用
+type Principal struct {
+	// ID 主体ID，通常为用户ID
+	ID string
+	// Method 完成认证所用的方式
+	Method string
+	// Attributes 认证器附加的属性（如角色、租户等）
+	Attributes map[string]string
+}
+
+// principalContextKey 用于在context中存放Principal的键
+type principalContextKey struct{}
+
+// ContextWithPrincipal 返回携带Principal的context
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// PrincipalFromContext 从context中取出Principal，不存在时返回nil
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// Authenticator 服务端认证器接口
+// 每种认证方式实现一个Authenticator，注册到ServerHandshake中
+type Authenticator interface {
+	// Method 返回认证方式名称，与AuthMessage.Method匹配
+	Method() string
+	// Begin 处理认证请求
+	// 返回非nil的Principal表示认证完成；返回非nil的challenge表示需要客户端响应
+	Begin(ctx context.Context, req *AuthMessage) (principal *Principal, challenge []byte, err error)
+	// Continue 处理客户端对challenge的响应，返回认证结果
+	Continue(ctx context.Context, req *AuthMessage, challenge []byte, resp *AuthMessage) (*Principal, error)
+}
+
+// TokenAuthenticator 令牌认证器
+type TokenAuthenticator struct {
+	// Validate 校验令牌并返回对应的主体
+	Validate func(ctx context.Context, token string) (*Principal, error)
+}
+
+// Method 实现Authenticator接口
+func (a *TokenAuthenticator) Method() string {
+	return AuthMethodToken
+}
+
+// Begin 实现Authenticator接口
+func (a *TokenAuthenticator) Begin(ctx context.Context, req *AuthMessage) (*Principal, []byte, error) {
+	if req.Token == "" {
+		return nil, nil, NewAuthFailedError("token is empty")
+	}
+	p, err := a.Validate(ctx, req.Token)
+	return p, nil, err
+}
+
+// Continue 实现Authenticator接口，令牌认证没有挑战步骤
+func (a *TokenAuthenticator) Continue(ctx context.Context, req *AuthMessage, challenge []byte, resp *AuthMessage) (*Principal, error) {
+	return nil, NewAuthFailedError("token authentication has no challenge step")
+}
+
+// PasswordAuthenticator 用户名/密码认证器
+// 密码以明文传输，应配合TLS使用
+type PasswordAuthenticator struct {
+	// Verify 校验用户名和密码并返回对应的主体
+	Verify func(ctx context.Context, username, password string) (*Principal, error)
+}
+
+// Method 实现Authenticator接口
+func (a *PasswordAuthenticator) Method() string {
+	return AuthMethodPassword
+}
+
+// Begin 实现Authenticator接口
+func (a *PasswordAuthenticator) Begin(ctx context.Context, req *AuthMessage) (*Principal, []byte, error) {
+	if req.Username == "" {
+		return nil, nil, NewAuthFailedError("username is empty")
+	}
+	p, err := a.Verify(ctx, req.Username, req.Password)
+	return p, nil, err
+}
+
+// Continue 实现Authenticator接口，密码认证没有挑战步骤
+func (a *PasswordAuthenticator) Continue(ctx context.Context, req *AuthMessage, challenge []byte, resp *AuthMessage) (*Principal, error) {
+	return nil, NewAuthFailedError("password authentication has no challenge step")
+}
+
+// HMACChallengeAuthenticator HMAC挑战-响应认证器
+// 服务端下发随机挑战，客户端以共享密钥计算HMAC-SHA256(secret, challenge)作为响应
+// 密钥本身不在网络上传输
+type HMACChallengeAuthenticator struct {
+	// Secret 查找用户的共享密钥
+	Secret func(ctx context.Context, username string) ([]byte, error)
+	// Principal 认证成功后构造主体，为nil时以用户名作为主体ID
+	Principal func(ctx context.Context, username string) (*Principal, error)
+	// ChallengeSize 挑战长度，默认32字节
+	ChallengeSize int
+}
+
+// Method 实现Authenticator接口
+func (a *HMACChallengeAuthenticator) Method() string {
+	return AuthMethodHMAC
+}
+
+// Begin 实现Authenticator接口，生成随机挑战
+func (a *HMACChallengeAuthenticator) Begin(ctx context.Context, req *AuthMessage) (*Principal, []byte, error) {
+	if req.Username == "" {
+		return nil, nil, NewAuthFailedError("username is empty")
+	}
+
+	size := a.ChallengeSize
+	if size <= 0 {
+		size = 32
+	}
+	challenge := make([]byte, size)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, nil, err
+	}
+	return nil, challenge, nil
+}
+
+// Continue 实现Authenticator接口，校验客户端的HMAC响应
+func (a *HMACChallengeAuthenticator) Continue(ctx context.Context, req *AuthMessage, challenge []byte, resp *AuthMessage) (*Principal, error) {
+	secret, err := a.Secret(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(computeChallengeResponse(secret, challenge), resp.Response) {
+		return nil, NewAuthFailedError("challenge response mismatch")
+	}
+
+	if a.Principal != nil {
+		return a.Principal(ctx, req.Username)
+	}
+	return &Principal{ID: req.Username}, nil
+}
+
+// computeChallengeResponse 计算挑战响应：HMAC-SHA256(secret, challenge)
+func computeChallengeResponse(secret, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// AuthState 服务端认证状态
+type AuthState uint8
+
+// 认证状态定义
+const (
+	// AuthStatePending 等待认证请求
+	AuthStatePending AuthState = iota
+	// AuthStateChallenged 已下发挑战，等待客户端响应
+	AuthStateChallenged
+	// AuthStateAuthenticated 认证成功
+	AuthStateAuthenticated
+	// AuthStateFailed 认证失败，连接应被关闭
+	AuthStateFailed
+)
+
+// String 返回认证状态的字符串表示
+func (s AuthState) String() string {
+	switch s {
+	case AuthStatePending:
+		return "pending"
+	case AuthStateChallenged:
+		return "challenged"
+	case AuthStateAuthenticated:
+		return "authenticated"
+	case AuthStateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("AuthState(%d)", uint8(s))
+	}
+}
+
+// ServerHandshake 服务端认证状态机，每个连接一个实例
+//
+// 状态转换：
+//
+//	Pending --request--> Authenticated | Challenged | Failed
+//	Challenged --response--> Authenticated | Failed
+//
+// 认证成功前，非认证帧一律被拒绝；认证失败为终止状态
+//
+// 使用示例：
+//
+//	hs := NewServerHandshake(&TokenAuthenticator{Validate: validate})
+//	for hs.State() != AuthStateAuthenticated {
+//		frame, _ := decoder.DecodeFromReader(conn)
+//		reply, err := hs.HandleFrame(ctx, frame)
+//		if reply != nil {
+//			reply.EncodeTo(conn)
+//		}
+//		if err != nil {
+//			conn.Close()
+//			return
+//		}
+//	}
+//	ctx = ContextWithPrincipal(ctx, hs.Principal())
+//
+// 并发安全说明：
+// ServerHandshake 的所有方法均为并发安全
+type ServerHandshake struct {
+	// mu 互斥锁，保护状态
+	mu sync.Mutex
+	// authenticators 认证方式到认证器的映射
+	authenticators map[string]Authenticator
+	// state 当前状态
+	state AuthState
+	// request 进入挑战阶段时的认证请求
+	request *AuthMessage
+	// challenge 已下发的挑战
+	challenge []byte
+	// principal 认证成功后的主体
+	principal *Principal
+	// sessions 会话存储，为nil时不签发会话令牌
+	sessions *SessionStore
+}
+
+// NewServerHandshake 创建服务端认证状态机
+func NewServerHandshake(authenticators ...Authenticator) *ServerHandshake {
+	h := &ServerHandshake{authenticators: make(map[string]Authenticator, len(authenticators))}
+	for _, a := range authenticators {
+		h.authenticators[a.Method()] = a
+	}
+	return h
+}
+
+// State 返回当前认证状态
+func (h *ServerHandshake) State() AuthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// Principal 返回认证成功后的主体，未认证时返回nil
+func (h *ServerHandshake) Principal() *Principal {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.principal
+}
+
+// Admit 检查业务帧是否允许处理，认证成功前返回ErrCodeUnauthenticated错误
+func (h *ServerHandshake) Admit(f *Frame) error {
+	if h.State() != AuthStateAuthenticated {
+		return NewUnauthenticatedError("frame received before authentication")
+	}
+	return nil
+}
+
+// HandleFrame 处理认证阶段收到的帧，返回需要回复给客户端的帧
+// 返回错误时连接应被关闭；认证失败时reply为失败通知帧，err为ErrCodeAuthFailed错误
+func (h *ServerHandshake) HandleFrame(ctx context.Context, f *Frame) (reply *Frame, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch h.state {
+	case AuthStateAuthenticated:
+		return nil, NewAuthFailedError("already authenticated")
+	case AuthStateFailed:
+		return nil, NewAuthFailedError("authentication already failed")
+	}
+
+	msg, err := ParseAuthMessage(f)
+	if err != nil {
+		h.state = AuthStateFailed
+		return nil, err
+	}
+
+	var principal *Principal
+	switch {
+	case h.state == AuthStatePending && msg.Kind == AuthKindRequest:
+		authenticator, ok := h.authenticators[msg.Method]
+		if !ok {
+			return h.fail(fmt.Sprintf("unsupported auth method %q", msg.Method), nil)
+		}
+		var challenge []byte
+		principal, challenge, err = authenticator.Begin(ctx, msg)
+		if err != nil {
+			return h.fail("invalid credentials", err)
+		}
+		if principal == nil {
+			if challenge == nil {
+				return h.fail("authenticator returned neither principal nor challenge", nil)
+			}
+			h.state = AuthStateChallenged
+			h.request = msg
+			h.challenge = challenge
+			return (&AuthMessage{Kind: AuthKindChallenge, Method: msg.Method, Challenge: challenge}).Frame()
+		}
+	case h.state == AuthStateChallenged && msg.Kind == AuthKindResponse:
+		authenticator := h.authenticators[h.request.Method]
+		principal, err = authenticator.Continue(ctx, h.request, h.challenge, msg)
+		if err != nil {
+			return h.fail("invalid credentials", err)
+		}
+		if principal == nil {
+			return h.fail("authenticator returned no principal", nil)
+		}
+	default:
+		return h.fail(fmt.Sprintf("unexpected auth message %q in state %s", msg.Kind, h.state), nil)
+	}
+
+	if principal.Method == "" {
+		principal.Method = msg.Method
+		if h.request != nil {
+			principal.Method = h.request.Method
+		}
+	}
+	session, err := h.issueSession(msg, principal)
+	if err != nil {
+		return h.fail("failed to issue session", err)
+	}
+	h.state = AuthStateAuthenticated
+	h.principal = principal
+	h.request = nil
+	h.challenge = nil
+	return (&AuthMessage{Kind: AuthKindSuccess, Method: principal.Method, Principal: principal.ID, Session: session}).Frame()
+}
+
+// fail 进入失败状态并返回失败通知帧
+// reason 会发送给客户端；cause 为认证器返回的原始错误，只在服务端错误链中保留
+// 调用方必须持有锁
+func (h *ServerHandshake) fail(reason string, cause error) (*Frame, error) {
+	h.state = AuthStateFailed
+	h.request = nil
+	h.challenge = nil
+	reply, err := (&AuthMessage{Kind: AuthKindFailure, Reason: reason}).Frame()
+	if err != nil {
+		return nil, err
+	}
+	return reply, &ProtocolError{
+		Code:     ErrCodeAuthFailed,
+		Message:  fmt.Sprintf("authentication failed: %s", reason),
+		Original: cause,
+	}
+}
+
+// Credentials 客户端凭据接口
+type Credentials interface {
+	// Request 构造认证请求
+	Request() *AuthMessage
+	// Respond 响应服务端下发的挑战
+	Respond(challenge []byte) (*AuthMessage, error)
+}
+
+// TokenCredentials 令牌凭据
+type TokenCredentials struct {
+	Token string
+}
+
+// Request 实现Credentials接口
+func (c *TokenCredentials) Request() *AuthMessage {
+	return &AuthMessage{Kind: AuthKindRequest, Method: AuthMethodToken, Token: c.Token}
+}
+
+// Respond 实现Credentials接口，令牌认证不应收到挑战
+func (c *TokenCredentials) Respond(challenge []byte) (*AuthMessage, error) {
+	return nil, NewAuthFailedError("unexpected challenge for token authentication")
+}
+
+// PasswordCredentials 用户名/密码凭据
+type PasswordCredentials struct {
+	Username string
+	Password string
+}
+
+// Request 实现Credentials接口
+func (c *PasswordCredentials) Request() *AuthMessage {
+	return &AuthMessage{Kind: AuthKindRequest, Method: AuthMethodPassword, Username: c.Username, Password: c.Password}
+}
+
+// Respond 实现Credentials接口，密码认证不应收到挑战
+func (c *PasswordCredentials) Respond(challenge []byte) (*AuthMessage, error) {
+	return nil, NewAuthFailedError("unexpected challenge for password authentication")
+}
+
+// HMACCredentials HMAC挑战-响应凭据
+type HMACCredentials struct {
+	Username string
+	Secret   []byte
+}
+
+// Request 实现Credentials接口
+func (c *HMACCredentials) Request() *AuthMessage {
+	return &AuthMessage{Kind: AuthKindRequest, Method: AuthMethodHMAC, Username: c.Username}
+}
+
+// Respond 实现Credentials接口，计算HMAC-SHA256(secret, challenge)
+func (c *HMACCredentials) Respond(challenge []byte) (*AuthMessage, error) {
+	return &AuthMessage{Kind: AuthKindResponse, Method: AuthMethodHMAC, Response: computeChallengeResponse(c.Secret, challenge)}, nil
+}
+
+// ClientHandshake 在客户端执行认证流程，直到成功或失败
+// decoder 用于从rw中读取服务端回复，认证结束后可继续用于读取业务帧
+// 成功时返回服务端的success消息；失败时返回ErrCodeAuthFailed错误
+func ClientHandshake(rw io.ReadWriter, decoder *StreamDecoder, creds Credentials) (*AuthMessage, error) {
+	msg := creds.Request()
+	for {
+		f, err := msg.Frame()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.EncodeTo(rw); err != nil {
+			return nil, err
+		}
+
+		replyFrame, err := decoder.ReadFrame(rw)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := ParseAuthMessage(replyFrame)
+		if err != nil {
+			return nil, err
+		}
+
+		switch reply.Kind {
+		case AuthKindSuccess:
+			return reply, nil
+		case AuthKindFailure:
+			return nil, NewAuthFailedError(reply.Reason)
+		case AuthKindChallenge:
+			if msg, err = creds.Respond(reply.Challenge); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, NewAuthFailedError(fmt.Sprintf("unexpected auth message %q from server", reply.Kind))
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// serveHandshake runs the server side of the auth phase on conn
+func serveHandshake(ctx context.Context, conn net.Conn, hs *ServerHandshake) error {
+	decoder := NewStreamDecoder()
+	for hs.State() != AuthStateAuthenticated {
+		frame, err := decoder.ReadFrame(conn)
+		if err != nil {
+			return err
+		}
+		reply, err := hs.HandleFrame(ctx, frame)
+		if reply != nil {
+			if _, writeErr := reply.EncodeTo(conn); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestAuthHandshakeMethods tests token, password and HMAC challenge-response authentication
+func TestAuthHandshakeMethods(t *testing.T) {
+	secret := []byte("shared-secret")
+	authenticators := []Authenticator{
+		&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+			if token != "good-token" {
+				return nil, errors.New("bad token")
+			}
+			return &Principal{ID: "alice"}, nil
+		}},
+		&PasswordAuthenticator{Verify: func(ctx context.Context, username, password string) (*Principal, error) {
+			if username != "bob" || password != "hunter2" {
+				return nil, errors.New("bad password")
+			}
+			return &Principal{ID: username}, nil
+		}},
+		&HMACChallengeAuthenticator{Secret: func(ctx context.Context, username string) ([]byte, error) {
+			return secret, nil
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		creds    Credentials
+		wantID   string
+		wantFail bool
+	}{
+		{"token", &TokenCredentials{Token: "good-token"}, "alice", false},
+		{"bad token", &TokenCredentials{Token: "stolen"}, "", true},
+		{"password", &PasswordCredentials{Username: "bob", Password: "hunter2"}, "bob", false},
+		{"hmac", &HMACCredentials{Username: "carol", Secret: secret}, "carol", false},
+		{"bad hmac", &HMACCredentials{Username: "carol", Secret: []byte("wrong")}, "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			hs := NewServerHandshake(authenticators...)
+			serverErr := make(chan error, 1)
+			go func() {
+				serverErr <- serveHandshake(context.Background(), server, hs)
+			}()
+
+			reply, err := ClientHandshake(client, NewStreamDecoder(), tc.creds)
+			srvErr := <-serverErr
+
+			if tc.wantFail {
+				if !IsAuthFailedError(err) {
+					t.Errorf("Expected client auth failure, got %v", err)
+				}
+				if !IsAuthFailedError(srvErr) || hs.State() != AuthStateFailed {
+					t.Errorf("Expected server auth failure, got %v in state %s", srvErr, hs.State())
+				}
+				return
+			}
+
+			if err != nil || srvErr != nil {
+				t.Fatalf("Handshake failed: client %v, server %v", err, srvErr)
+			}
+			if reply.Principal != tc.wantID {
+				t.Errorf("Expected principal %q, got %q", tc.wantID, reply.Principal)
+			}
+			if p := hs.Principal(); p == nil || p.ID != tc.wantID || p.Method != tc.creds.Request().Method {
+				t.Errorf("Unexpected server principal %+v", p)
+			}
+		})
+	}
+}
+
+// TestServerHandshakeRejectsNonAuthFrames tests that business frames are refused before authentication
+func TestServerHandshakeRejectsNonAuthFrames(t *testing.T) {
+	hs := NewServerHandshake(&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+		return &Principal{ID: "alice"}, nil
+	}})
+
+	business, err := NewFrame(FrameTypeJSON, []byte(`{"text":"hi"}`))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+
+	if err := hs.Admit(business); !IsUnauthenticatedError(err) {
+		t.Errorf("Expected unauthenticated error from Admit, got %v", err)
+	}
+
+	if _, err := hs.HandleFrame(context.Background(), business); !IsUnauthenticatedError(err) {
+		t.Errorf("Expected unauthenticated error from HandleFrame, got %v", err)
+	}
+
+	if hs.State() != AuthStateFailed {
+		t.Errorf("Expected failed state, got %s", hs.State())
+	}
+
+	// A fresh handshake admits business frames after success
+	hs = NewServerHandshake(&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+		return &Principal{ID: "alice"}, nil
+	}})
+	request, err := (&TokenCredentials{Token: "t"}).Request().Frame()
+	if err != nil {
+		t.Fatalf("Failed to create auth frame: %v", err)
+	}
+	reply, err := hs.HandleFrame(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Unexpected auth error: %v", err)
+	}
+	if msg, err := ParseAuthMessage(reply); err != nil || msg.Kind != AuthKindSuccess {
+		t.Errorf("Expected success reply, got %+v, %v", msg, err)
+	}
+	if err := hs.Admit(business); err != nil {
+		t.Errorf("Expected business frame to be admitted, got %v", err)
+	}
+
+	ctx := ContextWithPrincipal(context.Background(), hs.Principal())
+	if p := PrincipalFromContext(ctx); p == nil || p.ID != "alice" {
+		t.Errorf("Unexpected principal from context: %+v", p)
+	}
+}
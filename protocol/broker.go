@@ -0,0 +1,434 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 主题代理默认值
+const (
+	// DefaultBrokerQueueSize 每个订阅者默认的待发送帧队列长度
+	DefaultBrokerQueueSize = 256
+	// DefaultBrokerWriteTimeout 向订阅者写入单个帧的默认超时
+	DefaultBrokerWriteTimeout = 5 * time.Second
+)
+
+// ErrSlowConsumer 订阅者的发送队列已满，被代理逐出
+var ErrSlowConsumer = errors.New("protocol: slow consumer")
+
+// BrokerConfig 主题代理配置，零值字段使用默认值
+type BrokerConfig struct {
+	// QueueSize 每个订阅者的待发送帧队列长度，默认DefaultBrokerQueueSize
+	// 队列满时订阅者被视为慢消费者并被逐出
+	QueueSize int
+	// WriteTimeout 向订阅者写入单个帧的超时，默认DefaultBrokerWriteTimeout，负值表示不设超时
+	// 超时同样视为慢消费者
+	WriteTimeout time.Duration
+	// Authorize 可选，检查主体是否允许订阅主题，返回的错误原样回复给对端
+	// 连接未认证时p为nil
+	Authorize func(p *Principal, topic string) error
+	// OnEvict 可选，订阅者被逐出（队列满或写入失败）后的回调
+	OnEvict func(c *Conn, err error)
+}
+
+// BrokerStats 主题代理统计
+type BrokerStats struct {
+	// Topics 当前至少有一个订阅者的主题数
+	Topics int
+	// Subscribers 当前订阅了至少一个主题的连接数
+	Subscribers int
+	// Published 发布的帧数
+	Published uint64
+	// Delivered 成功写入订阅者的帧数
+	Delivered uint64
+	// Evicted 被逐出的订阅者数
+	Evicted uint64
+}
+
+// subscriber 一个订阅连接及其发送队列
+type subscriber struct {
+	// conn 订阅连接
+	conn *Conn
+	// topics 已订阅的主题
+	topics map[string]struct{}
+	// queue 待写入的已编码帧，队列中的每个帧持有一个引用，写入后释放
+	queue chan *EncodedFrame
+	// done 订阅者移除时关闭，通知写协程退出
+	done chan struct{}
+}
+
+// Broker 服务端主题代理，将帧扇出给订阅了同一主题（如群聊）的所有连接
+//
+// 连接通过Subscribe/Unsubscribe控制消息订阅主题（见Middleware），也可由服务端直接调用Subscribe。
+// Publish只编码一次帧，所有订阅者共享同一份引用计数的编码结果（见EncodedFrame）；每个订阅者有独立的有界队列和写协程，
+// 队列满或写入超时的订阅者会被逐出并断开连接，不会拖慢发布方和其他订阅者。
+// 被逐出的客户端重连后应通过离线消息等机制补齐遗漏的帧。
+//
+// 由于编码结果在订阅者之间共享，连接上通过SetEncodeOptions设置的编码选项（如签名）不适用于广播帧，
+// 需要时在Publish时统一传入。
+//
+// 使用示例：
+//
+//	broker := NewBroker(BrokerConfig{})
+//	srv := NewServer(ServerConfig{OnClose: broker.Remove})
+//	srv.Use(broker.Middleware())
+//	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+//		msg, err := ParseMessage(f)
+//		if err != nil {
+//			return err
+//		}
+//		if chat, ok := msg.(*ChatMessage); ok && chat.ConversationID != "" {
+//			_, err = broker.Publish(chat.ConversationID, f)
+//		}
+//		return err
+//	})
+//
+// 并发安全说明：
+// 所有方法都可以并发调用
+type Broker struct {
+	config BrokerConfig
+
+	mu sync.RWMutex
+	// topics 主题到订阅者集合的映射
+	topics map[string]map[*subscriber]struct{}
+	// subs 连接到订阅者的映射
+	subs map[*Conn]*subscriber
+
+	published atomic.Uint64
+	delivered atomic.Uint64
+	evicted   atomic.Uint64
+}
+
+// NewBroker 创建主题代理
+func NewBroker(config BrokerConfig) *Broker {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultBrokerQueueSize
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = DefaultBrokerWriteTimeout
+	}
+	return &Broker{
+		config: config,
+		topics: make(map[string]map[*subscriber]struct{}),
+		subs:   make(map[*Conn]*subscriber),
+	}
+}
+
+// Subscribe 为连接订阅主题，已订阅的主题被忽略
+// 不执行BrokerConfig.Authorize检查，由调用方负责
+func (b *Broker) Subscribe(c *Conn, topics ...string) error {
+	for _, topic := range topics {
+		if topic == "" {
+			return NewInvalidMessageError("empty topic")
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.subs[c]
+	if !ok {
+		s = &subscriber{
+			conn:   c,
+			topics: make(map[string]struct{}),
+			queue:  make(chan *EncodedFrame, b.config.QueueSize),
+			done:   make(chan struct{}),
+		}
+		b.subs[c] = s
+		go b.writeLoop(s)
+	}
+	for _, topic := range topics {
+		s.topics[topic] = struct{}{}
+		members, ok := b.topics[topic]
+		if !ok {
+			members = make(map[*subscriber]struct{})
+			b.topics[topic] = members
+		}
+		members[s] = struct{}{}
+	}
+	return nil
+}
+
+// Unsubscribe 取消连接对主题的订阅，未订阅任何主题的连接会被移除
+func (b *Broker) Unsubscribe(c *Conn, topics ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.subs[c]
+	if !ok {
+		return
+	}
+	for _, topic := range topics {
+		delete(s.topics, topic)
+		b.leave(s, topic)
+	}
+	if len(s.topics) == 0 {
+		b.removeLocked(s)
+	}
+}
+
+// Remove 移除连接的所有订阅，通常在连接关闭时调用（如ServerConfig.OnClose）
+// 队列中尚未写出的帧被丢弃，不会关闭连接
+func (b *Broker) Remove(c *Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.subs[c]; ok {
+		b.removeLocked(s)
+	}
+}
+
+// Topics 返回连接已订阅的主题
+func (b *Broker) Topics(c *Conn) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	s, ok := b.subs[c]
+	if !ok {
+		return nil
+	}
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// Subscribers 返回主题当前的订阅者数量
+func (b *Broker) Subscribers(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.topics[topic])
+}
+
+// Publish 将帧发布给主题的所有订阅者
+// 帧只通过Frame.EncodeShared编码一次，编码结果由所有订阅者共享，全部写入完成后归还缓冲区池
+//
+// 参数：
+//   - topic：主题
+//   - f：要发布的帧
+//   - options：编码选项，对所有订阅者生效
+//
+// 返回值：
+//   - int：帧进入发送队列的订阅者数，不包括因队列已满被逐出的订阅者
+//   - error：编码失败时返回错误
+func (b *Broker) Publish(topic string, f *Frame, options ...EncodeOption) (int, error) {
+	ef, err := f.EncodeShared(options...)
+	if err != nil {
+		return 0, err
+	}
+	defer ef.Release()
+	return b.PublishEncoded(topic, ef), nil
+}
+
+// PublishEncoded 将已编码的帧发布给主题的所有订阅者，返回进入发送队列的订阅者数
+// 每个订阅者各自持有一个引用，调用方持有的引用不受影响，仍需由调用方释放
+// 同一个EncodedFrame可以发布到多个主题
+func (b *Broker) PublishEncoded(topic string, ef *EncodedFrame) int {
+	b.published.Add(1)
+
+	var queued int
+	var slow []*subscriber
+	b.mu.RLock()
+	for s := range b.topics[topic] {
+		ef.Retain()
+		select {
+		case s.queue <- ef:
+			queued++
+		default:
+			ef.Release()
+			slow = append(slow, s)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range slow {
+		b.evict(s, ErrSlowConsumer)
+	}
+	return queued
+}
+
+// SendTo 将已编码的帧放入单个订阅者的发送队列，与发布给该订阅者的其他帧保持先后顺序
+// 连接未订阅任何主题时返回false；队列已满时逐出订阅者并返回false
+// 订阅者持有一个引用，调用方持有的引用仍需由调用方释放
+func (b *Broker) SendTo(c *Conn, ef *EncodedFrame) bool {
+	b.mu.RLock()
+	s, ok := b.subs[c]
+	if ok {
+		ef.Retain()
+		select {
+		case s.queue <- ef:
+			b.mu.RUnlock()
+			return true
+		default:
+			ef.Release()
+		}
+	}
+	b.mu.RUnlock()
+
+	if ok {
+		b.evict(s, ErrSlowConsumer)
+	}
+	return false
+}
+
+// Stats 返回统计信息
+func (b *Broker) Stats() BrokerStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return BrokerStats{
+		Topics:      len(b.topics),
+		Subscribers: len(b.subs),
+		Published:   b.published.Load(),
+		Delivered:   b.delivered.Load(),
+		Evicted:     b.evicted.Load(),
+	}
+}
+
+// Middleware 返回处理Subscribe/Unsubscribe控制消息的中间件，其余帧交给后续处理器
+// 控制消息带有ID时回复Ack；订阅被Authorize拒绝时返回其错误，由Server回复错误帧
+// 连接必须在context中（见ContextWithConn），Server会自动设置
+func (b *Broker) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+			op, ok := envelopeOpcode(f)
+			if !ok || (op != OpSubscribe && op != OpUnsubscribe) {
+				return next.ServeFrame(ctx, w, f)
+			}
+
+			conn := ConnFromContext(ctx)
+			if conn == nil {
+				return NewInvalidMessageError("topic control message outside of a connection")
+			}
+			msg, err := ParseMessage(f)
+			if err != nil {
+				return err
+			}
+
+			var id string
+			switch m := msg.(type) {
+			case *Subscribe:
+				id = m.ID
+				if err := b.authorize(ctx, conn, m.Topics); err != nil {
+					return err
+				}
+				if err := b.Subscribe(conn, m.Topics...); err != nil {
+					return err
+				}
+			case *Unsubscribe:
+				id = m.ID
+				b.Unsubscribe(conn, m.Topics...)
+			}
+
+			if id == "" {
+				return nil
+			}
+			ack, err := EncodeMessage(f.Type, &Ack{MessageID: id})
+			if err != nil {
+				return err
+			}
+			return w.WriteFrame(ack)
+		})
+	}
+}
+
+// authorize 检查连接的主体是否允许订阅所有主题
+func (b *Broker) authorize(ctx context.Context, conn *Conn, topics []string) error {
+	if b.config.Authorize == nil {
+		return nil
+	}
+	p := PrincipalFromContext(ctx)
+	if p == nil {
+		p = conn.Principal()
+	}
+	for _, topic := range topics {
+		if err := b.config.Authorize(p, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLoop 将订阅者队列中的帧依次写入连接，写入失败时逐出订阅者
+// 退出时释放队列中剩余帧的引用；订阅者移除后不会再有帧入队
+func (b *Broker) writeLoop(s *subscriber) {
+	defer func() {
+		for {
+			select {
+			case ef := <-s.queue:
+				ef.Release()
+			default:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case ef := <-s.queue:
+			err := s.conn.writeEncodedTimeout(ef.Bytes(), b.config.WriteTimeout)
+			ef.Release()
+			if err != nil {
+				b.evict(s, err)
+				return
+			}
+			b.delivered.Add(1)
+		}
+	}
+}
+
+// evict 逐出订阅者并断开连接，订阅者已被移除时不做任何事
+func (b *Broker) evict(s *subscriber, err error) {
+	b.mu.Lock()
+	if b.subs[s.conn] != s {
+		b.mu.Unlock()
+		return
+	}
+	b.removeLocked(s)
+	b.mu.Unlock()
+
+	b.evicted.Add(1)
+	s.conn.Close()
+	if b.config.OnEvict != nil {
+		b.config.OnEvict(s.conn, err)
+	}
+}
+
+// removeLocked 从所有主题中移除订阅者并停止其写协程，调用方需持有写锁
+func (b *Broker) removeLocked(s *subscriber) {
+	for topic := range s.topics {
+		b.leave(s, topic)
+	}
+	delete(b.subs, s.conn)
+	close(s.done)
+}
+
+// leave 从主题的订阅者集合中移除订阅者，主题没有订阅者时删除，调用方需持有写锁
+func (b *Broker) leave(s *subscriber, topic string) {
+	members, ok := b.topics[topic]
+	if !ok {
+		return
+	}
+	delete(members, s)
+	if len(members) == 0 {
+		delete(b.topics, topic)
+	}
+}
+
+// envelopeOpcode 返回帧中信封消息的操作码，帧类型未注册BodyCodec或消息体不是信封时返回false
+func envelopeOpcode(f *Frame) (Opcode, bool) {
+	codec, ok := BodyCodecFor(f.Type)
+	if !ok {
+		return 0, false
+	}
+	header, err := decodeEnvelopeHeader(codec, f.Body)
+	if err != nil {
+		return 0, false
+	}
+	return header.Op, true
+}
@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// brokerRequest writes a control message and waits for its Ack
+func brokerRequest(t *testing.T, conn *Conn, msg Message) {
+	t.Helper()
+	frame, err := EncodeMessage(FrameTypeJSON, msg)
+	if err != nil {
+		t.Fatalf("Failed to encode %s: %v", msg.Opcode(), err)
+	}
+	if err := conn.WriteFrame(frame); err != nil {
+		t.Fatalf("Failed to write %s: %v", msg.Opcode(), err)
+	}
+	reply, err := conn.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	parsed, err := ParseMessage(reply)
+	if err != nil {
+		t.Fatalf("Failed to parse reply: %v", err)
+	}
+	if ack, ok := parsed.(*Ack); !ok || ack.MessageID != msg.header().ID {
+		t.Fatalf("Expected Ack for %s, got %#v", msg.header().ID, parsed)
+	}
+}
+
+// TestBrokerFanOut tests subscribing via control frames and publishing to all subscribers
+func TestBrokerFanOut(t *testing.T) {
+	broker := NewBroker(BrokerConfig{
+		Authorize: func(p *Principal, topic string) error {
+			if topic == "secret" {
+				return NewUnauthenticatedError("not a member")
+			}
+			return nil
+		},
+	})
+	srv := NewServer(ServerConfig{OnClose: broker.Remove})
+	srv.Use(broker.Middleware())
+	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+		msg, err := ParseMessage(f)
+		if err != nil {
+			return err
+		}
+		if chat, ok := msg.(*ChatMessage); ok {
+			_, err = broker.Publish(chat.ConversationID, f)
+		}
+		return err
+	})
+	addr := startTestServer(t, srv)
+
+	var members []*Conn
+	for i := 0; i < 2; i++ {
+		conn, err := Dial(context.Background(), addr, nil)
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer conn.Close()
+		brokerRequest(t, conn, &Subscribe{EnvelopeHeader: EnvelopeHeader{ID: "sub"}, Topics: []string{"group-1"}})
+		members = append(members, conn)
+	}
+	if n := broker.Subscribers("group-1"); n != 2 {
+		t.Fatalf("Expected 2 subscribers, got %d", n)
+	}
+
+	// Unauthorized subscriptions are rejected with an error frame
+	frame, _ := EncodeMessage(FrameTypeJSON, &Subscribe{EnvelopeHeader: EnvelopeHeader{ID: "sub2"}, Topics: []string{"secret"}})
+	members[1].WriteFrame(frame)
+	reply, err := members[1].ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	var errMsg ErrorMessage
+	if err := json.Unmarshal(reply.Body, &errMsg); err != nil || errMsg.Code != ErrCodeUnauthenticated {
+		t.Errorf("Expected unauthenticated error frame, got %s", reply)
+	}
+
+	chat, _ := EncodeMessage(FrameTypeJSON, &ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: "m1"}, From: "alice", ConversationID: "group-1", Content: "hi all"})
+	if err := members[0].WriteFrame(chat); err != nil {
+		t.Fatalf("Failed to write chat message: %v", err)
+	}
+	for i, conn := range members {
+		f, err := conn.ReadFrame()
+		if err != nil {
+			t.Fatalf("Member %d failed to read: %v", i, err)
+		}
+		msg, err := ParseMessage(f)
+		if err != nil {
+			t.Fatalf("Member %d failed to parse: %v", i, err)
+		}
+		if got, ok := msg.(*ChatMessage); !ok || got.Content != "hi all" {
+			t.Errorf("Member %d got unexpected message %#v", i, msg)
+		}
+	}
+
+	brokerRequest(t, members[1], &Unsubscribe{EnvelopeHeader: EnvelopeHeader{ID: "unsub"}, Topics: []string{"group-1"}})
+	if n := broker.Subscribers("group-1"); n != 1 {
+		t.Errorf("Expected 1 subscriber after unsubscribe, got %d", n)
+	}
+
+	members[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for broker.Stats().Subscribers != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := broker.Stats()
+	if stats.Subscribers != 0 || stats.Topics != 0 {
+		t.Errorf("Expected subscriptions to be removed on close, got %+v", stats)
+	}
+	if stats.Published != 1 || stats.Delivered != 2 {
+		t.Errorf("Expected 1 published and 2 delivered, got %+v", stats)
+	}
+}
+
+// TestBrokerSlowConsumer tests that a subscriber whose queue fills up is evicted without blocking others
+func TestBrokerSlowConsumer(t *testing.T) {
+	evicted := make(chan error, 2)
+	broker := NewBroker(BrokerConfig{
+		QueueSize:    4,
+		WriteTimeout: -1,
+		OnEvict:      func(c *Conn, err error) { evicted <- err },
+	})
+
+	// Nobody reads from the slow end of the pipe, so writes block
+	slowServer, slowClient := net.Pipe()
+	defer slowClient.Close()
+	slow := NewConn(slowServer)
+
+	fastServer, fastClient := net.Pipe()
+	defer fastClient.Close()
+	fast := NewConn(fastServer)
+	defer fast.Close()
+	go io.Copy(io.Discard, fastClient)
+
+	if err := broker.Subscribe(slow, "room"); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	if err := broker.Subscribe(fast, "room"); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	frame, _ := NewFrame(FrameTypeJSON, []byte(`{"op":1}`))
+	for i := 0; i < 100 && len(broker.Topics(slow)) != 0; i++ {
+		if _, err := broker.Publish("room", frame); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case err := <-evicted:
+		if !errors.Is(err, ErrSlowConsumer) {
+			t.Errorf("Expected ErrSlowConsumer, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for eviction")
+	}
+	if topics := broker.Topics(slow); len(topics) != 0 {
+		t.Errorf("Expected evicted subscriber to have no topics, got %v", topics)
+	}
+	slowClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.Copy(io.Discard, slowClient); err != nil {
+		t.Errorf("Expected evicted connection to be closed, got %v", err)
+	}
+	if topics := broker.Topics(fast); len(topics) != 1 {
+		t.Errorf("Expected fast subscriber to stay subscribed, got %v", topics)
+	}
+	if stats := broker.Stats(); stats.Evicted != 1 {
+		t.Errorf("Expected 1 eviction, got %+v", stats)
+	}
+}
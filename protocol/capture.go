@@ -0,0 +1,289 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 抓包文件格式
+//
+//	文件头：8字节魔数 "IMCAP\x00\x00\x01"
+//	记录：[1字节方向][8字节时间戳(Unix纳秒)][4字节数据长度][数据]
+//
+// 记录保存的是连接上原始的字节流（一次Read或Write的结果），不做帧解析，
+// 因此可以复现粘包/拆包的原始分片情况。所有整数使用大端序。
+const (
+	// captureMagic 抓包文件魔数，最后一个字节为格式版本
+	captureMagic = "IMCAP\x00\x00\x01"
+	// captureRecordHeaderLength 记录头长度
+	captureRecordHeaderLength = 1 + 8 + 4
+	// maxCaptureRecordLength 单条记录的最大长度，防止损坏的文件导致过大的分配
+	maxCaptureRecordLength = 16 * 1024 * 1024
+)
+
+// CaptureDirection 抓包记录的方向，以被抓包的连接为视角
+type CaptureDirection uint8
+
+const (
+	// CaptureRead 从连接读取的数据（对端发来）
+	CaptureRead CaptureDirection = 1
+	// CaptureWrite 向连接写入的数据（发往对端）
+	CaptureWrite CaptureDirection = 2
+)
+
+// String 返回方向名称
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureRead:
+		return "read"
+	case CaptureWrite:
+		return "write"
+	default:
+		return fmt.Sprintf("direction(%d)", uint8(d))
+	}
+}
+
+// CaptureRecord 抓包文件中的一条记录
+type CaptureRecord struct {
+	// Time 数据被读取或写入的时间
+	Time time.Time
+	// Direction 数据方向
+	Direction CaptureDirection
+	// Data 原始字节
+	Data []byte
+}
+
+// CaptureWriter 抓包文件写入器
+//
+// 并发安全说明：
+// Write可以并发调用，同一连接的读写两个方向通常在不同goroutine中记录
+type CaptureWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	// now 返回当前时间，测试时可替换
+	now func() time.Time
+}
+
+// NewCaptureWriter 创建抓包文件写入器并写入文件头
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	if _, err := io.WriteString(w, captureMagic); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w, now: time.Now}, nil
+}
+
+// Write 以当前时间记录一段数据
+func (cw *CaptureWriter) Write(direction CaptureDirection, data []byte) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.writeRecord(&CaptureRecord{Time: cw.now(), Direction: direction, Data: data})
+}
+
+// WriteRecord 写入一条完整的记录，可用于过滤或合并抓包文件
+func (cw *CaptureWriter) WriteRecord(record *CaptureRecord) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.writeRecord(record)
+}
+
+// writeRecord 写入记录，调用方需持有锁
+func (cw *CaptureWriter) writeRecord(record *CaptureRecord) error {
+	if len(record.Data) > maxCaptureRecordLength {
+		return NewMessageTooLongError(len(record.Data), maxCaptureRecordLength)
+	}
+	buf := make([]byte, captureRecordHeaderLength, captureRecordHeaderLength+len(record.Data))
+	buf[0] = byte(record.Direction)
+	binary.BigEndian.PutUint64(buf[1:9], uint64(record.Time.UnixNano()))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(record.Data)))
+	buf = append(buf, record.Data...)
+	_, err := cw.w.Write(buf)
+	return err
+}
+
+// CaptureReader 抓包文件读取器
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+// NewCaptureReader 创建抓包文件读取器并校验文件头
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("read capture header: %w", err)
+	}
+	if string(magic) != captureMagic {
+		return nil, errors.New("not a capture file")
+	}
+	return &CaptureReader{r: br}, nil
+}
+
+// Next 读取下一条记录，文件结束时返回io.EOF，记录不完整时返回io.ErrUnexpectedEOF
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	header := make([]byte, captureRecordHeaderLength)
+	if _, err := io.ReadFull(cr.r, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[9:13])
+	if length > maxCaptureRecordLength {
+		return nil, NewMessageTooLongError(int(length), maxCaptureRecordLength)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &CaptureRecord{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9]))),
+		Direction: CaptureDirection(header[0]),
+		Data:      data,
+	}, nil
+}
+
+// captureConn 将读写的数据同时记录到抓包文件的net.Conn
+type captureConn struct {
+	net.Conn
+	capture *CaptureWriter
+}
+
+// NewCaptureConn 包装net.Conn，把每次成功读写的数据记录到capture中
+// 记录失败不影响连接本身的读写
+//
+// 使用示例：
+//
+//	conn := NewConn(NewCaptureConn(nc, capture))
+func NewCaptureConn(nc net.Conn, capture *CaptureWriter) net.Conn {
+	return &captureConn{Conn: nc, capture: capture}
+}
+
+// Read 实现net.Conn接口
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.capture.Write(CaptureRead, p[:n])
+	}
+	return n, err
+}
+
+// Write 实现net.Conn接口
+func (c *captureConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.capture.Write(CaptureWrite, p[:n])
+	}
+	return n, err
+}
+
+// CapturePlayer 按原始时间间隔回放抓包文件
+//
+// 使用示例：
+//
+//	player := &CapturePlayer{Direction: CaptureRead, Speed: 1}
+//	err := player.PlayToDecoder(ctx, reader, NewStreamDecoder(), func(record *CaptureRecord, f *Frame) error {
+//		fmt.Println(record.Time.Format(time.RFC3339Nano), f)
+//		return nil
+//	})
+type CapturePlayer struct {
+	// Direction 只回放该方向的记录，0表示回放所有方向
+	Direction CaptureDirection
+	// Speed 回放速度倍数，1为原始速度，2为两倍速；0或负值表示不等待，尽快回放
+	Speed float64
+	// sleep 等待指定时长，测试时可替换
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Play 依次把记录交给sink处理，两条记录之间按原始时间间隔（除以Speed）等待
+// sink返回错误或ctx取消时停止；文件正常结束时返回nil
+func (p *CapturePlayer) Play(ctx context.Context, r *CaptureReader, sink func(record *CaptureRecord) error) error {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	var first time.Time
+	var elapsed time.Duration
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p.Direction != 0 && record.Direction != p.Direction {
+			continue
+		}
+
+		if first.IsZero() {
+			first = record.Time
+		} else if p.Speed > 0 {
+			target := time.Duration(float64(record.Time.Sub(first)) / p.Speed)
+			if target > elapsed {
+				if err := sleep(ctx, target-elapsed); err != nil {
+					return err
+				}
+				elapsed = target
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := sink(record); err != nil {
+			return err
+		}
+	}
+}
+
+// PlayTo 把记录的原始字节按原始时间间隔写入w（如到服务端的实时连接）
+func (p *CapturePlayer) PlayTo(ctx context.Context, r *CaptureReader, w io.Writer) error {
+	return p.Play(ctx, r, func(record *CaptureRecord) error {
+		_, err := w.Write(record.Data)
+		return err
+	})
+}
+
+// PlayToDecoder 把记录的原始字节按原始时间间隔送入StreamDecoder，对解码出的每个帧调用onFrame
+// 两个方向的字节流不能混在同一个解码器中，因此Direction必须指定单一方向
+func (p *CapturePlayer) PlayToDecoder(ctx context.Context, r *CaptureReader, decoder *StreamDecoder, onFrame func(record *CaptureRecord, f *Frame) error) error {
+	if p.Direction == 0 {
+		return errors.New("PlayToDecoder requires a single capture direction")
+	}
+	return p.Play(ctx, r, func(record *CaptureRecord) error {
+		if err := decoder.Feed(record.Data); err != nil {
+			return err
+		}
+		for {
+			frame, err := decoder.TryDecode()
+			if err != nil {
+				return err
+			}
+			if frame == nil {
+				return nil
+			}
+			if err := onFrame(record, frame); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// sleepContext 等待d或ctx取消
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
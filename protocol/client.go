@@ -0,0 +1,423 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// 客户端默认值
+const (
+	// DefaultClientRetransmitInterval 连接期间检查到期重传的间隔
+	DefaultClientRetransmitInterval = 250 * time.Millisecond
+	// DefaultClientStateBuffer 状态变化通道的缓冲大小
+	DefaultClientStateBuffer = 16
+	// DefaultClientStableConnection 连接保持该时长以上才视为稳定，重连次数归零
+	DefaultClientStableConnection = 10 * time.Second
+)
+
+// ClientState 客户端连接状态
+type ClientState uint8
+
+// 客户端连接状态定义
+const (
+	// ClientStateDisconnected 未连接，等待重连
+	ClientStateDisconnected ClientState = iota
+	// ClientStateConnecting 正在拨号和认证
+	ClientStateConnecting
+	// ClientStateConnected 已连接并完成认证
+	ClientStateConnected
+	// ClientStateClosed 已关闭，不再重连
+	ClientStateClosed
+)
+
+// String 返回连接状态的字符串表示
+func (s ClientState) String() string {
+	switch s {
+	case ClientStateDisconnected:
+		return "disconnected"
+	case ClientStateConnecting:
+		return "connecting"
+	case ClientStateConnected:
+		return "connected"
+	case ClientStateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ClientState(%d)", uint8(s))
+	}
+}
+
+// ClientStateChange 一次连接状态变化
+type ClientStateChange struct {
+	// State 新状态
+	State ClientState
+	// Attempt 当前连续重连的次数，连接保持StableConnection以上后归零
+	Attempt int
+	// Err 导致状态变化的错误（断开原因、拨号或认证失败原因）
+	Err error
+}
+
+// ClientConfig 客户端配置
+type ClientConfig struct {
+	// Addr 服务端地址
+	Addr string
+	// TLSConfig TLS配置，为nil时使用明文连接
+	TLSConfig *tls.Config
+	// Dial 自定义拨号函数，设置后忽略Addr和TLSConfig
+	Dial func(ctx context.Context) (*Conn, error)
+	// Credentials 认证凭据，为nil时不执行认证
+	// 服务端签发了会话令牌时，重连会优先凭令牌恢复会话，失败后再使用该凭据
+	Credentials Credentials
+	// Reconnect 重连退避策略，MaxAttempts为连续失败的最大重连次数
+	// 每次重新拨号前都按退避等待，包括连接建立后又断开的情况
+	Reconnect RetryPolicy
+	// StableConnection 连接保持该时长以上后才将重连次数归零，默认DefaultClientStableConnection
+	// 避免服务端接受连接后立即断开时，客户端以最短间隔反复重连
+	StableConnection time.Duration
+	// Reliable 消息确认与重传配置
+	Reliable ReliableConfig
+	// RetransmitInterval 检查到期重传的间隔，默认DefaultClientRetransmitInterval
+	RetransmitInterval time.Duration
+	// OnMessage 收到信封消息（已确认、已去重）时的回调，在读取协程中调用
+	OnMessage func(msg Message)
+	// OnFrame 收到非信封帧时的回调，在读取协程中调用
+	OnFrame func(f *Frame)
+	// OnStateChange 连接状态变化时的回调，在Run所在的协程中调用
+	OnStateChange func(change ClientStateChange)
+}
+
+// Client 自动重连的客户端
+// 断线后按指数退避加随机抖动重新拨号，重新执行认证（优先恢复会话），
+// 并按顺序重发所有未确认的消息
+//
+// 使用示例：
+//
+//	client := NewClient(ClientConfig{
+//		Addr:        "im.example.com:443",
+//		TLSConfig:   &tls.Config{},
+//		Credentials: &TokenCredentials{Token: token},
+//		Reconnect:   RetryPolicy{InitialInterval: 500 * time.Millisecond, Jitter: 0.2},
+//		OnMessage:   func(msg Message) { ... },
+//	})
+//	go client.Run(ctx)
+//	for change := range client.States() {
+//		log.Printf("state: %s (%v)", change.State, change.Err)
+//	}
+//
+// 并发安全说明：
+// Send、State、Session、Close可在任意协程中调用；Run只能调用一次
+type Client struct {
+	// config 客户端配置
+	config ClientConfig
+	// writer 帧写入器，连接切换时重置底层连接
+	writer *frameWriter
+	// outbox 跨连接保留的发件箱
+	outbox *Outbox
+	// receiver 接收端确认与去重
+	receiver *Receiver
+	// states 状态变化通道
+	states chan ClientStateChange
+
+	// mu 保护以下字段
+	mu sync.Mutex
+	// state 当前状态
+	state ClientState
+	// conn 当前连接，未连接时为nil
+	conn *Conn
+	// session 服务端签发的会话令牌
+	session string
+	// cancel 取消Run的上下文
+	cancel context.CancelFunc
+	// closed 是否已调用Close
+	closed bool
+}
+
+// NewClient 创建客户端，调用Run开始连接
+func NewClient(config ClientConfig) *Client {
+	if config.RetransmitInterval <= 0 {
+		config.RetransmitInterval = DefaultClientRetransmitInterval
+	}
+	if config.StableConnection <= 0 {
+		config.StableConnection = DefaultClientStableConnection
+	}
+	config.Reconnect = config.Reconnect.withDefaults()
+
+	writer := &frameWriter{}
+	return &Client{
+		config:   config,
+		writer:   writer,
+		outbox:   newOutbox(writer, config.Reliable.Outbox),
+		receiver: newReceiver(writer, config.Reliable.Receiver),
+		states:   make(chan ClientStateChange, DefaultClientStateBuffer),
+	}
+}
+
+// Run 连接服务端并在断线后自动重连，直到ctx取消、调用Close或连续重连失败达到上限
+// 返回导致客户端关闭的错误
+func (c *Client) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	attempt := 0
+	for {
+		if ctx.Err() != nil {
+			return c.shutdown(ctx.Err())
+		}
+
+		attempt++
+		c.setState(ClientStateConnecting, attempt, nil)
+		conn, err := c.connect(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return c.shutdown(ctx.Err())
+			}
+			if c.config.Reconnect.MaxAttempts > 0 && attempt >= c.config.Reconnect.MaxAttempts {
+				return c.shutdown(err)
+			}
+			c.setState(ClientStateDisconnected, attempt, err)
+			if err := c.wait(ctx, attempt); err != nil {
+				return c.shutdown(err)
+			}
+			continue
+		}
+
+		c.setState(ClientStateConnected, attempt, nil)
+		connected := time.Now()
+		err = c.serve(ctx, conn)
+		if ctx.Err() != nil {
+			return c.shutdown(ctx.Err())
+		}
+		if time.Since(connected) >= c.config.StableConnection {
+			attempt = 0
+		}
+		c.setState(ClientStateDisconnected, attempt, err)
+		if err := c.wait(ctx, max(attempt, 1)); err != nil {
+			return c.shutdown(err)
+		}
+	}
+}
+
+// wait 按第attempt次重连的退避时间等待，ctx取消时返回其错误
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(c.config.Reconnect.backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// connect 拨号并执行认证
+// 持有会话令牌时先尝试恢复会话，被拒绝后丢弃令牌并使用完整凭据重新连接
+func (c *Client) connect(ctx context.Context) (*Conn, error) {
+	for {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if c.config.Credentials == nil {
+			return conn, nil
+		}
+
+		creds := c.config.Credentials
+		session := c.Session()
+		if session != "" {
+			creds = &SessionCredentials{Token: session}
+		}
+
+		// 认证期间ctx取消时关闭连接，使阻塞的读写返回
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		reply, err := ClientHandshake(conn.NetConn(), conn.decoder, creds)
+		stop()
+		if err != nil {
+			conn.Close()
+			if session != "" && IsAuthFailedError(err) && ctx.Err() == nil {
+				c.setSession("")
+				continue
+			}
+			return nil, err
+		}
+
+		if reply.Session != "" {
+			c.setSession(reply.Session)
+		}
+		return conn, nil
+	}
+}
+
+// dial 建立连接
+func (c *Client) dial(ctx context.Context) (*Conn, error) {
+	if c.config.Dial != nil {
+		return c.config.Dial(ctx)
+	}
+	return Dial(ctx, c.config.Addr, c.config.TLSConfig)
+}
+
+// serve 在一个连接上重发未确认消息并读取帧，直到连接出错
+func (c *Client) serve(ctx context.Context, conn *Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	c.writer.resetConn(conn)
+	defer func() {
+		c.writer.resetConn(nil)
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	// ctx取消时关闭连接，使ReadFrame返回
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := c.outbox.Replay(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := c.outbox.Run(ctx, c.config.RetransmitInterval); err != nil && ctx.Err() == nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		f, err := conn.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if err := c.dispatch(f); err != nil {
+			return err
+		}
+	}
+}
+
+// dispatch 处理收到的帧：确认帧交给发件箱，信封消息确认并去重后交给OnMessage
+// 确认写入失败时仍先投递消息再返回错误，因为消息ID已记入跨连接共享的去重缓存，重连后的重传会被当作重复消息丢弃
+func (c *Client) dispatch(f *Frame) error {
+	if c.outbox.HandleFrame(f) {
+		return nil
+	}
+
+	msg, duplicate, err := c.receiver.Accept(f)
+	if err != nil && IsInvalidMessageError(err) {
+		if c.config.OnFrame != nil {
+			c.config.OnFrame(f)
+		}
+		return nil
+	}
+	if msg != nil && !duplicate && c.config.OnMessage != nil {
+		c.config.OnMessage(msg)
+	}
+	return err
+}
+
+// Send 发送消息并跟踪确认，返回消息ID
+// 未连接或写入失败时消息保留在发件箱中，重连后自动重发，此时不返回错误；
+// 只有消息无法编码时返回错误
+func (c *Client) Send(msg Message) (string, error) {
+	id, err := c.outbox.Send(msg)
+	if err == nil || id == "" {
+		return id, err
+	}
+	if !errors.Is(err, errNotConnected) {
+		// 连接已损坏，关闭后由Run重连
+		c.mu.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mu.Unlock()
+	}
+	return id, nil
+}
+
+// Outbox 返回发件箱，可用于查询未确认的消息
+func (c *Client) Outbox() *Outbox {
+	return c.outbox
+}
+
+// State 返回当前连接状态
+func (c *Client) State() ClientState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// States 返回状态变化通道
+// 通道有缓冲，消费不及时时新的状态变化会被丢弃；Run返回时通道被关闭
+func (c *Client) States() <-chan ClientStateChange {
+	return c.states
+}
+
+// Session 返回服务端签发的会话令牌，未签发时返回空字符串
+func (c *Client) Session() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// setSession 保存会话令牌
+func (c *Client) setSession(session string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = session
+}
+
+// Close 关闭客户端，停止重连
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+	return nil
+}
+
+// setState 更新状态并通知回调和通道
+func (c *Client) setState(state ClientState, attempt int, err error) {
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+
+	change := ClientStateChange{State: state, Attempt: attempt, Err: err}
+	if c.config.OnStateChange != nil {
+		c.config.OnStateChange(change)
+	}
+	select {
+	case c.states <- change:
+	default:
+	}
+}
+
+// shutdown 进入关闭状态，关闭状态通道并返回err
+func (c *Client) shutdown(err error) error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	c.setState(ClientStateClosed, 0, err)
+	close(c.states)
+	return err
+}
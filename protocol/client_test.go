@@ -0,0 +1,260 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestClientReconnectResume tests reconnection, session resumption and replay of unacked messages
+func TestClientReconnectResume(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	var validations atomic.Int32
+	sessions := NewSessionStore(time.Hour)
+	received := make(chan *ChatMessage, 1)
+
+	go func() {
+		var open []*Conn
+		defer func() {
+			for _, conn := range open {
+				conn.Close()
+			}
+		}()
+
+		for connections := 0; ; connections++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			open = append(open, conn)
+			hs := NewServerHandshake(&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+				validations.Add(1)
+				return &Principal{ID: "alice"}, nil
+			}})
+			hs.SetSessionStore(sessions)
+			for hs.State() != AuthStateAuthenticated {
+				f, err := conn.ReadFrame()
+				if err != nil {
+					return
+				}
+				reply, err := hs.HandleFrame(context.Background(), f)
+				if reply != nil {
+					conn.WriteFrame(reply)
+				}
+				if err != nil {
+					return
+				}
+			}
+
+			f, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			if connections == 0 {
+				// Drop the first connection without acknowledging
+				conn.Close()
+				continue
+			}
+			msg, _, err := NewReceiver(conn.NetConn(), ReceiverConfig{}).Accept(f)
+			if err != nil {
+				t.Errorf("Failed to accept message: %v", err)
+				return
+			}
+			received <- msg.(*ChatMessage)
+		}
+	}()
+
+	client := NewClient(ClientConfig{
+		Addr:        ln.Addr().String(),
+		Credentials: &TokenCredentials{Token: "secret"},
+		Reconnect:   RetryPolicy{InitialInterval: 10 * time.Millisecond, Jitter: 0.5},
+	})
+
+	// Sent before connecting: queued and replayed once connected
+	id, err := client.Send(&ChatMessage{From: "alice", To: "bob", Content: "hello"})
+	if err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- client.Run(context.Background()) }()
+
+	select {
+	case msg := <-received:
+		if msg.ID != id || msg.Content != "hello" {
+			t.Errorf("Unexpected message: %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for replayed message")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Outbox().Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if client.Outbox().Len() != 0 {
+		t.Error("Expected message to be acknowledged")
+	}
+	if n := validations.Load(); n != 1 {
+		t.Errorf("Expected credentials to be validated once and the session resumed, got %d validations", n)
+	}
+	if client.Session() == "" {
+		t.Error("Expected client to hold a session token")
+	}
+
+	client.Close()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	var connected int
+	for change := range client.States() {
+		if change.State == ClientStateConnected {
+			connected++
+		}
+	}
+	if connected != 2 {
+		t.Errorf("Expected 2 connected states, got %d", connected)
+	}
+	if client.State() != ClientStateClosed {
+		t.Errorf("Expected closed state, got %s", client.State())
+	}
+}
+
+// TestClientMaxAttempts tests that the client gives up after consecutive dial failures
+func TestClientMaxAttempts(t *testing.T) {
+	dialErr := errors.New("network unreachable")
+	var dials int
+	var states []ClientState
+	client := NewClient(ClientConfig{
+		Dial: func(ctx context.Context) (*Conn, error) {
+			dials++
+			return nil, dialErr
+		},
+		Reconnect:     RetryPolicy{InitialInterval: time.Millisecond, MaxAttempts: 3},
+		OnStateChange: func(change ClientStateChange) { states = append(states, change.State) },
+	})
+
+	if err := client.Run(context.Background()); !errors.Is(err, dialErr) {
+		t.Fatalf("Expected dial error, got %v", err)
+	}
+	if dials != 3 {
+		t.Errorf("Expected 3 dial attempts, got %d", dials)
+	}
+
+	expected := []ClientState{
+		ClientStateConnecting, ClientStateDisconnected,
+		ClientStateConnecting, ClientStateDisconnected,
+		ClientStateConnecting, ClientStateClosed,
+	}
+	if len(states) != len(expected) {
+		t.Fatalf("Expected states %v, got %v", expected, states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Errorf("State %d: expected %s, got %s", i, expected[i], states[i])
+		}
+	}
+}
+
+// TestClientReconnectBackoff tests that connections dropped right after connecting are redialled with growing backoff
+func TestClientReconnectBackoff(t *testing.T) {
+	var attempts []int
+	var dials int
+	client := NewClient(ClientConfig{
+		Dial: func(ctx context.Context) (*Conn, error) {
+			dials++
+			local, remote := net.Pipe()
+			remote.Close()
+			return NewConn(local), nil
+		},
+		Reconnect: RetryPolicy{InitialInterval: 20 * time.Millisecond},
+		OnStateChange: func(change ClientStateChange) {
+			if change.State == ClientStateDisconnected {
+				attempts = append(attempts, change.Attempt)
+			}
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	client.Run(ctx)
+
+	// 20+40+80 ms of backoff fit in the timeout, the next 160 ms do not
+	if dials < 3 || dials > 4 {
+		t.Fatalf("Expected 3 or 4 dials, got %d", dials)
+	}
+	for i, attempt := range attempts {
+		if attempt != i+1 {
+			t.Fatalf("Expected attempts to keep counting up, got %v", attempts)
+		}
+	}
+}
+
+// failingWriteConn is a net.Conn whose writes always fail
+type failingWriteConn struct {
+	net.Conn
+}
+
+func (c failingWriteConn) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// TestClientAckWriteFailure tests that a message whose ack write failed is delivered once and acknowledged after reconnecting
+func TestClientAckWriteFailure(t *testing.T) {
+	peers := make(chan net.Conn, 2)
+	var dials int
+	var delivered atomic.Int32
+	client := NewClient(ClientConfig{
+		Dial: func(ctx context.Context) (*Conn, error) {
+			dials++
+			local, remote := net.Pipe()
+			peers <- remote
+			if dials == 1 {
+				return NewConn(failingWriteConn{local}), nil
+			}
+			return NewConn(local), nil
+		},
+		Reconnect: RetryPolicy{InitialInterval: time.Millisecond},
+		OnMessage: func(msg Message) { delivered.Add(1) },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	f, err := EncodeMessage(FrameTypeJSON, &ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: "m1"}, Content: "hello"})
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	first := NewConn(<-peers)
+	defer first.Close()
+	if err := first.WriteFrame(f); err != nil {
+		t.Fatalf("Failed to deliver message: %v", err)
+	}
+
+	// Without an ack the server redelivers on the next connection, where the copy is acknowledged but not delivered again
+	second := NewConn(<-peers)
+	defer second.Close()
+	if err := second.WriteFrame(f); err != nil {
+		t.Fatalf("Failed to redeliver message: %v", err)
+	}
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := second.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read ack: %v", err)
+	}
+	if msg, err := ParseMessage(reply); err != nil || msg.(*Ack).MessageID != "m1" {
+		t.Fatalf("Expected ack for m1, got %v, %v", msg, err)
+	}
+	if n := delivered.Load(); n != 1 {
+		t.Fatalf("Expected the message to be delivered once, got %d", n)
+	}
+}
@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// BodyCodec 消息体序列化接口
+// 每种帧类型对应一个BodyCodec，用于将结构体编解码为帧的消息体
+//
+// 实现要求：
+//   - Unmarshal 应忽略目标结构体中不存在的字段，信封解析依赖这一点先读取操作码
+type BodyCodec interface {
+	// Marshal 序列化
+	Marshal(v any) ([]byte, error)
+	// Unmarshal 反序列化
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec 基于encoding/json的消息体编解码器，默认注册到FrameTypeJSON
+type JSONCodec struct{}
+
+// Marshal 实现BodyCodec接口
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal 实现BodyCodec接口
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// bodyCodecs 帧类型到消息体编解码器的注册表
+var bodyCodecs = struct {
+	mu     sync.RWMutex
+	codecs map[uint8]BodyCodec
+}{
+	codecs: map[uint8]BodyCodec{FrameTypeJSON: JSONCodec{}},
+}
+
+// RegisterBodyCodec 为帧类型注册消息体编解码器，codec为nil时取消注册
+// 本库不引入第三方依赖，Protobuf和MsgPack编解码器需由使用方注册，例如：
+//
+//	RegisterBodyCodec(FrameTypeMsgPack, msgpackCodec{})
+func RegisterBodyCodec(frameType uint8, codec BodyCodec) {
+	bodyCodecs.mu.Lock()
+	defer bodyCodecs.mu.Unlock()
+	if codec == nil {
+		delete(bodyCodecs.codecs, frameType)
+		return
+	}
+	bodyCodecs.codecs[frameType] = codec
+}
+
+// BodyCodecFor 返回帧类型对应的消息体编解码器
+func BodyCodecFor(frameType uint8) (BodyCodec, bool) {
+	bodyCodecs.mu.RLock()
+	defer bodyCodecs.mu.RUnlock()
+	codec, ok := bodyCodecs.codecs[frameType]
+	return codec, ok
+}
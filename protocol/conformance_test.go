@@ -0,0 +1,218 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// conformanceVectorsPath 一致性测试向量文件，供其他语言的实现校验线路格式
+// 文件格式见conformanceFile的字段说明
+var conformanceVectorsPath = filepath.Join("testdata", "conformance", "vectors.json")
+
+// conformanceFile 一致性测试向量文件
+type conformanceFile struct {
+	// Description 文件格式说明
+	Description []string `json:"description"`
+	// MaxMessageLength 帧头中消息体长度（含序列号和签名尾部）的上限
+	MaxMessageLength int `json:"max_message_length"`
+	// Keys 签名校验使用的HMAC-SHA256密钥
+	Keys []conformanceKey `json:"keys"`
+	// ErrorCodes 错误码到名称的映射
+	ErrorCodes map[ErrorCode]string `json:"error_codes"`
+	// Vectors 测试向量
+	Vectors []conformanceVector `json:"vectors"`
+}
+
+// conformanceKey 签名密钥
+type conformanceKey struct {
+	// ID 密钥ID
+	ID uint8 `json:"id"`
+	// Hex 密钥的十六进制
+	Hex string `json:"hex"`
+}
+
+// conformanceFill 重复字节，用于描述很长的消息体而不必写出全部内容
+type conformanceFill struct {
+	// Byte 重复的字节
+	Byte uint8 `json:"byte"`
+	// Count 重复次数
+	Count int `json:"count"`
+}
+
+// conformanceVector 一个测试向量：输入字节，以及期望的解码结果或错误码
+type conformanceVector struct {
+	// Name 向量名称
+	Name string `json:"name"`
+	// Description 向量说明
+	Description string `json:"description"`
+	// Hex 输入字节的十六进制
+	Hex string `json:"hex"`
+	// Fill 追加在Hex之后的重复字节，可选
+	Fill *conformanceFill `json:"fill,omitempty"`
+	// Verify 是否使用Keys中的所有密钥校验签名
+	Verify bool `json:"verify,omitempty"`
+	// Error 期望的错误码，为0时期望解码成功
+	Error ErrorCode `json:"error,omitempty"`
+	// Expect 期望的解码结果
+	Expect *conformanceFrame `json:"expect,omitempty"`
+	// Canonical 重新编码Expect是否必须得到与输入完全相同的字节
+	Canonical bool `json:"canonical,omitempty"`
+}
+
+// conformanceFrame 期望的解码结果
+type conformanceFrame struct {
+	// Length 帧在输入中占用的字节数
+	Length int `json:"length"`
+	// Version 版本号
+	Version uint8 `json:"version"`
+	// SubVersion 子版本号
+	SubVersion uint8 `json:"subversion"`
+	// Type 帧类型（不含扩展标志位）
+	Type uint8 `json:"type"`
+	// Sequence 序列号，未携带时为0
+	Sequence uint64 `json:"sequence"`
+	// Signed 是否携带并通过了签名校验
+	Signed bool `json:"signed"`
+	// KeyID 签名密钥ID
+	KeyID uint8 `json:"key_id"`
+	// BodyHex 消息体的十六进制，Fill同样追加在其后
+	BodyHex string `json:"body_hex"`
+}
+
+// input 返回向量的输入字节
+func (v *conformanceVector) input(t *testing.T) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(v.Hex)
+	if err != nil {
+		t.Fatalf("Failed to decode hex: %v", err)
+	}
+	return appendFill(data, v.Fill)
+}
+
+// appendFill 追加重复字节
+func appendFill(data []byte, fill *conformanceFill) []byte {
+	if fill == nil {
+		return data
+	}
+	return append(data, bytes.Repeat([]byte{fill.Byte}, fill.Count)...)
+}
+
+// loadConformanceVectors 读取一致性测试向量
+func loadConformanceVectors(t *testing.T) *conformanceFile {
+	t.Helper()
+	data, err := os.ReadFile(conformanceVectorsPath)
+	if err != nil {
+		t.Fatalf("Failed to read vectors: %v", err)
+	}
+	var file conformanceFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Failed to parse vectors: %v", err)
+	}
+	return &file
+}
+
+// TestConformanceVectors tests Decode, StreamDecoder and Encode against the golden wire format vectors
+func TestConformanceVectors(t *testing.T) {
+	file := loadConformanceVectors(t)
+	if file.MaxMessageLength != MaxMessageLength {
+		t.Fatalf("Vectors assume max message length %d, got %d", file.MaxMessageLength, MaxMessageLength)
+	}
+	for code := ErrCodeUnknown; code <= ErrCodeInvalidMessage; code++ {
+		if file.ErrorCodes[code] == "" {
+			t.Errorf("Error code %d is missing from the vectors file", code)
+		}
+	}
+
+	// signers 按密钥ID签名，verifier 持有所有密钥用于校验
+	signers := make(map[uint8]*FrameSigner)
+	var verifier *FrameSigner
+	for _, key := range file.Keys {
+		secret, err := hex.DecodeString(key.Hex)
+		if err != nil {
+			t.Fatalf("Failed to decode key %d: %v", key.ID, err)
+		}
+		if signers[key.ID], err = NewFrameSigner(key.ID, secret); err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		if verifier == nil {
+			verifier, _ = NewFrameSigner(key.ID, secret)
+		} else if err := verifier.AddKey(key.ID, secret); err != nil {
+			t.Fatalf("Failed to add key: %v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, v := range file.Vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			if names[v.Name] {
+				t.Fatalf("Duplicate vector name")
+			}
+			names[v.Name] = true
+			if (v.Error == 0) == (v.Expect == nil) {
+				t.Fatalf("Vector must have exactly one of error and expect")
+			}
+
+			var options []DecodeOption
+			if v.Verify {
+				options = append(options, WithSignatureVerifier(verifier))
+			}
+			data := v.input(t)
+			frame, err := Decode(data, options...)
+			if v.Error != 0 {
+				if code := GetErrorCode(err); code != v.Error {
+					t.Fatalf("Expected error %d (%s), got %v", v.Error, file.ErrorCodes[v.Error], err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+
+			body, _ := hex.DecodeString(v.Expect.BodyHex)
+			want := &Frame{
+				Version:    v.Expect.Version,
+				SubVersion: v.Expect.SubVersion,
+				Type:       v.Expect.Type,
+				Body:       appendFill(body, v.Fill),
+				Signed:     v.Expect.Signed,
+				KeyID:      v.Expect.KeyID,
+				Sequence:   v.Expect.Sequence,
+			}
+			want.bodyLength = uint32(len(want.Body))
+			expectSameFrame(t, want, frame)
+
+			// A stream decoder fed one byte at a time consumes exactly Length bytes
+			decoder := NewStreamDecoder()
+			decoder.SetDecodeOptions(options...)
+			var streamed *Frame
+			for i := 0; i < v.Expect.Length && streamed == nil; i++ {
+				decoder.Feed(data[i : i+1])
+				if streamed, err = decoder.TryDecode(); err != nil {
+					t.Fatalf("Stream decoder failed at byte %d: %v", i, err)
+				}
+			}
+			if streamed == nil {
+				t.Fatalf("Stream decoder did not produce a frame after %d bytes", v.Expect.Length)
+			}
+			expectSameFrame(t, want, streamed)
+
+			if v.Canonical {
+				var encodeOptions []EncodeOption
+				if want.Signed {
+					encodeOptions = append(encodeOptions, WithSigner(signers[want.KeyID]))
+				}
+				encoded, err := want.Encode(encodeOptions...)
+				if err != nil {
+					t.Fatalf("Failed to encode: %v", err)
+				}
+				if !bytes.Equal(encoded, data[:v.Expect.Length]) {
+					t.Fatalf("Encoding differs from vector:\nwant %x\n got %x", data[:min(len(data), 64)], encoded[:min(len(encoded), 64)])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,550 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alpnPrefix ALPN协议名前缀，完整协议名形如 "im/1"
+const alpnPrefix = "im/"
+
+// DefaultHandshakeTimeout ReadFrame、WriteFrame等隐式执行TLS握手时的超时
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// ALPNProtocol 返回协议版本对应的ALPN协议名
+func ALPNProtocol(version uint8) string {
+	return alpnPrefix + strconv.Itoa(int(version))
+}
+
+// ALPNProtocols 返回SupportedVersions对应的ALPN协议名列表
+// 按版本从新到旧排列，TLS服务端按此顺序优先选择较新的版本
+func ALPNProtocols() []string {
+	protos := make([]string, 0, len(SupportedVersions))
+	for i := len(SupportedVersions) - 1; i >= 0; i-- {
+		protos = append(protos, ALPNProtocol(SupportedVersions[i]))
+	}
+	return protos
+}
+
+// parseALPNProtocol 解析ALPN协议名对应的协议版本
+func parseALPNProtocol(proto string) (uint8, bool) {
+	if !strings.HasPrefix(proto, alpnPrefix) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(proto[len(alpnPrefix):], 10, 8)
+	if err != nil || !isSupportedVersion(uint8(v)) {
+		return 0, false
+	}
+	return uint8(v), true
+}
+
+// withALPN 复制TLS配置，未设置NextProtos时填入ALPNProtocols
+func withALPN(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = ALPNProtocols()
+	}
+	return config
+}
+
+// Conn 按帧读写的连接
+// 读取基于StreamDecoder处理粘包/拆包，写入基于Frame.EncodeTo
+//
+// 使用示例：
+//
+//	conn, err := Dial(ctx, "im.example.com:443", &tls.Config{ServerName: "im.example.com"})
+//	frame, _ := NewFrame(FrameTypeJSON, body, WithVersion(conn.Version()))
+//	err = conn.WriteFrame(frame)
+//	reply, err := conn.ReadFrame()
+//
+// 并发安全说明：
+// ReadFrame 与 WriteFrame 可以在不同协程中同时调用；
+// 多个协程同时调用ReadFrame（或WriteFrame）时按调用顺序串行执行
+type Conn struct {
+	// conn 底层网络连接，TLS连接时为*tls.Conn
+	conn net.Conn
+	// decoder 流式解码器
+	decoder *StreamDecoder
+	// readMu 读锁，保证帧读取的完整性
+	readMu sync.Mutex
+	// writeMu 写锁，保证帧写入不交错
+	writeMu sync.Mutex
+	// encodeOptions 写入每一帧时使用的编码期选项
+	encodeOptions []EncodeOption
+	// limiter 读取路径上的限流器，为nil时不限流
+	limiter *RateLimiter
+	// closed 调用Close后关闭，用于唤醒等待限流延迟的读取
+	closed chan struct{}
+	// closeOnce 保证closed只关闭一次
+	closeOnce sync.Once
+	// deadlineMu 保护以下截止时间，并保证它们按顺序设置到底层连接
+	deadlineMu sync.Mutex
+	// readDeadline 调用方通过SetReadDeadline/SetDeadline设置的读截止时间
+	readDeadline time.Time
+	// frameDeadline 慢速攻击防护为不完整帧设置的截止时间，零值表示没有
+	frameDeadline time.Time
+	// writeDeadline 调用方通过SetWriteDeadline/SetDeadline设置的写截止时间
+	writeDeadline time.Time
+	// timeoutDeadline 单次写入超时对应的截止时间，零值表示没有
+	timeoutDeadline time.Time
+
+	// handshakeMu 串行化TLS握手，握手期间不持有mu，避免阻塞Principal等访问
+	handshakeMu sync.Mutex
+	// handshakeTimeout 隐式握手的超时
+	handshakeTimeout time.Duration
+
+	// mu 保护以下字段
+	mu sync.Mutex
+	// handshakeDone TLS握手是否已完成（非TLS连接始终为true）
+	handshakeDone bool
+	// version 协商出的协议版本
+	version uint8
+	// principal 认证成功后附加的主体
+	principal *Principal
+}
+
+// NewConn 将已建立的网络连接包装为按帧读写的连接
+// 若nc为*tls.Conn，协议版本在TLS握手后根据ALPN确定，否则为CurrentProtocolVersion
+func NewConn(nc net.Conn) *Conn {
+	_, isTLS := nc.(*tls.Conn)
+	return &Conn{
+		conn:             nc,
+		decoder:          NewStreamDecoder(),
+		closed:           make(chan struct{}),
+		handshakeTimeout: DefaultHandshakeTimeout,
+		handshakeDone:    !isTLS,
+		version:          CurrentProtocolVersion,
+	}
+}
+
+// Handshake 执行TLS握手并根据ALPN确定协议版本
+// 非TLS连接或已握手时直接返回；ReadFrame/WriteFrame之前无需显式调用，
+// 隐式握手的超时为DefaultHandshakeTimeout
+func (c *Conn) Handshake(ctx context.Context) error {
+	if c.isHandshakeDone() {
+		return nil
+	}
+	c.handshakeMu.Lock()
+	defer c.handshakeMu.Unlock()
+	if c.isHandshakeDone() {
+		return nil
+	}
+
+	tlsConn := c.conn.(*tls.Conn)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	proto := tlsConn.ConnectionState().NegotiatedProtocol
+	if proto != "" {
+		version, ok := parseALPNProtocol(proto)
+		if !ok {
+			return &ProtocolError{
+				Code:    ErrCodeUnsupportedVersion,
+				Message: fmt.Sprintf("unsupported ALPN protocol %q, supported protocols: %v", proto, ALPNProtocols()),
+			}
+		}
+		c.version = version
+	}
+	c.handshakeDone = true
+	return nil
+}
+
+// isHandshakeDone 返回TLS握手是否已完成
+func (c *Conn) isHandshakeDone() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handshakeDone
+}
+
+// implicitHandshake 在读写前执行握手，最多等待handshakeTimeout
+func (c *Conn) implicitHandshake() error {
+	if c.isHandshakeDone() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.handshakeTimeout)
+	defer cancel()
+	return c.Handshake(ctx)
+}
+
+// Version 返回协商出的协议版本
+// TLS连接未握手时会先执行握手，握手失败返回CurrentProtocolVersion
+func (c *Conn) Version() uint8 {
+	_ = c.implicitHandshake()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// SetEncodeOptions 设置写入每一帧时使用的编码期选项（如签名）
+func (c *Conn) SetEncodeOptions(options ...EncodeOption) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.encodeOptions = options
+}
+
+// SetDecodeOptions 设置读取每一帧时使用的解码期选项（如签名校验、重放检测）
+func (c *Conn) SetDecodeOptions(options ...DecodeOption) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.decoder.SetDecodeOptions(options...)
+}
+
+// SetFrameTimeout 设置慢速攻击防护，限制帧头到达后完成整帧的时间和最低接收速率
+func (c *Conn) SetFrameTimeout(config FrameTimeoutConfig) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.decoder.SetFrameTimeout(config)
+}
+
+// SetRateLimiter 设置读取路径上的限流器，nil表示不限流
+func (c *Conn) SetRateLimiter(limiter *RateLimiter) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.limiter = limiter
+}
+
+// ReadFrame 读取一个完整的帧，阻塞直到数据足够或出错
+// 连接在帧边界处关闭时返回io.EOF
+//
+// 设置了限流器时，超限的帧按限流动作处理：
+//   - RateLimitDrop：丢弃并继续读取下一帧
+//   - RateLimitDelay：等待配额后返回该帧，等待期间连接关闭或到达读截止时间则丢弃该帧并返回错误
+//     等待期间仍持有读锁，并发调用ReadFrame时按帧到达的顺序返回
+//   - RateLimitErrorFrame：丢弃并回复错误帧，继续读取下一帧
+//   - RateLimitDisconnect：关闭连接，返回ErrCodeRateLimited错误
+func (c *Conn) ReadFrame() (*Frame, error) {
+	if err := c.implicitHandshake(); err != nil {
+		return nil, err
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		frame, err := c.decoder.ReadFrame(connReader{c})
+		if err != nil || c.limiter == nil {
+			return frame, err
+		}
+
+		decision := c.limiter.Check(frame)
+		if decision.Allowed {
+			return frame, nil
+		}
+
+		switch decision.Action {
+		case RateLimitDelay:
+			if err := c.waitDelay(decision.Delay); err != nil {
+				return nil, err
+			}
+			return frame, nil
+		case RateLimitErrorFrame:
+			errFrame, err := NewErrorFrame(NewRateLimitedError(frame.Type))
+			if err != nil {
+				return nil, err
+			}
+			if err := c.WriteFrame(errFrame); err != nil {
+				return nil, err
+			}
+		case RateLimitDisconnect:
+			c.Close()
+			return nil, NewRateLimitedError(frame.Type)
+		}
+	}
+}
+
+// waitDelay 等待限流延迟，连接关闭时返回net.ErrClosed，延迟超过读截止时间时在截止时间返回os.ErrDeadlineExceeded
+func (c *Conn) waitDelay(delay time.Duration) error {
+	c.deadlineMu.Lock()
+	deadline := c.readDeadline
+	c.deadlineMu.Unlock()
+
+	var expired bool
+	if !deadline.IsZero() {
+		if remaining := time.Until(deadline); remaining < delay {
+			delay, expired = max(remaining, 0), true
+		}
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	case <-timer.C:
+		if expired {
+			return os.ErrDeadlineExceeded
+		}
+		return nil
+	}
+}
+
+// bufferedFrame 从解码器缓冲区中取出一个已完整到达的帧，不读取连接，没有时返回nil, nil
+func (c *Conn) bufferedFrame() (*Frame, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.decoder.TryDecode()
+}
+
+// WriteFrame 编码并写入一个帧
+func (c *Conn) WriteFrame(f *Frame) error {
+	if err := c.implicitHandshake(); err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := f.EncodeTo(c.conn, c.encodeOptions...)
+	return err
+}
+
+// WriteEncoded 写入已编码的完整帧数据，用于广播时多个连接复用同一份编码结果
+// 不会再应用连接的编码选项（如签名），data应由调用方按需编码
+func (c *Conn) WriteEncoded(data []byte) error {
+	if err := c.implicitHandshake(); err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// writeEncodedTimeout 与WriteEncoded相同，但本次写入最多等待timeout，timeout<=0时不限制
+// 超时只会收紧调用方设置的写截止时间，写完后恢复原值
+func (c *Conn) writeEncodedTimeout(data []byte, timeout time.Duration) error {
+	if timeout <= 0 {
+		return c.WriteEncoded(data)
+	}
+	if err := c.implicitHandshake(); err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.setTimeoutDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	if resetErr := c.setTimeoutDeadline(time.Time{}); err == nil {
+		err = resetErr
+	}
+	return err
+}
+
+// ErrorMessage 错误帧的消息体，以JSON编码承载于FrameTypeJSON帧中
+type ErrorMessage struct {
+	// Code 错误码
+	Code ErrorCode `json:"code"`
+	// Error 错误描述
+	Error string `json:"error"`
+}
+
+// NewErrorFrame 创建通知对端错误的FrameTypeJSON帧
+// 消息体格式：{"code":10,"error":"rate limited: ..."}
+func NewErrorFrame(err error) (*Frame, error) {
+	body, marshalErr := json.Marshal(&ErrorMessage{Code: GetErrorCode(err), Error: err.Error()})
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return NewFrame(FrameTypeJSON, body, WithZeroCopy(true))
+}
+
+// Principal 返回附加在连接上的认证主体，未认证时返回nil
+func (c *Conn) Principal() *Principal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.principal
+}
+
+// SetPrincipal 在认证成功后将主体附加到连接上
+func (c *Conn) SetPrincipal(p *Principal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.principal = p
+}
+
+// NetConn 返回底层网络连接
+// 注意：直接读取底层连接会绕过内部解码缓冲区
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Close 关闭连接
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.conn.Close()
+}
+
+// LocalAddr 返回本地地址
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr 返回远端地址
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline 设置读写截止时间
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读截止时间
+// 慢速攻击防护等待不完整帧时只会收紧截止时间，不会覆盖这里设置的值
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.applyReadDeadline()
+}
+
+// callerReadDeadline 返回调用方通过SetReadDeadline/SetDeadline设置的读截止时间
+func (c *Conn) callerReadDeadline() time.Time {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	return c.readDeadline
+}
+
+// setFrameDeadline 设置不完整帧的截止时间，零值表示清除
+func (c *Conn) setFrameDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.frameDeadline = t
+	return c.applyReadDeadline()
+}
+
+// applyReadDeadline 将调用方截止时间与帧截止时间中较早的一个设置到底层连接，调用方需持有deadlineMu
+func (c *Conn) applyReadDeadline() error {
+	deadline := c.readDeadline
+	if !c.frameDeadline.IsZero() && (deadline.IsZero() || c.frameDeadline.Before(deadline)) {
+		deadline = c.frameDeadline
+	}
+	return c.conn.SetReadDeadline(deadline)
+}
+
+// connReader Conn传给StreamDecoder的reader，读取底层连接并通过Conn合并读截止时间
+type connReader struct {
+	c *Conn
+}
+
+func (r connReader) Read(p []byte) (int, error) {
+	return r.c.conn.Read(p)
+}
+
+func (r connReader) setFrameDeadline(t time.Time) error {
+	return r.c.setFrameDeadline(t)
+}
+
+// SetWriteDeadline 设置写截止时间
+// 单次写入超时（如Broker的WriteTimeout）只会收紧截止时间，不会覆盖这里设置的值
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.writeDeadline = t
+	return c.applyWriteDeadline()
+}
+
+// setTimeoutDeadline 设置单次写入超时的截止时间，零值表示清除
+func (c *Conn) setTimeoutDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.timeoutDeadline = t
+	return c.applyWriteDeadline()
+}
+
+// applyWriteDeadline 将调用方截止时间与写入超时截止时间中较早的一个设置到底层连接，调用方需持有deadlineMu
+func (c *Conn) applyWriteDeadline() error {
+	deadline := c.writeDeadline
+	if !c.timeoutDeadline.IsZero() && (deadline.IsZero() || c.timeoutDeadline.Before(deadline)) {
+		deadline = c.timeoutDeadline
+	}
+	return c.conn.SetWriteDeadline(deadline)
+}
+
+// Listener 接受按帧读写连接的监听器
+type Listener struct {
+	// ln 底层监听器
+	ln net.Listener
+}
+
+// Listen 在指定地址上监听
+//
+// 参数：
+//
+//	network - 网络类型，如"tcp"、"tcp4"、"unix"
+//	addr - 监听地址
+//	config - TLS配置，为nil时使用明文连接；未设置NextProtos时自动通过ALPN通告SupportedVersions
+func Listen(network, addr string, config *tls.Config) (*Listener, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, withALPN(config))
+	}
+	return &Listener{ln: ln}, nil
+}
+
+// Accept 等待并返回下一个连接
+// TLS握手在连接首次读写或调用Handshake时进行，不会阻塞Accept
+func (l *Listener) Accept() (*Conn, error) {
+	nc, err := l.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(nc), nil
+}
+
+// Close 关闭监听器
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+// Addr 返回监听地址
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Dial 建立到addr的TCP连接，并在返回前完成TLS握手
+//
+// 参数：
+//
+//	ctx - 控制拨号和握手的超时与取消
+//	addr - 目标地址
+//	config - TLS配置，为nil时使用明文连接；未设置NextProtos时自动通过ALPN通告SupportedVersions
+func Dial(ctx context.Context, addr string, config *tls.Config) (*Conn, error) {
+	var nc net.Conn
+	var err error
+	if config != nil {
+		dialer := &tls.Dialer{Config: withALPN(config)}
+		nc, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		var dialer net.Dialer
+		nc, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := NewConn(nc)
+	if err := conn.Handshake(ctx); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("handshake with %s: %w", addr, err)
+	}
+	return conn, nil
+}
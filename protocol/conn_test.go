@@ -0,0 +1,259 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// newSelfSignedTLSConfigs generates an in-process self-signed certificate
+// and returns matching server and client TLS configs
+func newSelfSignedTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	return server, client
+}
+
+// echoOnce accepts one connection and echoes frames until it is closed
+// configure, if not nil, is applied to the accepted connection
+func echoOnce(t *testing.T, ln *Listener, configure func(*Conn)) {
+	t.Helper()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if configure != nil {
+			configure(conn)
+		}
+		for {
+			frame, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteFrame(frame); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// TestTLSListenDial tests framed connections over TLS with ALPN version negotiation
+func TestTLSListenDial(t *testing.T) {
+	serverConfig, clientConfig := newSelfSignedTLSConfigs(t)
+
+	ln, err := Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	echoOnce(t, ln, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := Dial(ctx, ln.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// The newest supported version is preferred
+	newest := SupportedVersions[len(SupportedVersions)-1]
+	if conn.Version() != newest {
+		t.Errorf("Expected negotiated version %d, got %d", newest, conn.Version())
+	}
+
+	state := conn.NetConn().(*tls.Conn).ConnectionState()
+	if state.NegotiatedProtocol != ALPNProtocol(newest) {
+		t.Errorf("Expected ALPN protocol %q, got %q", ALPNProtocol(newest), state.NegotiatedProtocol)
+	}
+
+	for _, body := range []string{"hello", "world"} {
+		frame, err := NewFrame(FrameTypeJSON, []byte(body), WithVersion(conn.Version()))
+		if err != nil {
+			t.Fatalf("Failed to create frame: %v", err)
+		}
+		if err := conn.WriteFrame(frame); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+		reply, err := conn.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if reply.Version != newest || !bytes.Equal(reply.Body, frame.Body) {
+			t.Errorf("Unexpected echoed frame: %v", reply)
+		}
+	}
+
+	// A client restricted to V1 negotiates V1
+	v1Config := clientConfig.Clone()
+	v1Config.NextProtos = []string{ALPNProtocol(ProtocolVersionV1)}
+	echoOnce(t, ln, nil)
+	v1Conn, err := Dial(ctx, ln.Addr().String(), v1Config)
+	if err != nil {
+		t.Fatalf("Failed to dial with V1 only: %v", err)
+	}
+	defer v1Conn.Close()
+	if v1Conn.Version() != ProtocolVersionV1 {
+		t.Errorf("Expected negotiated version %d, got %d", ProtocolVersionV1, v1Conn.Version())
+	}
+}
+
+// TestPlainListenDial tests framed connections without TLS
+func TestPlainListenDial(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	signer, err := NewFrameSigner(1, []byte("key"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	echoOnce(t, ln, func(conn *Conn) {
+		conn.SetEncodeOptions(WithSigner(signer))
+		conn.SetDecodeOptions(WithSignatureVerifier(signer))
+	})
+
+	conn, err := Dial(context.Background(), ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if conn.Version() != CurrentProtocolVersion {
+		t.Errorf("Expected version %d, got %d", CurrentProtocolVersion, conn.Version())
+	}
+	conn.SetEncodeOptions(WithSigner(signer))
+	conn.SetDecodeOptions(WithSignatureVerifier(signer))
+
+	frame, err := NewFrame(FrameTypeMsgPack, []byte{0x81, 0xa1, 0x61, 0x01})
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+	if err := conn.WriteFrame(frame); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	reply, err := conn.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if !reply.Signed || !bytes.Equal(reply.Body, frame.Body) {
+		t.Errorf("Unexpected echoed frame: %v", reply)
+	}
+}
+
+// TestConnWriteTimeoutKeepsCallerDeadline tests that a per-write timeout tightens but never replaces the caller's write deadline
+func TestConnWriteTimeoutKeepsCallerDeadline(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := NewConn(local)
+	defer conn.Close()
+	data := []byte("frame")
+
+	// The earlier caller deadline wins over a longer timeout
+	conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if err := conn.writeEncodedTimeout(data, time.Hour); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the caller deadline to expire, got %v", err)
+	}
+
+	// After a write that timed out, the caller deadline is restored rather than cleared
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if err := conn.writeEncodedTimeout(data, 10*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the write timeout to expire, got %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- conn.WriteEncoded(data) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Expected the restored caller deadline to expire, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write blocked past the caller deadline")
+	}
+}
+
+// TestConnHandshakeDoesNotBlockPrincipal tests that a stalled TLS handshake neither blocks other Conn accessors nor implicit reads forever
+func TestConnHandshakeDoesNotBlockPrincipal(t *testing.T) {
+	serverTLS, _ := newSelfSignedTLSConfigs(t)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := NewConn(tls.Server(local, serverTLS))
+	defer conn.Close()
+	conn.handshakeTimeout = 50 * time.Millisecond
+
+	// The peer never sends a ClientHello
+	read := make(chan error, 1)
+	go func() {
+		_, err := conn.ReadFrame()
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		conn.SetPrincipal(&Principal{ID: "alice"})
+		conn.Principal()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Principal blocked behind the TLS handshake")
+	}
+
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Fatal("Expected the stalled handshake to fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Implicit handshake was not bounded by a timeout")
+	}
+}
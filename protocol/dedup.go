@@ -0,0 +1,283 @@
+package protocol
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// 去重缓存默认值
+const (
+	// DefaultDedupCapacity 默认记录的消息ID数量
+	DefaultDedupCapacity = 4096
+	// DefaultDedupTTL 消息ID的默认保留时间
+	DefaultDedupTTL = 10 * time.Minute
+	// DefaultBloomHashes 布隆过滤器默认的哈希函数个数
+	DefaultBloomHashes = 4
+)
+
+// DedupConfig 去重缓存配置，零值字段使用默认值
+type DedupConfig struct {
+	// Capacity LRU精确记录的消息ID数量上限，默认DefaultDedupCapacity
+	Capacity int
+	// TTL 消息ID的保留时间，默认DefaultDedupTTL
+	TTL time.Duration
+	// BloomBits 布隆过滤器每一代的位数，0表示不启用
+	// 启用后被LRU淘汰的ID仍会在1~2个TTL内被识别为重复，代价是存在误判
+	BloomBits int
+	// BloomHashes 布隆过滤器的哈希函数个数，默认DefaultBloomHashes
+	BloomHashes int
+	// Key 从帧中提取去重键，返回false的帧不参与去重，默认EnvelopeMessageID
+	Key func(f *Frame) (string, bool)
+}
+
+// DedupStats 去重统计
+type DedupStats struct {
+	// Hits LRU命中（确定重复）的次数
+	Hits uint64
+	// BloomHits LRU未命中但布隆过滤器命中（可能重复）的次数
+	BloomHits uint64
+	// Misses 未命中（新消息）的次数
+	Misses uint64
+	// Skipped 无法提取去重键而跳过的帧数
+	Skipped uint64
+	// Evictions 因超出容量被淘汰的记录数
+	Evictions uint64
+	// Expired 因超过保留时间被淘汰的记录数
+	Expired uint64
+}
+
+// dedupEntry LRU中的一条记录
+type dedupEntry struct {
+	// id 消息ID
+	id string
+	// seen 首次出现的时间
+	seen time.Time
+}
+
+// bloomFilter 简单的位图布隆过滤器，使用双重哈希生成k个位置
+type bloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes int
+}
+
+func newBloomFilter(bits, hashes int) *bloomFilter {
+	words := (bits + 63) / 64
+	return &bloomFilter{bits: make([]uint64, words), size: uint64(words * 64), hashes: hashes}
+}
+
+// positions 返回id对应的k个位
+func (b *bloomFilter) positions(id string, fn func(pos uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h1 := h.Sum64()
+	h2 := h1>>33 | h1<<31 | 1
+	for i := 0; i < b.hashes; i++ {
+		if !fn((h1 + uint64(i)*h2) % b.size) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) add(id string) {
+	b.positions(id, func(pos uint64) bool {
+		b.bits[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+}
+
+func (b *bloomFilter) contains(id string) bool {
+	return b.positions(id, func(pos uint64) bool {
+		return b.bits[pos/64]&(1<<(pos%64)) != 0
+	})
+}
+
+func (b *bloomFilter) clear() {
+	clear(b.bits)
+}
+
+// DedupCache 基于消息ID的去重缓存，用于至少一次投递场景下丢弃重传或多路径产生的重复帧
+// LRU精确记录最近的消息ID并按TTL过期；可选的布隆过滤器按TTL分代轮换，
+// 以固定内存延长去重范围
+//
+// 使用示例：
+//
+//	cache := NewDedupCache(DedupConfig{Capacity: 10000, TTL: 5 * time.Minute})
+//	decoder.SetDedupCache(cache) // TryDecode自动丢弃重复帧
+//
+// 并发安全说明：
+// DedupCache 的所有方法均为并发安全，可在多个连接间共享
+type DedupCache struct {
+	// mu 互斥锁，保护以下所有字段
+	mu sync.Mutex
+	// config 去重配置
+	config DedupConfig
+	// lru 按最近使用排序的记录，最近使用的在前
+	lru *list.List
+	// items 消息ID到LRU记录的索引
+	items map[string]*list.Element
+	// bloom 当前代与上一代布隆过滤器，未启用时为nil
+	bloom [2]*bloomFilter
+	// bloomRotated 上次轮换布隆过滤器的时间
+	bloomRotated time.Time
+	// stats 统计
+	stats DedupStats
+	// now 时钟，便于测试替换
+	now func() time.Time
+}
+
+// NewDedupCache 创建去重缓存
+func NewDedupCache(config DedupConfig) *DedupCache {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultDedupCapacity
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultDedupTTL
+	}
+	if config.BloomHashes <= 0 {
+		config.BloomHashes = DefaultBloomHashes
+	}
+	if config.Key == nil {
+		config.Key = EnvelopeMessageID
+	}
+
+	c := &DedupCache{
+		config: config,
+		lru:    list.New(),
+		items:  make(map[string]*list.Element),
+		now:    time.Now,
+	}
+	if config.BloomBits > 0 {
+		c.bloom = [2]*bloomFilter{
+			newBloomFilter(config.BloomBits, config.BloomHashes),
+			newBloomFilter(config.BloomBits, config.BloomHashes),
+		}
+	}
+	return c
+}
+
+// Seen 记录消息ID，在保留期内已出现过时返回true
+func (c *DedupCache) Seen(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.expire(now)
+	c.rotateBloom(now)
+
+	if elem, ok := c.items[id]; ok {
+		if now.Sub(elem.Value.(*dedupEntry).seen) < c.config.TTL {
+			c.lru.MoveToFront(elem)
+			c.stats.Hits++
+			return true
+		}
+		c.remove(elem)
+		c.stats.Expired++
+	} else if c.bloom[0] != nil && (c.bloom[0].contains(id) || c.bloom[1].contains(id)) {
+		c.stats.BloomHits++
+		return true
+	}
+
+	c.stats.Misses++
+	c.items[id] = c.lru.PushFront(&dedupEntry{id: id, seen: now})
+	if c.bloom[0] != nil {
+		c.bloom[0].add(id)
+	}
+	for c.lru.Len() > c.config.Capacity {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return false
+}
+
+// IsDuplicate 按配置的Key提取帧的去重键并记录，重复时返回true
+// 无法提取去重键的帧不视为重复
+func (c *DedupCache) IsDuplicate(f *Frame) bool {
+	id, ok := c.config.Key(f)
+	if !ok {
+		c.mu.Lock()
+		c.stats.Skipped++
+		c.mu.Unlock()
+		return false
+	}
+	return c.Seen(id)
+}
+
+// expire 从LRU尾部淘汰过期记录
+// 命中的记录会被移到头部，因此尾部之外仍可能有过期记录，由Seen在命中时处理
+// 调用方必须持有锁
+func (c *DedupCache) expire(now time.Time) {
+	for elem := c.lru.Back(); elem != nil; elem = c.lru.Back() {
+		if now.Sub(elem.Value.(*dedupEntry).seen) < c.config.TTL {
+			return
+		}
+		c.remove(elem)
+		c.stats.Expired++
+	}
+}
+
+// rotateBloom 每个TTL轮换一次布隆过滤器，丢弃上一代
+// 调用方必须持有锁
+func (c *DedupCache) rotateBloom(now time.Time) {
+	if c.bloom[0] == nil {
+		return
+	}
+	if c.bloomRotated.IsZero() {
+		c.bloomRotated = now
+		return
+	}
+	if now.Sub(c.bloomRotated) < c.config.TTL {
+		return
+	}
+	// 超过两个TTL未轮换时两代都已过期
+	if now.Sub(c.bloomRotated) >= 2*c.config.TTL {
+		c.bloom[0].clear()
+	}
+	c.bloom[0], c.bloom[1] = c.bloom[1], c.bloom[0]
+	c.bloom[0].clear()
+	c.bloomRotated = now
+}
+
+// remove 删除一条LRU记录
+// 调用方必须持有锁
+func (c *DedupCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.items, elem.Value.(*dedupEntry).id)
+}
+
+// Len 返回LRU中的记录数
+func (c *DedupCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Stats 返回去重统计的快照
+func (c *DedupCache) Stats() DedupStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Reset 清空所有记录，统计保留
+func (c *DedupCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.items)
+	if c.bloom[0] != nil {
+		c.bloom[0].clear()
+		c.bloom[1].clear()
+	}
+	c.bloomRotated = time.Time{}
+}
+
+// SetDedupCache 设置去重缓存，TryDecode和ReadFrame会丢弃重复的帧
+// 设置为nil时关闭去重
+func (sd *StreamDecoder) SetDedupCache(cache *DedupCache) {
+	sd.dedup = cache
+}
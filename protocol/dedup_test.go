@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestDedupCacheLRUAndTTL tests exact deduplication with capacity and TTL eviction
+func TestDedupCacheLRUAndTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cache := NewDedupCache(DedupConfig{Capacity: 2, TTL: time.Minute})
+	cache.now = clock.Now
+
+	if cache.Seen("a") || cache.Seen("b") {
+		t.Fatal("Expected first sightings not to be duplicates")
+	}
+	if !cache.Seen("a") {
+		t.Fatal("Expected duplicate of a")
+	}
+
+	// "a" was just used, so "b" is the least recently used and gets evicted
+	cache.Seen("c")
+	if !cache.Seen("a") {
+		t.Error("Expected recently used a to survive eviction")
+	}
+	if cache.Seen("b") {
+		t.Error("Expected evicted b not to be a duplicate")
+	}
+
+	clock.Advance(time.Minute)
+	if cache.Seen("a") {
+		t.Error("Expected expired a not to be a duplicate")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 5 || stats.Evictions == 0 || stats.Expired == 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+// TestDedupCacheBloom tests that the bloom filter remembers IDs evicted from the LRU
+func TestDedupCacheBloom(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	cache := NewDedupCache(DedupConfig{Capacity: 1, TTL: time.Minute, BloomBits: 1 << 16})
+	cache.now = clock.Now
+
+	cache.Seen("a")
+	cache.Seen("b") // evicts "a" from the LRU
+	if !cache.Seen("a") {
+		t.Fatal("Expected bloom filter to report evicted a as duplicate")
+	}
+	if cache.Stats().BloomHits != 1 {
+		t.Errorf("Expected 1 bloom hit, got %d", cache.Stats().BloomHits)
+	}
+
+	// Survives one rotation, forgotten after two
+	clock.Advance(time.Minute)
+	cache.Seen("c")
+	if !cache.Seen("a") {
+		t.Error("Expected a to be remembered by the previous generation")
+	}
+	clock.Advance(time.Minute)
+	if cache.Seen("a") {
+		t.Error("Expected a to be forgotten after two rotations")
+	}
+
+	// False positive rate stays low at this size
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if cache.Seen(fmt.Sprintf("id-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 10 {
+		t.Errorf("Too many false positives: %d", falsePositives)
+	}
+}
+
+// TestStreamDecoderDedup tests that duplicate frames are dropped after TryDecode
+func TestStreamDecoderDedup(t *testing.T) {
+	decoder := NewStreamDecoder()
+	cache := NewDedupCache(DedupConfig{})
+	decoder.SetDedupCache(cache)
+
+	for _, id := range []string{"m1", "m1", "m2", "m1"} {
+		frame, err := EncodeMessage(FrameTypeJSON, &ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: id}})
+		if err != nil {
+			t.Fatalf("Failed to encode message: %v", err)
+		}
+		data, err := frame.Encode()
+		if err != nil {
+			t.Fatalf("Failed to encode frame: %v", err)
+		}
+		decoder.Feed(data)
+	}
+	// Frames without a registered body codec pass through
+	plain, _ := NewFrame(FrameTypeProtobuf, []byte{1, 2, 3})
+	data, _ := plain.Encode()
+	decoder.Feed(data)
+
+	var ids []string
+	for {
+		frame, err := decoder.TryDecode()
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		if frame == nil {
+			break
+		}
+		id, _ := EnvelopeMessageID(frame)
+		ids = append(ids, id)
+	}
+
+	if fmt.Sprint(ids) != "[m1 m2 ]" {
+		t.Errorf("Expected [m1 m2 ], got %v", ids)
+	}
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Skipped != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
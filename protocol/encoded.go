@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// EncodedFrame 引用计数的已编码帧，用于将同一帧写入多个连接（如群聊广播）
+//
+// Frame.Encode每次调用都会分配一个新的结果切片；EncodedFrame的编码结果直接保存在
+// tieredBufferPool的缓冲区中，所有持有者共享同一份数据，最后一个持有者Release后缓冲区归还池中，
+// 广播时每帧只需一次编码，且稳定状态下几乎不产生分配。
+//
+// 引用计数规则：
+//   - Frame.EncodeShared返回的EncodedFrame引用计数为1，由调用方持有
+//   - 每个额外的持有者（如写协程、发送队列）在持有前调用Retain，用完后调用Release
+//   - 引用计数归零后不能再访问Bytes返回的数据或调用任何方法
+//
+// 使用示例：
+//
+//	ef, err := frame.EncodeShared()
+//	if err != nil {
+//		return err
+//	}
+//	defer ef.Release()
+//	for _, conn := range conns {
+//		ef.Retain()
+//		go func(conn *Conn) {
+//			defer ef.Release()
+//			conn.WriteEncoded(ef.Bytes())
+//		}(conn)
+//	}
+//
+// 并发安全说明：
+// Retain、Release、Bytes、WriteTo可以并发调用，数据在引用计数归零前只读
+type EncodedFrame struct {
+	// bufPtr 从tieredBufferPool取得的缓冲区，超大帧的缓冲区不会被回收
+	bufPtr *[]byte
+	// data 编码结果，是*bufPtr的前缀
+	data []byte
+	// refs 引用计数
+	refs atomic.Int32
+}
+
+// encodedFramePool 缓存EncodedFrame结构体本身，避免每次编码分配
+var encodedFramePool = sync.Pool{
+	New: func() interface{} {
+		return &EncodedFrame{}
+	},
+}
+
+// EncodeShared 将帧编码为引用计数为1的EncodedFrame
+// 编码结果与Encode相同，options同Encode
+func (f *Frame) EncodeShared(options ...EncodeOption) (*EncodedFrame, error) {
+	cfg := newEncodeConfig(options)
+	if err := f.checkEncodable(cfg); err != nil {
+		observeEncode(f.Type, 0, err)
+		return nil, err
+	}
+
+	totalLength := FrameHeaderLength + f.payloadLength(cfg)
+	bufPtr := bufferPool.Get(totalLength)
+	if cap(*bufPtr) < totalLength {
+		buf := make([]byte, totalLength)
+		bufPtr = &buf
+	}
+	data := (*bufPtr)[:totalLength]
+	f.writeWire(data, cfg)
+
+	ef := encodedFramePool.Get().(*EncodedFrame)
+	ef.bufPtr = bufPtr
+	ef.data = data
+	ef.refs.Store(1)
+	observeEncode(f.Type, totalLength, nil)
+	return ef, nil
+}
+
+// Bytes 返回完整的帧编码，只读，引用计数归零后失效
+func (ef *EncodedFrame) Bytes() []byte {
+	return ef.data
+}
+
+// Len 返回帧编码的字节数
+func (ef *EncodedFrame) Len() int {
+	return len(ef.data)
+}
+
+// Retain 增加一个引用，返回ef本身以便链式调用
+func (ef *EncodedFrame) Retain() *EncodedFrame {
+	if ef.refs.Add(1) <= 1 {
+		panic("protocol: Retain on released EncodedFrame")
+	}
+	return ef
+}
+
+// Release 释放一个引用，最后一个引用释放时缓冲区归还池中
+// 释放次数超过引用次数时panic，以便尽早发现重复释放
+func (ef *EncodedFrame) Release() {
+	refs := ef.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("protocol: EncodedFrame released too many times")
+	}
+
+	bufferPool.Put(ef.bufPtr)
+	ef.bufPtr = nil
+	ef.data = nil
+	encodedFramePool.Put(ef)
+}
+
+// WriteTo 将帧编码写入w，实现io.WriterTo
+func (ef *EncodedFrame) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(ef.data)
+	return int64(n), err
+}
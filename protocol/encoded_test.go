@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// broadcastRecipients is the fan-out size used by the broadcast benchmarks
+const broadcastRecipients = 64
+
+// TestEncodeShared tests that shared encoding matches Encode and is released after the last reference
+func TestEncodeShared(t *testing.T) {
+	signer, err := NewFrameSigner(1, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	frame, err := NewFrame(FrameTypeJSON, []byte(`{"op":1,"content":"hello"}`))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+
+	for _, options := range [][]EncodeOption{nil, {WithSigner(signer)}} {
+		expected, err := frame.Encode(options...)
+		if err != nil {
+			t.Fatalf("Failed to encode frame: %v", err)
+		}
+		ef, err := frame.EncodeShared(options...)
+		if err != nil {
+			t.Fatalf("Failed to encode shared frame: %v", err)
+		}
+		if !bytes.Equal(ef.Bytes(), expected) || ef.Len() != len(expected) {
+			t.Errorf("Shared encoding mismatch: %x != %x", ef.Bytes(), expected)
+		}
+
+		var buf bytes.Buffer
+		ef.Retain()
+		if n, err := ef.WriteTo(&buf); err != nil || n != int64(len(expected)) {
+			t.Errorf("WriteTo returned %d, %v", n, err)
+		}
+		ef.Release()
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("WriteTo mismatch: %x != %x", buf.Bytes(), expected)
+		}
+		ef.Release()
+	}
+
+	ef, _ := frame.EncodeShared()
+	ef.Release()
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on double release")
+		}
+	}()
+	ef.Release()
+}
+
+// TestEncodeSharedAllocs tests that broadcasting an EncodedFrame allocates less than encoding per recipient
+func TestEncodeSharedAllocs(t *testing.T) {
+	frame, _ := NewFrame(FrameTypeJSON, bytes.Repeat([]byte("x"), 512))
+
+	perRecipient := testing.AllocsPerRun(100, func() {
+		for i := 0; i < broadcastRecipients; i++ {
+			data, _ := frame.Encode()
+			io.Discard.Write(data)
+		}
+	})
+	shared := testing.AllocsPerRun(100, func() {
+		ef, _ := frame.EncodeShared()
+		for i := 0; i < broadcastRecipients; i++ {
+			ef.Retain()
+			ef.WriteTo(io.Discard)
+			ef.Release()
+		}
+		ef.Release()
+	})
+	if shared >= perRecipient || shared > 2 {
+		t.Errorf("Expected shared encoding to allocate less: %v per broadcast vs %v", shared, perRecipient)
+	}
+}
+
+// BenchmarkBroadcastEncodePerRecipient benchmarks fan-out with one Encode per recipient
+func BenchmarkBroadcastEncodePerRecipient(b *testing.B) {
+	frame, _ := NewFrame(FrameTypeJSON, bytes.Repeat([]byte("x"), 512))
+	b.ReportAllocs()
+	for b.Loop() {
+		for i := 0; i < broadcastRecipients; i++ {
+			data, err := frame.Encode()
+			if err != nil {
+				b.Fatal(err)
+			}
+			io.Discard.Write(data)
+		}
+	}
+}
+
+// BenchmarkBroadcastEncodedFrame benchmarks fan-out sharing one EncodedFrame
+func BenchmarkBroadcastEncodedFrame(b *testing.B) {
+	frame, _ := NewFrame(FrameTypeJSON, bytes.Repeat([]byte("x"), 512))
+	b.ReportAllocs()
+	for b.Loop() {
+		ef, err := frame.EncodeShared()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < broadcastRecipients; i++ {
+			ef.Retain()
+			ef.WriteTo(io.Discard)
+			ef.Release()
+		}
+		ef.Release()
+	}
+}
@@ -0,0 +1,369 @@
+package protocol
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Opcode 消息操作码，标识信封中承载的消息语义
+type Opcode uint16
+
+// 内置操作码定义，自定义操作码应从OpcodeUserBase开始
+const (
+	// OpChatMessage 聊天消息
+	OpChatMessage Opcode = 1
+	// OpAck 消息确认
+	OpAck Opcode = 2
+	// OpReceipt 送达/已读回执
+	OpReceipt Opcode = 3
+	// OpTyping 正在输入提示
+	OpTyping Opcode = 4
+	// OpPresence 在线状态
+	OpPresence Opcode = 5
+	// OpSystemNotice 系统通知
+	OpSystemNotice Opcode = 6
+	// OpSubscribe 订阅主题
+	OpSubscribe Opcode = 7
+	// OpUnsubscribe 取消订阅主题
+	OpUnsubscribe Opcode = 8
+	// OpWatch 关注联系人的在线状态
+	OpWatch Opcode = 9
+	// OpUnwatch 取消关注联系人的在线状态
+	OpUnwatch Opcode = 10
+
+	// OpcodeUserBase 自定义操作码起始值
+	OpcodeUserBase Opcode = 1000
+)
+
+// String 返回操作码的字符串表示
+func (op Opcode) String() string {
+	switch op {
+	case OpChatMessage:
+		return "chat"
+	case OpAck:
+		return "ack"
+	case OpReceipt:
+		return "receipt"
+	case OpTyping:
+		return "typing"
+	case OpPresence:
+		return "presence"
+	case OpSystemNotice:
+		return "notice"
+	case OpSubscribe:
+		return "subscribe"
+	case OpUnsubscribe:
+		return "unsubscribe"
+	case OpWatch:
+		return "watch"
+	case OpUnwatch:
+		return "unwatch"
+	default:
+		return fmt.Sprintf("Opcode(%d)", uint16(op))
+	}
+}
+
+// EnvelopeHeader 所有信封消息的公共字段，嵌入到具体消息结构体中
+type EnvelopeHeader struct {
+	// Op 操作码，由EncodeMessage根据消息类型自动填写
+	Op Opcode `json:"op" msgpack:"op"`
+	// ID 消息ID，用于确认、回执和去重
+	ID string `json:"id,omitempty" msgpack:"id,omitempty"`
+	// Timestamp 发送时间（Unix毫秒）
+	Timestamp int64 `json:"ts,omitempty" msgpack:"ts,omitempty"`
+}
+
+// header 返回公共字段，供EncodeMessage填写操作码
+func (h *EnvelopeHeader) header() *EnvelopeHeader {
+	return h
+}
+
+// Message 信封消息接口，所有内置消息类型均实现该接口
+// 自定义消息嵌入EnvelopeHeader并实现Opcode方法即可
+type Message interface {
+	// Opcode 返回消息的操作码
+	Opcode() Opcode
+	// header 返回公共字段
+	header() *EnvelopeHeader
+}
+
+// ChatMessage 聊天消息
+type ChatMessage struct {
+	EnvelopeHeader
+	// From 发送方ID
+	From string `json:"from" msgpack:"from"`
+	// To 接收方ID（单聊为用户ID，群聊为群ID）
+	To string `json:"to" msgpack:"to"`
+	// ConversationID 会话ID
+	ConversationID string `json:"conv,omitempty" msgpack:"conv,omitempty"`
+	// ContentType 内容类型，如"text"、"image"
+	ContentType string `json:"content_type,omitempty" msgpack:"content_type,omitempty"`
+	// Content 消息内容
+	Content string `json:"content" msgpack:"content"`
+}
+
+// Opcode 实现Message接口
+func (*ChatMessage) Opcode() Opcode { return OpChatMessage }
+
+// Ack 消息确认，表示接收方已收到并持久化了指定消息
+type Ack struct {
+	EnvelopeHeader
+	// MessageID 被确认的消息ID
+	MessageID string `json:"msg_id" msgpack:"msg_id"`
+}
+
+// Opcode 实现Message接口
+func (*Ack) Opcode() Opcode { return OpAck }
+
+// ReceiptStatus 回执状态
+type ReceiptStatus string
+
+// 回执状态定义
+const (
+	// ReceiptDelivered 已送达
+	ReceiptDelivered ReceiptStatus = "delivered"
+	// ReceiptRead 已读
+	ReceiptRead ReceiptStatus = "read"
+)
+
+// Receipt 送达/已读回执，由接收方发给原发送方
+type Receipt struct {
+	EnvelopeHeader
+	// MessageID 回执对应的消息ID
+	MessageID string `json:"msg_id" msgpack:"msg_id"`
+	// From 回执发送方（原消息的接收方）
+	From string `json:"from" msgpack:"from"`
+	// Status 回执状态
+	Status ReceiptStatus `json:"status" msgpack:"status"`
+}
+
+// Opcode 实现Message接口
+func (*Receipt) Opcode() Opcode { return OpReceipt }
+
+// TypingIndicator 正在输入提示
+type TypingIndicator struct {
+	EnvelopeHeader
+	// From 正在输入的用户ID
+	From string `json:"from" msgpack:"from"`
+	// To 对方用户ID或群ID
+	To string `json:"to" msgpack:"to"`
+	// Typing true表示开始输入，false表示停止输入
+	Typing bool `json:"typing" msgpack:"typing"`
+}
+
+// Opcode 实现Message接口
+func (*TypingIndicator) Opcode() Opcode { return OpTyping }
+
+// PresenceStatus 在线状态
+type PresenceStatus string
+
+// 在线状态定义
+const (
+	// PresenceOnline 在线
+	PresenceOnline PresenceStatus = "online"
+	// PresenceAway 离开
+	PresenceAway PresenceStatus = "away"
+	// PresenceOffline 离线
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// Presence 在线状态
+type Presence struct {
+	EnvelopeHeader
+	// User 用户ID
+	User string `json:"user" msgpack:"user"`
+	// Device 设备ID，为空表示用户的聚合状态
+	Device string `json:"device,omitempty" msgpack:"device,omitempty"`
+	// Status 在线状态
+	Status PresenceStatus `json:"status" msgpack:"status"`
+	// Text 自定义状态文本
+	Text string `json:"text,omitempty" msgpack:"text,omitempty"`
+	// LastSeen 最后活跃时间（Unix毫秒）
+	LastSeen int64 `json:"last_seen,omitempty" msgpack:"last_seen,omitempty"`
+}
+
+// Opcode 实现Message接口
+func (*Presence) Opcode() Opcode { return OpPresence }
+
+// SystemNotice 系统通知
+type SystemNotice struct {
+	EnvelopeHeader
+	// Code 通知代码，如"maintenance"、"kicked"
+	Code string `json:"code" msgpack:"code"`
+	// Text 通知文本
+	Text string `json:"text" msgpack:"text"`
+}
+
+// Opcode 实现Message接口
+func (*SystemNotice) Opcode() Opcode { return OpSystemNotice }
+
+// Subscribe 订阅主题的控制消息，见Broker
+type Subscribe struct {
+	EnvelopeHeader
+	// Topics 要订阅的主题，如群聊ID
+	Topics []string `json:"topics" msgpack:"topics"`
+}
+
+// Opcode 实现Message接口
+func (*Subscribe) Opcode() Opcode { return OpSubscribe }
+
+// Unsubscribe 取消订阅主题的控制消息，见Broker
+type Unsubscribe struct {
+	EnvelopeHeader
+	// Topics 要取消订阅的主题
+	Topics []string `json:"topics" msgpack:"topics"`
+}
+
+// Opcode 实现Message接口
+func (*Unsubscribe) Opcode() Opcode { return OpUnsubscribe }
+
+// Watch 关注联系人在线状态的控制消息，见PresenceRegistry
+type Watch struct {
+	EnvelopeHeader
+	// Users 要关注的用户ID
+	Users []string `json:"users" msgpack:"users"`
+}
+
+// Opcode 实现Message接口
+func (*Watch) Opcode() Opcode { return OpWatch }
+
+// Unwatch 取消关注联系人在线状态的控制消息，见PresenceRegistry
+type Unwatch struct {
+	EnvelopeHeader
+	// Users 要取消关注的用户ID
+	Users []string `json:"users" msgpack:"users"`
+}
+
+// Opcode 实现Message接口
+func (*Unwatch) Opcode() Opcode { return OpUnwatch }
+
+// messageFactories 操作码到消息构造函数的注册表
+var messageFactories = struct {
+	mu        sync.RWMutex
+	factories map[Opcode]func() Message
+}{
+	factories: map[Opcode]func() Message{
+		OpChatMessage:  func() Message { return &ChatMessage{} },
+		OpAck:          func() Message { return &Ack{} },
+		OpReceipt:      func() Message { return &Receipt{} },
+		OpTyping:       func() Message { return &TypingIndicator{} },
+		OpPresence:     func() Message { return &Presence{} },
+		OpSystemNotice: func() Message { return &SystemNotice{} },
+		OpSubscribe:    func() Message { return &Subscribe{} },
+		OpUnsubscribe:  func() Message { return &Unsubscribe{} },
+		OpWatch:        func() Message { return &Watch{} },
+		OpUnwatch:      func() Message { return &Unwatch{} },
+	},
+}
+
+// RegisterMessage 注册自定义消息类型，使ParseMessage能够解析该操作码，factory为nil时取消注册
+func RegisterMessage(op Opcode, factory func() Message) {
+	messageFactories.mu.Lock()
+	defer messageFactories.mu.Unlock()
+	if factory == nil {
+		delete(messageFactories.factories, op)
+		return
+	}
+	messageFactories.factories[op] = factory
+}
+
+// EncodeMessage 将信封消息编码为指定帧类型的帧
+//
+// 参数：
+//
+//	frameType - 帧类型，决定消息体的序列化格式，必须已注册BodyCodec
+//	msg - 信封消息，操作码会根据消息类型自动填写
+//	options - 构造期选项，同NewFrame
+//
+// 使用示例：
+//
+//	frame, err := EncodeMessage(FrameTypeJSON, &ChatMessage{
+//		EnvelopeHeader: EnvelopeHeader{ID: "m1"},
+//		From:           "alice",
+//		To:             "bob",
+//		Content:        "hello",
+//	})
+func EncodeMessage(frameType uint8, msg Message, options ...ConstructorOption) (*Frame, error) {
+	codec, ok := BodyCodecFor(frameType)
+	if !ok {
+		return nil, NewInvalidMessageError(fmt.Sprintf("no body codec registered for frame type %d", frameType))
+	}
+
+	msg.header().Op = msg.Opcode()
+	body, err := codec.Marshal(msg)
+	if err != nil {
+		return nil, &ProtocolError{
+			Code:     ErrCodeInvalidMessage,
+			Message:  fmt.Sprintf("invalid message: marshal %s: %v", msg.Opcode(), err),
+			Original: err,
+		}
+	}
+
+	// body为新分配的切片，无需再次拷贝
+	return NewFrame(frameType, body, append([]ConstructorOption{WithZeroCopy(true)}, options...)...)
+}
+
+// ParseMessage 从帧中解析信封消息
+// 返回值为具体消息类型的指针，可通过类型断言或type switch使用：
+//
+//	switch m := msg.(type) {
+//	case *ChatMessage:
+//	case *Ack:
+//	}
+func ParseMessage(f *Frame) (Message, error) {
+	codec, ok := BodyCodecFor(f.Type)
+	if !ok {
+		return nil, NewInvalidMessageError(fmt.Sprintf("no body codec registered for frame type %d", f.Type))
+	}
+
+	// 先读取操作码，再按操作码解析完整消息
+	header, err := decodeEnvelopeHeader(codec, f.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	messageFactories.mu.RLock()
+	factory, ok := messageFactories.factories[header.Op]
+	messageFactories.mu.RUnlock()
+	if !ok {
+		return nil, NewInvalidMessageError(fmt.Sprintf("unknown opcode %d", header.Op))
+	}
+
+	msg := factory()
+	if err := codec.Unmarshal(f.Body, msg); err != nil {
+		return nil, &ProtocolError{
+			Code:     ErrCodeInvalidMessage,
+			Message:  fmt.Sprintf("invalid message: unmarshal %s: %v", header.Op, err),
+			Original: err,
+		}
+	}
+	return msg, nil
+}
+
+// decodeEnvelopeHeader 只解析信封的公共字段
+func decodeEnvelopeHeader(codec BodyCodec, body []byte) (EnvelopeHeader, error) {
+	// 与具体消息一样以嵌入方式携带公共字段，兼容展开和不展开嵌入字段的编解码器
+	var header struct{ EnvelopeHeader }
+	if err := codec.Unmarshal(body, &header); err != nil {
+		return EnvelopeHeader{}, &ProtocolError{
+			Code:     ErrCodeInvalidMessage,
+			Message:  fmt.Sprintf("invalid message: %v", err),
+			Original: err,
+		}
+	}
+	return header.EnvelopeHeader, nil
+}
+
+// EnvelopeMessageID 返回帧中信封消息的ID
+// 帧类型未注册BodyCodec、消息体不是信封或消息没有ID时返回false
+func EnvelopeMessageID(f *Frame) (string, bool) {
+	codec, ok := BodyCodecFor(f.Type)
+	if !ok {
+		return "", false
+	}
+	header, err := decodeEnvelopeHeader(codec, f.Body)
+	if err != nil || header.ID == "" {
+		return "", false
+	}
+	return header.ID, true
+}
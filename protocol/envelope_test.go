@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+// gobCodec is a BodyCodec used to exercise non-JSON frame types in tests
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// TestEnvelopeRoundTrip tests building and parsing every built-in message type
+func TestEnvelopeRoundTrip(t *testing.T) {
+	messages := []Message{
+		&ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: "m1", Timestamp: 1700000000000}, From: "alice", To: "bob", ContentType: "text", Content: "hello"},
+		&Ack{MessageID: "m1"},
+		&Receipt{MessageID: "m1", From: "bob", Status: ReceiptRead},
+		&TypingIndicator{From: "alice", To: "bob", Typing: true},
+		&Presence{User: "alice", Device: "phone", Status: PresenceAway, LastSeen: 42},
+		&SystemNotice{Code: "maintenance", Text: "restarting"},
+		&Subscribe{Topics: []string{"group-1"}},
+		&Unsubscribe{Topics: []string{"group-1"}},
+		&Watch{Users: []string{"bob"}},
+		&Unwatch{Users: []string{"bob"}},
+	}
+
+	for _, msg := range messages {
+		frame, err := EncodeMessage(FrameTypeJSON, msg)
+		if err != nil {
+			t.Fatalf("Failed to encode %s: %v", msg.Opcode(), err)
+		}
+		if msg.header().Op != msg.Opcode() {
+			t.Errorf("Expected opcode %s to be filled in, got %s", msg.Opcode(), msg.header().Op)
+		}
+
+		parsed, err := ParseMessage(frame)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", msg.Opcode(), err)
+		}
+		if parsed.Opcode() != msg.Opcode() {
+			t.Errorf("Expected opcode %s, got %s", msg.Opcode(), parsed.Opcode())
+		}
+	}
+
+	frame, err := EncodeMessage(FrameTypeJSON, messages[0])
+	if err != nil {
+		t.Fatalf("Failed to encode chat message: %v", err)
+	}
+	parsed, err := ParseMessage(frame)
+	if err != nil {
+		t.Fatalf("Failed to parse chat message: %v", err)
+	}
+	chat, ok := parsed.(*ChatMessage)
+	if !ok {
+		t.Fatalf("Expected *ChatMessage, got %T", parsed)
+	}
+	if chat.ID != "m1" || chat.Timestamp != 1700000000000 || chat.From != "alice" || chat.Content != "hello" {
+		t.Errorf("Chat message mismatch: %+v", chat)
+	}
+}
+
+// TestEnvelopeRegisteredCodec tests envelopes carried in a frame type with a user-registered codec
+func TestEnvelopeRegisteredCodec(t *testing.T) {
+	if _, err := EncodeMessage(FrameTypeMsgPack, &Ack{MessageID: "m1"}); !IsInvalidMessageError(err) {
+		t.Fatalf("Expected invalid message error without codec, got %v", err)
+	}
+
+	RegisterBodyCodec(FrameTypeMsgPack, gobCodec{})
+	defer RegisterBodyCodec(FrameTypeMsgPack, nil)
+
+	frame, err := EncodeMessage(FrameTypeMsgPack, &Receipt{MessageID: "m1", From: "bob", Status: ReceiptDelivered})
+	if err != nil {
+		t.Fatalf("Failed to encode receipt: %v", err)
+	}
+	if frame.Type != FrameTypeMsgPack {
+		t.Errorf("Expected frame type %d, got %d", FrameTypeMsgPack, frame.Type)
+	}
+
+	parsed, err := ParseMessage(frame)
+	if err != nil {
+		t.Fatalf("Failed to parse receipt: %v", err)
+	}
+	receipt, ok := parsed.(*Receipt)
+	if !ok || receipt.MessageID != "m1" || receipt.Status != ReceiptDelivered {
+		t.Errorf("Receipt mismatch: %#v", parsed)
+	}
+}
+
+// customMessage is a user-defined envelope message
+type customMessage struct {
+	EnvelopeHeader
+	Value int `json:"value"`
+}
+
+func (*customMessage) Opcode() Opcode { return OpcodeUserBase + 1 }
+
+// TestEnvelopeCustomMessage tests registering a custom opcode and rejecting unknown ones
+func TestEnvelopeCustomMessage(t *testing.T) {
+	frame, err := EncodeMessage(FrameTypeJSON, &customMessage{Value: 7})
+	if err != nil {
+		t.Fatalf("Failed to encode custom message: %v", err)
+	}
+
+	if _, err := ParseMessage(frame); !IsInvalidMessageError(err) {
+		t.Fatalf("Expected invalid message error for unknown opcode, got %v", err)
+	}
+
+	RegisterMessage(OpcodeUserBase+1, func() Message { return &customMessage{} })
+	defer RegisterMessage(OpcodeUserBase+1, nil)
+	parsed, err := ParseMessage(frame)
+	if err != nil {
+		t.Fatalf("Failed to parse custom message: %v", err)
+	}
+	if custom, ok := parsed.(*customMessage); !ok || custom.Value != 7 {
+		t.Errorf("Custom message mismatch: %#v", parsed)
+	}
+
+	bad, err := NewFrame(FrameTypeJSON, []byte("not json"))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+	if _, err := ParseMessage(bad); !IsInvalidMessageError(err) {
+		t.Errorf("Expected invalid message error for malformed body, got %v", err)
+	}
+}
@@ -0,0 +1,487 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 离线消息文件的记录格式
+//
+//	保存记录：[4字节CRC32][1字节类型=1][8字节序号][8字节保存时间(Unix纳秒)][2字节ID长度][4字节数据长度][ID][帧编码]
+//	删除记录：[4字节CRC32][1字节类型=2][8字节序号]
+//
+// CRC32（IEEE）覆盖类型字节之后的全部内容，所有整数使用大端序
+const (
+	// storeRecordPut 保存记录
+	storeRecordPut byte = 1
+	// storeRecordDelete 删除记录
+	storeRecordDelete byte = 2
+
+	// storePutHeaderLength 保存记录中ID之前的长度
+	storePutHeaderLength = 4 + 1 + 8 + 8 + 2 + 4
+	// storeDeleteLength 删除记录的长度
+	storeDeleteLength = 4 + 1 + 8
+
+	// storeFileExt 离线消息文件的扩展名
+	storeFileExt = ".mbox"
+	// storeCompactMinDead 触发压缩的最少失效记录数
+	storeCompactMinDead = 64
+)
+
+// FileStoreConfig 文件离线消息存储配置
+type FileStoreConfig struct {
+	// Quota 每个接收者的配额
+	Quota StoreQuota
+	// Sync 每次写入后是否调用fsync，开启后更可靠但更慢
+	Sync bool
+}
+
+// fileMailbox 一个接收者的离线消息文件
+type fileMailbox struct {
+	mailbox
+	// size 文件长度
+	size int64
+	// dead 文件中已失效（被删除）的保存记录数
+	dead int
+}
+
+// FileStore 文件离线消息存储，每个接收者一个追加写文件
+//
+// 保存和删除都以追加记录的方式写入，失效记录超过有效记录时重写文件；
+// 接收者的文件在首次访问时加载，末尾不完整或校验失败的记录（如写入时崩溃）会被截断。
+// 内存中只保留消息的索引，帧编码在Pending时从文件读取。
+//
+// 并发安全说明：
+// 所有方法都可以并发调用；同一目录不能被多个FileStore同时使用
+type FileStore struct {
+	dir    string
+	config FileStoreConfig
+
+	mu sync.Mutex
+	// mailboxes 已加载的接收者到信箱的映射
+	mailboxes map[string]*fileMailbox
+	// closed 是否已关闭
+	closed bool
+
+	// now 返回当前时间，测试时可替换
+	now func() time.Time
+}
+
+// NewFileStore 创建文件离线消息存储，目录不存在时创建
+func NewFileStore(dir string, config FileStoreConfig) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	config.Quota = config.Quota.withDefaults()
+	return &FileStore{
+		dir:       dir,
+		config:    config,
+		mailboxes: make(map[string]*fileMailbox),
+		now:       time.Now,
+	}, nil
+}
+
+// Put 实现MessageStore接口
+func (s *FileStore) Put(recipient, id string, data []byte) error {
+	if err := checkStoreSize(data, s.config.Quota); err != nil {
+		return err
+	}
+	if len(id) > 0xFFFF {
+		return NewInvalidMessageError("message ID too long")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	m, err := s.mailbox(recipient)
+	if err != nil {
+		return err
+	}
+
+	e := mailboxEntry{
+		StoredMessage: StoredMessage{Seq: m.nextSeq, ID: id, Stored: s.now()},
+		size:          int64(len(data)),
+		offset:        m.size + storePutHeaderLength + int64(len(id)),
+	}
+	record := appendPutRecord(nil, e, data)
+	dropped := m.add(e, s.config.Quota)
+	for _, d := range dropped {
+		record = appendDeleteRecord(record, d.Seq)
+	}
+	if err := s.append(recipient, m, record); err != nil {
+		// 写入失败时丢弃内存状态，下次访问时从文件重新加载
+		delete(s.mailboxes, recipient)
+		return err
+	}
+	m.dead += len(dropped)
+	return s.maybeCompact(recipient, m)
+}
+
+// Pending 实现MessageStore接口
+func (s *FileStore) Pending(recipient string) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	m, err := s.mailbox(recipient)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.entries) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(s.path(recipient))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	messages := make([]StoredMessage, len(m.entries))
+	for i, e := range m.entries {
+		messages[i] = e.StoredMessage
+		messages[i].Data = make([]byte, e.size)
+		if _, err := f.ReadAt(messages[i].Data, e.offset); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// Ack 实现MessageStore接口
+func (s *FileStore) Ack(recipient string, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	m, err := s.load(recipient)
+	if err != nil {
+		return err
+	}
+	return s.delete(recipient, m, m.remove(ids))
+}
+
+// Prune 删除目录中所有接收者的过期消息，返回删除的消息数
+func (s *FileStore) Prune() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ErrStoreClosed
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.dir, "*"+storeFileExt))
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, name := range names {
+		raw, err := hex.DecodeString(strings.TrimSuffix(filepath.Base(name), storeFileExt))
+		if err != nil {
+			continue
+		}
+		recipient := string(raw)
+		m, err := s.load(recipient)
+		if err != nil {
+			return n, err
+		}
+		before := len(m.entries)
+		if err := s.expire(recipient, m); err != nil {
+			return n, err
+		}
+		n += before - len(m.entries)
+	}
+	return n, nil
+}
+
+// Close 实现MessageStore接口
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.mailboxes = nil
+	return nil
+}
+
+// path 返回接收者的文件路径，文件名为接收者ID的十六进制编码
+func (s *FileStore) path(recipient string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(recipient))+storeFileExt)
+}
+
+// load 返回接收者的信箱，未加载时从文件加载，调用方需持有锁
+func (s *FileStore) load(recipient string) (*fileMailbox, error) {
+	if m, ok := s.mailboxes[recipient]; ok {
+		return m, nil
+	}
+
+	m := &fileMailbox{}
+	f, err := os.Open(s.path(recipient))
+	if errors.Is(err, os.ErrNotExist) {
+		s.mailboxes[recipient] = m
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[uint64]mailboxEntry)
+	var puts int
+	r := bufio.NewReader(f)
+	for {
+		e, deleted, n, err := readStoreRecord(r, m.size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// 截断末尾不完整或损坏的记录
+			if err := os.Truncate(s.path(recipient), m.size); err != nil {
+				return nil, err
+			}
+			break
+		}
+		m.size += n
+		if deleted {
+			delete(entries, e.Seq)
+			continue
+		}
+		puts++
+		entries[e.Seq] = e
+		if e.Seq >= m.nextSeq {
+			m.nextSeq = e.Seq + 1
+		}
+	}
+
+	m.entries = make([]mailboxEntry, 0, len(entries))
+	for _, e := range entries {
+		m.entries = append(m.entries, e)
+		m.bytes += e.size
+	}
+	sort.Slice(m.entries, func(i, j int) bool { return m.entries[i].Seq < m.entries[j].Seq })
+	m.dead = puts - len(m.entries)
+	s.mailboxes[recipient] = m
+	return m, nil
+}
+
+// mailbox 加载接收者的信箱并删除过期消息，调用方需持有锁
+// 过期删除可能清空并移除信箱，因此删除后重新获取
+func (s *FileStore) mailbox(recipient string) (*fileMailbox, error) {
+	m, err := s.load(recipient)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.expire(recipient, m); err != nil {
+		return nil, err
+	}
+	return s.load(recipient)
+}
+
+// expire 删除信箱中的过期消息，调用方需持有锁
+func (s *FileStore) expire(recipient string, m *fileMailbox) error {
+	if s.config.Quota.MaxAge <= 0 {
+		return nil
+	}
+	return s.delete(recipient, m, m.expire(s.now().Add(-s.config.Quota.MaxAge)))
+}
+
+// delete 为已从信箱移除的消息追加删除记录，信箱为空时删除文件，调用方需持有锁
+func (s *FileStore) delete(recipient string, m *fileMailbox, removed []mailboxEntry) error {
+	if len(m.entries) == 0 && (len(removed) > 0 || m.size > 0) {
+		delete(s.mailboxes, recipient)
+		if err := os.Remove(s.path(recipient)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	var record []byte
+	for _, e := range removed {
+		record = appendDeleteRecord(record, e.Seq)
+	}
+	if err := s.append(recipient, m, record); err != nil {
+		delete(s.mailboxes, recipient)
+		return err
+	}
+	m.dead += len(removed)
+	return s.maybeCompact(recipient, m)
+}
+
+// append 向接收者的文件追加记录，调用方需持有锁
+func (s *FileStore) append(recipient string, m *fileMailbox, record []byte) error {
+	f, err := os.OpenFile(s.path(recipient), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(record); err != nil {
+		f.Close()
+		return err
+	}
+	if s.config.Sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	m.size += int64(len(record))
+	return nil
+}
+
+// maybeCompact 失效记录超过有效记录时重写文件，只保留有效消息，调用方需持有锁
+func (s *FileStore) maybeCompact(recipient string, m *fileMailbox) error {
+	if m.dead < storeCompactMinDead || m.dead <= len(m.entries) {
+		return nil
+	}
+
+	path := s.path(recipient)
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(s.dir, ".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	entries := make([]mailboxEntry, len(m.entries))
+	var size int64
+	for i, e := range m.entries {
+		data := make([]byte, e.size)
+		if _, err := src.ReadAt(data, e.offset); err != nil {
+			tmp.Close()
+			return err
+		}
+		e.offset = size + storePutHeaderLength + int64(len(e.ID))
+		record := appendPutRecord(nil, e, data)
+		if _, err := w.Write(record); err != nil {
+			tmp.Close()
+			return err
+		}
+		size += int64(len(record))
+		entries[i] = e
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	m.entries = entries
+	m.size = size
+	m.dead = 0
+	return nil
+}
+
+// appendPutRecord 将保存记录追加到buf
+func appendPutRecord(buf []byte, e mailboxEntry, data []byte) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, storeRecordPut)
+	buf = binary.BigEndian.AppendUint64(buf, e.Seq)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(e.Stored.UnixNano()))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(e.ID)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, e.ID...)
+	buf = append(buf, data...)
+	binary.BigEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+// appendDeleteRecord 将删除记录追加到buf
+func appendDeleteRecord(buf []byte, seq uint64) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, storeRecordDelete)
+	buf = binary.BigEndian.AppendUint64(buf, seq)
+	binary.BigEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+// readStoreRecord 读取一条记录，offset为记录在文件中的偏移
+// 返回记录对应的消息（删除记录只有Seq）、是否为删除记录以及记录长度
+// 文件恰好结束时返回io.EOF，记录不完整或损坏时返回其他错误
+func readStoreRecord(r *bufio.Reader, offset int64) (mailboxEntry, bool, int64, error) {
+	var e mailboxEntry
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return e, false, 0, err
+	}
+
+	var rest []byte
+	switch header[4] {
+	case storeRecordDelete:
+		rest = make([]byte, storeDeleteLength-5)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return e, false, 0, io.ErrUnexpectedEOF
+		}
+	case storeRecordPut:
+		fixed := make([]byte, storePutHeaderLength-5)
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return e, false, 0, io.ErrUnexpectedEOF
+		}
+		idLen := int(binary.BigEndian.Uint16(fixed[16:18]))
+		dataLen := int(binary.BigEndian.Uint32(fixed[18:22]))
+		if dataLen > MaxStoredMessageLength {
+			return e, false, 0, fmt.Errorf("store record too large: %d bytes", dataLen)
+		}
+		rest = make([]byte, len(fixed)+idLen+dataLen)
+		copy(rest, fixed)
+		if _, err := io.ReadFull(r, rest[len(fixed):]); err != nil {
+			return e, false, 0, io.ErrUnexpectedEOF
+		}
+	default:
+		return e, false, 0, fmt.Errorf("unknown store record type %d", header[4])
+	}
+
+	sum := crc32.NewIEEE()
+	sum.Write(header[4:])
+	sum.Write(rest)
+	if sum.Sum32() != binary.BigEndian.Uint32(header[:4]) {
+		return e, false, 0, errors.New("store record checksum mismatch")
+	}
+
+	e.Seq = binary.BigEndian.Uint64(rest[:8])
+	length := int64(len(header) + len(rest))
+	if header[4] == storeRecordDelete {
+		return e, true, length, nil
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	e.Stored = time.Unix(0, int64(binary.BigEndian.Uint64(rest[8:16])))
+	e.ID = string(rest[22 : 22+idLen])
+	e.size = int64(len(rest) - 22 - idLen)
+	e.offset = offset + storePutHeaderLength + int64(idLen)
+	return e, false, length, nil
+}
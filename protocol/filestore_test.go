@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileStoreRecovery tests reloading, truncating a partial trailing record and compaction
+func TestFileStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir, FileStoreConfig{Sync: true})
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.Put("bob", fmt.Sprintf("m%d", i), []byte(fmt.Sprintf("frame-%d", i))); err != nil {
+			t.Fatalf("Failed to store message: %v", err)
+		}
+	}
+	store.Ack("bob", "m1")
+	store.Close()
+
+	// Simulate a crash in the middle of appending a record
+	path := store.path("bob")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open mailbox file: %v", err)
+	}
+	partial := appendPutRecord(nil, mailboxEntry{StoredMessage: StoredMessage{Seq: 9, ID: "torn"}}, []byte("lost"))
+	f.Write(partial[:len(partial)-2])
+	f.Close()
+
+	store, err = NewFileStore(dir, FileStoreConfig{})
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	defer store.Close()
+	messages := expectPending(t, store, "bob", "m0", "m2")
+	if string(messages[1].Data) != "frame-2" {
+		t.Errorf("Unexpected data: %q", messages[1].Data)
+	}
+
+	// The next message gets a fresh sequence number and lands after the truncated record
+	store.Put("bob", "m3", []byte("frame-3"))
+	messages = expectPending(t, store, "bob", "m0", "m2", "m3")
+	if messages[2].Seq != 3 {
+		t.Errorf("Expected sequence 3, got %d", messages[2].Seq)
+	}
+
+	// Acking most messages compacts the file
+	for i := 4; i < 4+2*storeCompactMinDead; i++ {
+		store.Put("bob", fmt.Sprintf("m%d", i), []byte("x"))
+	}
+	before, _ := os.Stat(path)
+	for i := 4; i < 4+2*storeCompactMinDead-1; i++ {
+		store.Ack("bob", fmt.Sprintf("m%d", i))
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Errorf("Expected compaction to shrink the file: %d >= %d", after.Size(), before.Size())
+	}
+	last := fmt.Sprintf("m%d", 4+2*storeCompactMinDead-1)
+	expectPending(t, store, "bob", "m0", "m2", "m3", last)
+
+	store.Ack("bob", "m0", "m2", "m3", last)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected empty mailbox file to be removed, got %v", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, ".compact-*")); len(names) != 0 {
+		t.Errorf("Expected no leftover temporary files, got %v", names)
+	}
+}
+
+// TestFileStoreMaxMessageLength tests that Put rejects messages the store could not reload, and that the largest one survives a reopen
+func TestFileStoreMaxMessageLength(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir, FileStoreConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	if err := store.Put("bob", "big", make([]byte, MaxStoredMessageLength+1)); !IsMessageTooLongError(err) {
+		t.Fatalf("Expected message too long error, got %v", err)
+	}
+	if err := store.Put("bob", "max", make([]byte, MaxStoredMessageLength)); err != nil {
+		t.Fatalf("Failed to store message: %v", err)
+	}
+	store.Put("bob", "next", []byte("frame"))
+	store.Close()
+
+	store, err = NewFileStore(dir, FileStoreConfig{})
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	defer store.Close()
+	expectPending(t, store, "bob", "max", "next")
+}
@@ -0,0 +1,746 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 帧日志默认值
+const (
+	// DefaultLogSegmentBytes 单个段文件的默认大小上限
+	DefaultLogSegmentBytes = 64 * 1024 * 1024
+)
+
+// 帧日志文件格式
+//
+// 日志由若干段组成，每段包含两个文件，文件名为段内第一条记录的偏移（20位十进制，不足补0）：
+//
+//	<base>.log：按追加顺序存放的帧编码（与Frame.Encode的结果相同），没有额外的记录头
+//	<base>.idx：每条记录一个24字节的索引项：[8字节偏移][8字节时间戳(Unix纳秒)][8字节在.log中的位置]
+//
+// 偏移从0开始连续编号，跨段连续；时间戳单调不减。每次追加先写.log再写.idx，
+// 因此索引项指向的帧总是完整的，崩溃后只需检查最后一段的尾部。
+const (
+	// logIndexEntryLength 索引项长度
+	logIndexEntryLength = 24
+	// logSegmentExt 段数据文件扩展名
+	logSegmentExt = ".log"
+	// logIndexExt 段索引文件扩展名
+	logIndexExt = ".idx"
+	// logRecoverChunk 恢复时读取未索引尾部的块大小
+	logRecoverChunk = 64 * 1024
+)
+
+// ErrLogClosed 帧日志已关闭
+var ErrLogClosed = errors.New("protocol: frame log closed")
+
+// FrameLogConfig 帧日志配置，零值字段使用默认值
+type FrameLogConfig struct {
+	// SegmentBytes 段数据文件的大小上限，超过后滚动到新段，默认DefaultLogSegmentBytes
+	SegmentBytes int64
+	// SegmentAge 段的最长时间跨度，超过后滚动到新段，0表示不按时间滚动
+	SegmentAge time.Duration
+	// Sync 每次追加后是否调用fsync
+	Sync bool
+	// DecodeOptions 解码帧时使用的选项，日志中含签名帧时必须包含WithSignatureVerifier，否则恢复未索引的记录时返回错误
+	DecodeOptions []DecodeOption
+}
+
+// LogRecord 帧日志中的一条记录
+type LogRecord struct {
+	// Offset 记录的偏移
+	Offset uint64
+	// Timestamp 追加时间
+	Timestamp time.Time
+	// Frame 解码后的帧
+	Frame *Frame
+}
+
+// logIndexEntry 索引项
+type logIndexEntry struct {
+	// offset 记录偏移
+	offset uint64
+	// timestamp 追加时间（Unix纳秒）
+	timestamp int64
+	// position 帧在段数据文件中的位置
+	position int64
+}
+
+// encode 编码索引项
+func (e logIndexEntry) encode() []byte {
+	buf := make([]byte, logIndexEntryLength)
+	binary.BigEndian.PutUint64(buf[0:8], e.offset)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(e.timestamp))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(e.position))
+	return buf
+}
+
+// decodeLogIndexEntry 解码索引项
+func decodeLogIndexEntry(buf []byte) logIndexEntry {
+	return logIndexEntry{
+		offset:    binary.BigEndian.Uint64(buf[0:8]),
+		timestamp: int64(binary.BigEndian.Uint64(buf[8:16])),
+		position:  int64(binary.BigEndian.Uint64(buf[16:24])),
+	}
+}
+
+// FrameLog 分段的追加写帧日志，用于审计和回放
+//
+// 打开时恢复最后一段：丢弃不完整或指向无效位置的索引项，用StreamDecoder扫描未索引的尾部，
+// 为完整的帧补建索引（时间戳取最后一条已索引记录的时间），截断末尾不完整或无法解码的数据。
+//
+// 使用示例：
+//
+//	log, err := OpenFrameLog("/var/lib/im/audit", FrameLogConfig{SegmentAge: 24 * time.Hour})
+//	if err != nil {
+//		return err
+//	}
+//	defer log.Close()
+//	offset, err := log.Append(frame)
+//
+//	reader, err := OpenFrameLogReader("/var/lib/im/audit")
+//	reader.SeekTime(since)
+//	for {
+//		record, err := reader.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+//
+// 并发安全说明：
+// FrameLog的所有方法都可以并发调用；同一目录只能有一个FrameLog写入，但可以同时有多个FrameLogReader读取
+type FrameLog struct {
+	dir    string
+	config FrameLogConfig
+
+	mu sync.Mutex
+	// bases 所有段的起始偏移，升序
+	bases []uint64
+	// log 当前段的数据文件
+	log *os.File
+	// idx 当前段的索引文件
+	idx *os.File
+	// logSize 当前段数据文件的长度
+	logSize int64
+	// next 下一条记录的偏移
+	next uint64
+	// segmentStart 当前段第一条记录的时间，段为空时为零值
+	segmentStart time.Time
+	// lastTimestamp 最后一条记录的时间戳（Unix纳秒），保证时间戳单调不减
+	lastTimestamp int64
+	// closed 是否已关闭
+	closed bool
+
+	// now 返回当前时间，测试时可替换
+	now func() time.Time
+}
+
+// OpenFrameLog 打开目录中的帧日志，目录不存在时创建，最后一段在打开时恢复
+func OpenFrameLog(dir string, config FrameLogConfig) (*FrameLog, error) {
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = DefaultLogSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	bases, err := listLogSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &FrameLog{dir: dir, config: config, bases: bases, now: time.Now}
+	if len(bases) == 0 {
+		if err := l.openSegment(0); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+	if err := l.recover(bases[len(bases)-1]); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append 编码帧并追加到日志，返回记录的偏移
+func (l *FrameLog) Append(f *Frame, options ...EncodeOption) (uint64, error) {
+	data, err := f.Encode(options...)
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+
+	now := l.now()
+	if l.logSize > 0 && (l.logSize+int64(len(data)) > l.config.SegmentBytes ||
+		(l.config.SegmentAge > 0 && now.Sub(l.segmentStart) >= l.config.SegmentAge)) {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	timestamp := now.UnixNano()
+	if timestamp < l.lastTimestamp {
+		timestamp = l.lastTimestamp
+	}
+	// 按已提交的位置写入而不依赖文件偏移，写入失败后下一次追加仍落在正确的位置
+	entry := logIndexEntry{offset: l.next, timestamp: timestamp, position: l.logSize}
+	indexSize := int64(l.next-l.bases[len(l.bases)-1]) * logIndexEntryLength
+	if _, err := l.log.WriteAt(data, l.logSize); err != nil {
+		l.discardUncommitted(indexSize)
+		return 0, err
+	}
+	if _, err := l.idx.WriteAt(entry.encode(), indexSize); err != nil {
+		l.discardUncommitted(indexSize)
+		return 0, err
+	}
+	if l.config.Sync {
+		if err := l.sync(); err != nil {
+			l.discardUncommitted(indexSize)
+			return 0, err
+		}
+	}
+
+	if l.logSize == 0 {
+		l.segmentStart = now
+	}
+	l.logSize += int64(len(data))
+	l.lastTimestamp = timestamp
+	l.next++
+	return entry.offset, nil
+}
+
+// discardUncommitted 追加失败时截掉当前段中未提交的数据和索引，尽力而为
+// 即使截断失败，下一次追加也会覆盖这些数据，重新打开时recover同样会丢弃它们
+func (l *FrameLog) discardUncommitted(indexSize int64) {
+	l.idx.Truncate(indexSize)
+	l.log.Truncate(l.logSize)
+}
+
+// NextOffset 返回下一条记录的偏移，即已追加的记录总数
+func (l *FrameLog) NextOffset() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next
+}
+
+// Segments 返回所有段的起始偏移，升序
+func (l *FrameLog) Segments() []uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]uint64(nil), l.bases...)
+}
+
+// Roll 立即滚动到新段，当前段为空时不做任何事
+func (l *FrameLog) Roll() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	if l.logSize == 0 {
+		return nil
+	}
+	return l.roll()
+}
+
+// RemoveBefore 删除所有记录的偏移都小于offset的段，当前段不会被删除，返回删除的段数
+// 可用于按保留期限清理日志
+func (l *FrameLog) RemoveBefore(offset uint64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+
+	var n int
+	var err error
+	for n < len(l.bases)-1 && l.bases[n+1] <= offset {
+		// 先删除数据文件，使读取器不再列出该段
+		base := l.bases[n]
+		if err = os.Remove(logSegmentPath(l.dir, base, logSegmentExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err = os.Remove(logSegmentPath(l.dir, base, logIndexExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		err = nil
+		n++
+	}
+	l.bases = append(l.bases[:0], l.bases[n:]...)
+	return n, err
+}
+
+// Sync 将当前段刷到磁盘
+func (l *FrameLog) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	return l.sync()
+}
+
+// Close 刷盘并关闭日志
+func (l *FrameLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	err := l.sync()
+	if closeErr := l.log.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := l.idx.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sync 刷盘当前段，调用方需持有锁
+func (l *FrameLog) sync() error {
+	if err := l.log.Sync(); err != nil {
+		return err
+	}
+	return l.idx.Sync()
+}
+
+// roll 关闭当前段并以下一条记录的偏移创建新段，调用方需持有锁
+func (l *FrameLog) roll() error {
+	if err := l.sync(); err != nil {
+		return err
+	}
+	l.log.Close()
+	l.idx.Close()
+	if err := l.openSegment(l.next); err != nil {
+		return err
+	}
+	l.bases = append(l.bases, l.next)
+	return nil
+}
+
+// openSegment 创建并打开起始偏移为base的空段
+func (l *FrameLog) openSegment(base uint64) error {
+	// 先创建索引文件，读取器按数据文件列出段时索引文件总是存在
+	idx, err := os.OpenFile(logSegmentPath(l.dir, base, logIndexExt), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	log, err := os.OpenFile(logSegmentPath(l.dir, base, logSegmentExt), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		idx.Close()
+		return err
+	}
+	if len(l.bases) == 0 {
+		l.bases = []uint64{base}
+	}
+	l.log, l.idx = log, idx
+	l.logSize = 0
+	l.next = base
+	l.segmentStart = time.Time{}
+	return nil
+}
+
+// recover 打开起始偏移为base的最后一段，校验索引并修复未索引或不完整的尾部
+func (l *FrameLog) recover(base uint64) error {
+	log, err := os.OpenFile(logSegmentPath(l.dir, base, logSegmentExt), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	idx, err := os.OpenFile(logSegmentPath(l.dir, base, logIndexExt), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Close()
+		return err
+	}
+	l.log, l.idx = log, idx
+
+	entries, logEnd, err := l.validIndex(base)
+	if err != nil {
+		l.log.Close()
+		l.idx.Close()
+		return err
+	}
+
+	l.next = base + uint64(len(entries))
+	if len(entries) > 0 {
+		l.segmentStart = time.Unix(0, entries[0].timestamp)
+		l.lastTimestamp = entries[len(entries)-1].timestamp
+	}
+	if err := l.idx.Truncate(int64(len(entries)) * logIndexEntryLength); err != nil {
+		return err
+	}
+
+	// 为索引之后的完整帧补建索引，截断不完整的尾部
+	end, err := l.reindexTail(logEnd)
+	if err != nil {
+		l.log.Close()
+		l.idx.Close()
+		return err
+	}
+	if err := l.log.Truncate(end); err != nil {
+		return err
+	}
+	l.logSize = end
+	return l.sync()
+}
+
+// validIndex 读取当前段的索引，返回有效的索引项前缀及最后一条有效记录的结束位置
+func (l *FrameLog) validIndex(base uint64) ([]logIndexEntry, int64, error) {
+	info, err := l.log.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	logSize := info.Size()
+
+	raw, err := io.ReadAll(io.NewSectionReader(l.idx, 0, 1<<62))
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := make([]logIndexEntry, 0, len(raw)/logIndexEntryLength)
+	position := int64(-1)
+	for i := 0; i+logIndexEntryLength <= len(raw); i += logIndexEntryLength {
+		e := decodeLogIndexEntry(raw[i:])
+		if e.offset != base+uint64(len(entries)) || e.position <= position || e.position >= logSize ||
+			(len(entries) == 0 && e.position != 0) {
+			break
+		}
+		entries = append(entries, e)
+		position = e.position
+	}
+
+	// 先写数据后写索引，只有末尾的索引项可能指向不完整的帧
+	for len(entries) > 0 {
+		last := entries[len(entries)-1]
+		length, err := logFrameLength(l.log, last.position, logSize)
+		if err == nil {
+			return entries, last.position + length, nil
+		}
+		entries = entries[:len(entries)-1]
+	}
+	return entries, 0, nil
+}
+
+// reindexTail 用StreamDecoder扫描数据文件中从start开始的未索引部分，为完整的帧追加索引项
+// 返回最后一个完整帧的结束位置
+func (l *FrameLog) reindexTail(start int64) (int64, error) {
+	decoder := NewStreamDecoder(MaxMessageLength + FrameHeaderLength + logRecoverChunk)
+	decoder.SetDecodeOptions(l.config.DecodeOptions...)
+
+	end := start
+	chunk := make([]byte, logRecoverChunk)
+	reader := io.NewSectionReader(l.log, start, 1<<62)
+	for {
+		n, readErr := reader.Read(chunk)
+		if n > 0 {
+			if err := decoder.Feed(chunk[:n]); err != nil {
+				return end, fmt.Errorf("reindex frame at %d: %w", end, err)
+			}
+		}
+		for {
+			before := decoder.Buffered()
+			frame, err := decoder.TryDecode()
+			if err != nil {
+				// 只有不完整的尾部才能截断，其他解码错误（如缺少签名校验密钥）交给调用方处理，避免丢弃有效记录
+				return end, fmt.Errorf("reindex frame at %d: %w", end, err)
+			}
+			if frame == nil {
+				break
+			}
+			entry := logIndexEntry{offset: l.next, timestamp: l.lastTimestamp, position: end}
+			if _, err := l.idx.WriteAt(entry.encode(), int64(l.next-l.bases[len(l.bases)-1])*logIndexEntryLength); err != nil {
+				return end, err
+			}
+			end += int64(before - decoder.Buffered())
+			l.next++
+		}
+		if readErr == io.EOF {
+			return end, nil
+		}
+		if readErr != nil {
+			return end, readErr
+		}
+	}
+}
+
+// FrameLogReader 帧日志读取器，可以与FrameLog的写入并发进行
+// 读到末尾时返回io.EOF，之后追加的记录可以继续读取
+//
+// 并发安全说明：
+// FrameLogReader不是并发安全的，每个goroutine应使用独立的读取器
+type FrameLogReader struct {
+	dir string
+	// decodeOptions 解码帧时使用的选项
+	decodeOptions []DecodeOption
+	// bases 已知段的起始偏移
+	bases []uint64
+	// segment 当前段在bases中的下标
+	segment int
+	// log 当前段的数据文件
+	log *os.File
+	// idx 当前段的索引文件
+	idx *os.File
+	// next 下一条要读取的记录偏移
+	next uint64
+}
+
+// OpenFrameLogReader 打开目录中的帧日志用于读取，初始位置为最早的记录
+func OpenFrameLogReader(dir string, options ...DecodeOption) (*FrameLogReader, error) {
+	bases, err := listLogSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("no frame log segments in %s", dir)
+	}
+	r := &FrameLogReader{dir: dir, decodeOptions: options, bases: bases}
+	if err := r.openSegment(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Offset 返回下一条要读取的记录偏移
+func (r *FrameLogReader) Offset() uint64 {
+	return r.next
+}
+
+// Next 读取下一条记录，没有更多记录时返回io.EOF
+func (r *FrameLogReader) Next() (*LogRecord, error) {
+	for {
+		entry, err := r.entry(r.next)
+		if err == nil {
+			raw, err := r.readFrame(entry.position)
+			if err != nil {
+				return nil, err
+			}
+			frame, err := Decode(raw, r.decodeOptions...)
+			if err != nil {
+				return nil, err
+			}
+			r.next++
+			return &LogRecord{Offset: entry.offset, Timestamp: time.Unix(0, entry.timestamp), Frame: frame}, nil
+		}
+		if err != io.EOF {
+			return nil, err
+		}
+
+		// 当前段读完，切换到下一段；已知的段都读完时重新列出段，读取器打开后可能滚动了新段
+		if r.segment+1 < len(r.bases) && r.bases[r.segment+1] <= r.next {
+			if err := r.openSegment(r.segment + 1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		base := r.bases[r.segment]
+		if err := r.refresh(); err != nil {
+			return nil, err
+		}
+		if r.bases[r.segment] == base && (r.segment+1 == len(r.bases) || r.bases[r.segment+1] > r.next) {
+			return nil, io.EOF
+		}
+	}
+}
+
+// SeekOffset 定位到偏移为offset的记录，offset超过最后一条记录时定位到末尾
+// offset所在的段已被删除时返回错误
+func (r *FrameLogReader) SeekOffset(offset uint64) error {
+	if err := r.refresh(); err != nil {
+		return err
+	}
+	if offset < r.bases[0] {
+		return fmt.Errorf("offset %d is before the first retained segment %d", offset, r.bases[0])
+	}
+	segment := sort.Search(len(r.bases), func(i int) bool { return r.bases[i] > offset }) - 1
+	if err := r.openSegment(segment); err != nil {
+		return err
+	}
+	r.next = offset
+	return nil
+}
+
+// SeekTime 定位到第一条时间不早于t的记录，没有这样的记录时定位到末尾
+func (r *FrameLogReader) SeekTime(t time.Time) error {
+	if err := r.refresh(); err != nil {
+		return err
+	}
+	target := t.UnixNano()
+
+	// 找到最后一个首条记录早于t的段，t所在的记录只可能在该段或其后一段的开头
+	segment := 0
+	for i := 1; i < len(r.bases); i++ {
+		if err := r.openSegment(i); err != nil {
+			return err
+		}
+		first, err := r.entry(r.bases[i])
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF || first.timestamp >= target {
+			break
+		}
+		segment = i
+	}
+	if err := r.openSegment(segment); err != nil {
+		return err
+	}
+
+	info, err := r.idx.Stat()
+	if err != nil {
+		return err
+	}
+	count := int(info.Size() / logIndexEntryLength)
+	var searchErr error
+	i := sort.Search(count, func(i int) bool {
+		e, err := r.entry(r.bases[segment] + uint64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return e.timestamp >= target
+	})
+	if searchErr != nil {
+		return searchErr
+	}
+	r.next = r.bases[segment] + uint64(i)
+	return nil
+}
+
+// Close 关闭读取器
+func (r *FrameLogReader) Close() error {
+	return r.closeSegment()
+}
+
+// refresh 重新读取段列表
+func (r *FrameLogReader) refresh() error {
+	bases, err := listLogSegments(r.dir)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return fmt.Errorf("no frame log segments in %s", r.dir)
+	}
+	current, next := r.bases[r.segment], r.next
+	r.bases = bases
+	// 当前位置所在的段已被删除时从最早的记录继续
+	if next < bases[0] {
+		next = bases[0]
+	}
+	segment := sort.Search(len(bases), func(i int) bool { return bases[i] > next }) - 1
+	if bases[segment] != current {
+		if err := r.openSegment(segment); err != nil {
+			return err
+		}
+	}
+	r.segment, r.next = segment, next
+	return nil
+}
+
+// openSegment 打开bases中第i个段，读取位置移到段首
+func (r *FrameLogReader) openSegment(i int) error {
+	if err := r.closeSegment(); err != nil {
+		return err
+	}
+	log, err := os.Open(logSegmentPath(r.dir, r.bases[i], logSegmentExt))
+	if err != nil {
+		return err
+	}
+	idx, err := os.Open(logSegmentPath(r.dir, r.bases[i], logIndexExt))
+	if err != nil {
+		log.Close()
+		return err
+	}
+	r.segment, r.log, r.idx = i, log, idx
+	r.next = r.bases[i]
+	return nil
+}
+
+// closeSegment 关闭当前段的文件
+func (r *FrameLogReader) closeSegment() error {
+	if r.log == nil {
+		return nil
+	}
+	err := r.log.Close()
+	if idxErr := r.idx.Close(); err == nil {
+		err = idxErr
+	}
+	r.log, r.idx = nil, nil
+	return err
+}
+
+// entry 读取当前段中偏移为offset的索引项，不存在（尚未写入或写入不完整）时返回io.EOF
+func (r *FrameLogReader) entry(offset uint64) (logIndexEntry, error) {
+	buf := make([]byte, logIndexEntryLength)
+	n, err := r.idx.ReadAt(buf, int64(offset-r.bases[r.segment])*logIndexEntryLength)
+	if n < logIndexEntryLength {
+		if err == nil || err == io.EOF {
+			return logIndexEntry{}, io.EOF
+		}
+		return logIndexEntry{}, err
+	}
+	return decodeLogIndexEntry(buf), nil
+}
+
+// readFrame 读取当前段中位于position的完整帧编码
+func (r *FrameLogReader) readFrame(position int64) ([]byte, error) {
+	header := make([]byte, FrameHeaderLength)
+	if _, err := r.log.ReadAt(header, position); err != nil {
+		return nil, fmt.Errorf("read frame header at %d: %w", position, err)
+	}
+	raw := make([]byte, FrameHeaderLength+int(binary.BigEndian.Uint32(header[3:7])))
+	copy(raw, header)
+	if _, err := r.log.ReadAt(raw[FrameHeaderLength:], position+FrameHeaderLength); err != nil {
+		return nil, fmt.Errorf("read frame body at %d: %w", position, err)
+	}
+	return raw, nil
+}
+
+// logFrameLength 读取位于position的帧头并返回完整帧的长度，帧超出文件末尾时返回错误
+func logFrameLength(f *os.File, position, size int64) (int64, error) {
+	header := make([]byte, FrameHeaderLength)
+	if _, err := f.ReadAt(header, position); err != nil {
+		return 0, err
+	}
+	length := int64(FrameHeaderLength) + int64(binary.BigEndian.Uint32(header[3:7]))
+	if position+length > size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return length, nil
+}
+
+// logSegmentPath 返回段文件路径
+func logSegmentPath(dir string, base uint64, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, ext))
+}
+
+// listLogSegments 返回目录中所有段的起始偏移，升序
+func listLogSegments(dir string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+logSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+	bases := make([]uint64, 0, len(names))
+	for _, name := range names {
+		base, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), logSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
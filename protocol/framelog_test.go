@@ -0,0 +1,227 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestFrameLog opens a frame log driven by a fake clock
+func openTestFrameLog(t *testing.T, dir string, config FrameLogConfig, clock *fakeClock) *FrameLog {
+	t.Helper()
+	log, err := OpenFrameLog(dir, config)
+	if err != nil {
+		t.Fatalf("Failed to open frame log: %v", err)
+	}
+	log.now = clock.Now
+	return log
+}
+
+// appendTestFrames appends frames with bodies "frame-<n>" one second apart
+func appendTestFrames(t *testing.T, log *FrameLog, clock *fakeClock, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		frame, _ := NewFrame(FrameTypeJSON, []byte(fmt.Sprintf("frame-%d", i)))
+		offset, err := log.Append(frame)
+		if err != nil {
+			t.Fatalf("Failed to append frame: %v", err)
+		}
+		if offset != uint64(i) {
+			t.Fatalf("Expected offset %d, got %d", i, offset)
+		}
+		clock.Advance(time.Second)
+	}
+}
+
+// expectLogRecord reads the next record and checks its offset and body
+func expectLogRecord(t *testing.T, reader *FrameLogReader, offset int) *LogRecord {
+	t.Helper()
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Failed to read record %d: %v", offset, err)
+	}
+	if record.Offset != uint64(offset) || string(record.Frame.Body) != fmt.Sprintf("frame-%d", offset) {
+		t.Fatalf("Expected record %d, got %d %q", offset, record.Offset, record.Frame.Body)
+	}
+	return record
+}
+
+// TestFrameLogSeek tests segment rolling, sequential reads, seeking and retention
+func TestFrameLogSeek(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Unix(1700000000, 0)
+	clock := &fakeClock{now: start}
+	// Each frame is 15 bytes, so every segment holds 4 frames
+	log := openTestFrameLog(t, dir, FrameLogConfig{SegmentBytes: 60}, clock)
+	defer log.Close()
+	appendTestFrames(t, log, clock, 0, 20)
+	if segments := log.Segments(); len(segments) != 5 || segments[1] != 4 {
+		t.Fatalf("Unexpected segments: %v", segments)
+	}
+
+	reader, err := OpenFrameLogReader(dir)
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer reader.Close()
+	for i := 0; i < 20; i++ {
+		record := expectLogRecord(t, reader, i)
+		if !record.Timestamp.Equal(start.Add(time.Duration(i) * time.Second)) {
+			t.Errorf("Unexpected timestamp for %d: %v", i, record.Timestamp)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+
+	// Records appended after EOF, including into a new segment, become readable
+	appendTestFrames(t, log, clock, 20, 22)
+	expectLogRecord(t, reader, 20)
+	expectLogRecord(t, reader, 21)
+
+	if err := reader.SeekOffset(13); err != nil {
+		t.Fatalf("Failed to seek to offset: %v", err)
+	}
+	expectLogRecord(t, reader, 13)
+
+	if err := reader.SeekTime(start.Add(7500 * time.Millisecond)); err != nil {
+		t.Fatalf("Failed to seek to time: %v", err)
+	}
+	expectLogRecord(t, reader, 8)
+	reader.SeekTime(start.Add(-time.Hour))
+	expectLogRecord(t, reader, 0)
+	reader.SeekTime(start.Add(time.Hour))
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Expected EOF after seeking past the end, got %v", err)
+	}
+
+	removed, err := log.RemoveBefore(10)
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected 2 segments removed, got %d, %v", removed, err)
+	}
+	if err := reader.SeekOffset(3); err == nil {
+		t.Error("Expected error seeking into a removed segment")
+	}
+	if err := reader.SeekOffset(8); err != nil {
+		t.Fatalf("Failed to seek to offset: %v", err)
+	}
+	expectLogRecord(t, reader, 8)
+}
+
+// TestFrameLogRecovery tests reindexing unindexed frames and truncating a torn tail after a crash
+func TestFrameLogRecovery(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	log := openTestFrameLog(t, dir, FrameLogConfig{}, clock)
+	appendTestFrames(t, log, clock, 0, 5)
+	log.Close()
+
+	// Simulate a crash: one frame written without its index entry, a torn frame and a torn index entry
+	appendFile := func(path string, data []byte) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", path, err)
+		}
+		defer f.Close()
+		f.Write(data)
+	}
+	unindexed, _ := NewFrame(FrameTypeJSON, []byte("frame-5"))
+	torn, _ := NewFrame(FrameTypeJSON, []byte("frame-6"))
+	unindexedData, _ := unindexed.Encode()
+	tornData, _ := torn.Encode()
+	appendFile(logSegmentPath(dir, 0, logSegmentExt), append(unindexedData, tornData[:len(tornData)-3]...))
+	appendFile(logSegmentPath(dir, 0, logIndexExt), make([]byte, logIndexEntryLength/2))
+
+	log = openTestFrameLog(t, dir, FrameLogConfig{}, clock)
+	defer log.Close()
+	if next := log.NextOffset(); next != 6 {
+		t.Fatalf("Expected next offset 6 after recovery, got %d", next)
+	}
+	appendTestFrames(t, log, clock, 6, 8)
+
+	reader, err := OpenFrameLogReader(dir)
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer reader.Close()
+	for i := 0; i < 8; i++ {
+		record := expectLogRecord(t, reader, i)
+		if i == 5 && !record.Timestamp.Equal(time.Unix(1700000004, 0)) {
+			t.Errorf("Expected recovered record to take the last indexed timestamp, got %v", record.Timestamp)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+// TestFrameLogAppendAfterPartialWrite tests that bytes left behind by a failed append do not shift later records
+func TestFrameLogAppendAfterPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	log := openTestFrameLog(t, dir, FrameLogConfig{}, clock)
+	defer log.Close()
+	appendTestFrames(t, log, clock, 0, 2)
+
+	// A short write leaves uncommitted bytes at the end of both files
+	for _, f := range []*os.File{log.log, log.idx} {
+		f.Seek(0, io.SeekEnd)
+		f.Write([]byte("torn"))
+	}
+	appendTestFrames(t, log, clock, 2, 4)
+
+	reader, err := OpenFrameLogReader(dir)
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer reader.Close()
+	for i := 0; i < 4; i++ {
+		expectLogRecord(t, reader, i)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Expected EOF after the last record, got %v", err)
+	}
+}
+
+// TestFrameLogRecoverySignedWithoutVerifier tests that recovery keeps signed records it cannot verify and reports an error
+func TestFrameLogRecoverySignedWithoutVerifier(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	log := openTestFrameLog(t, dir, FrameLogConfig{}, clock)
+	appendTestFrames(t, log, clock, 0, 2)
+	log.Close()
+
+	// A signed frame written without its index entry
+	signer, err := NewFrameSigner(1, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	frame, _ := NewFrame(FrameTypeJSON, []byte("frame-2"))
+	data, err := frame.Encode(WithSigner(signer))
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+	path := logSegmentPath(dir, 0, logSegmentExt)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	f.Write(data)
+	f.Close()
+	before, _ := os.Stat(path)
+
+	if _, err := OpenFrameLog(dir, FrameLogConfig{}); err == nil {
+		t.Fatal("Expected recovery without a verifier to fail")
+	}
+	if after, _ := os.Stat(path); after.Size() != before.Size() {
+		t.Fatalf("Recovery truncated the log from %d to %d bytes", before.Size(), after.Size())
+	}
+
+	log = openTestFrameLog(t, dir, FrameLogConfig{DecodeOptions: []DecodeOption{WithSignatureVerifier(signer)}}, clock)
+	defer log.Close()
+	if next := log.NextOffset(); next != 3 {
+		t.Fatalf("Expected the signed record to be recovered, next offset %d", next)
+	}
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultThroughputGracePeriod 开始检查最低接收速率前的默认宽限期
+const DefaultThroughputGracePeriod = time.Second
+
+// FrameTimeoutConfig 慢速攻击（Slowloris）防护配置
+// 帧头到达后开始计时，限制完成整帧的时间和最低接收速率，零值表示不限制
+type FrameTimeoutConfig struct {
+	// MaxFrameTime 帧头到达后完成整帧的最长时间
+	MaxFrameTime time.Duration
+	// MinBytesPerSecond 帧头到达后的最低平均接收速率（字节/秒）
+	MinBytesPerSecond int
+	// GracePeriod 开始检查最低接收速率前的宽限期，默认DefaultThroughputGracePeriod
+	GracePeriod time.Duration
+}
+
+// enabled 是否启用了任一限制
+func (c *FrameTimeoutConfig) enabled() bool {
+	return c.MaxFrameTime > 0 || c.MinBytesPerSecond > 0
+}
+
+// partialFrameState 正在接收的不完整帧的计时状态
+type partialFrameState struct {
+	// start 帧头到达的时间，零值表示当前没有不完整的帧
+	start time.Time
+	// startBuffered 帧头到达时缓冲区中的数据量
+	startBuffered int
+}
+
+// SetFrameTimeout 设置慢速攻击防护
+// 超出限制时TryDecode和ReadFrame返回ErrCodeFrameTimeout错误，连接应被关闭
+//
+// 通过Conn读取时，会在等待不完整帧期间收紧连接的读截止时间，使静默的对端也能被及时检测到；
+// 生效的截止时间取调用方设置的截止时间与帧截止时间中较早的一个，帧完成或出错后恢复为调用方设置的截止时间。
+// 直接向ReadFrame传入其他reader（如net.Conn）时不会修改其截止时间，静默的对端只能由调用方的截止时间检测
+func (sd *StreamDecoder) SetFrameTimeout(config FrameTimeoutConfig) {
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = DefaultThroughputGracePeriod
+	}
+	sd.frameTimeout = config
+	sd.partial = partialFrameState{}
+}
+
+// clock 返回当前时间
+func (sd *StreamDecoder) clock() time.Time {
+	if sd.now != nil {
+		return sd.now()
+	}
+	return time.Now()
+}
+
+// checkPartialFrame 检查不完整帧是否超出时间或速率限制
+// 仅在帧头已到达、消息体尚不完整时调用
+func (sd *StreamDecoder) checkPartialFrame() error {
+	if !sd.frameTimeout.enabled() {
+		return nil
+	}
+
+	now := sd.clock()
+	if sd.partial.start.IsZero() {
+		sd.partial = partialFrameState{start: now, startBuffered: len(sd.buffer)}
+		return nil
+	}
+
+	elapsed := now.Sub(sd.partial.start)
+	if sd.frameTimeout.MaxFrameTime > 0 && elapsed > sd.frameTimeout.MaxFrameTime {
+		return NewFrameTimeoutError(fmt.Sprintf("frame not completed within %v", sd.frameTimeout.MaxFrameTime))
+	}
+
+	if sd.frameTimeout.MinBytesPerSecond > 0 && elapsed >= sd.frameTimeout.GracePeriod {
+		received := len(sd.buffer) - sd.partial.startBuffered
+		rate := float64(received) / elapsed.Seconds()
+		if rate < float64(sd.frameTimeout.MinBytesPerSecond) {
+			return NewFrameTimeoutError(fmt.Sprintf("throughput %.0f bytes/s is below minimum %d bytes/s", rate, sd.frameTimeout.MinBytesPerSecond))
+		}
+	}
+	return nil
+}
+
+// partialFrameDeadline 返回不完整帧在没有新数据到达时触发超时的时间
+// 当前没有不完整的帧或未启用限制时返回false
+func (sd *StreamDecoder) partialFrameDeadline() (time.Time, bool) {
+	if !sd.frameTimeout.enabled() || sd.partial.start.IsZero() {
+		return time.Time{}, false
+	}
+
+	var deadline time.Time
+	if sd.frameTimeout.MaxFrameTime > 0 {
+		deadline = sd.partial.start.Add(sd.frameTimeout.MaxFrameTime)
+	}
+
+	if sd.frameTimeout.MinBytesPerSecond > 0 {
+		// 不再有数据到达时，平均速率降到最低速率以下的时间点
+		received := len(sd.buffer) - sd.partial.startBuffered
+		wait := time.Duration(float64(received) / float64(sd.frameTimeout.MinBytesPerSecond) * float64(time.Second))
+		wait = max(wait, sd.frameTimeout.GracePeriod)
+		if throughputDeadline := sd.partial.start.Add(wait); deadline.IsZero() || throughputDeadline.Before(deadline) {
+			deadline = throughputDeadline
+		}
+	}
+
+	// 留出少量余量，确保截止时间到达时检查一定失败
+	return deadline.Add(time.Millisecond), true
+}
+
+// frameDeadlineSetter 能在调用方的读截止时间之上叠加不完整帧截止时间的reader，由Conn提供
+// 零值表示清除帧截止时间，恢复为调用方设置的读截止时间
+type frameDeadlineSetter interface {
+	setFrameDeadline(t time.Time) error
+}
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestStreamDecoderMaxFrameTime tests the deadline for completing a partially received frame
+func TestStreamDecoderMaxFrameTime(t *testing.T) {
+	frame, err := NewFrame(FrameTypeJSON, make([]byte, 100))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	decoder := NewStreamDecoder()
+	decoder.now = clock.Now
+	decoder.SetFrameTimeout(FrameTimeoutConfig{MaxFrameTime: time.Second})
+
+	// A frame completed in time resets the timer
+	decoder.Feed(data[:FrameHeaderLength])
+	if f, err := decoder.TryDecode(); f != nil || err != nil {
+		t.Fatalf("Expected pending frame, got %v, %v", f, err)
+	}
+	clock.Advance(900 * time.Millisecond)
+	decoder.Feed(data[FrameHeaderLength:])
+	if f, err := decoder.TryDecode(); f == nil || err != nil {
+		t.Fatalf("Expected completed frame, got %v, %v", f, err)
+	}
+
+	// The next frame gets its own full time budget
+	decoder.Feed(data[:FrameHeaderLength+10])
+	if _, err := decoder.TryDecode(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	clock.Advance(1100 * time.Millisecond)
+	decoder.Feed(data[FrameHeaderLength+10 : FrameHeaderLength+20])
+	if _, err := decoder.TryDecode(); !IsFrameTimeoutError(err) {
+		t.Errorf("Expected frame timeout error, got %v", err)
+	}
+}
+
+// TestStreamDecoderMinThroughput tests the minimum throughput guard
+func TestStreamDecoderMinThroughput(t *testing.T) {
+	frame, err := NewFrame(FrameTypeJSON, make([]byte, 1000))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	decoder := NewStreamDecoder()
+	decoder.now = clock.Now
+	decoder.SetFrameTimeout(FrameTimeoutConfig{MinBytesPerSecond: 100, GracePeriod: time.Second})
+
+	decoder.Feed(data[:FrameHeaderLength])
+	if _, err := decoder.TryDecode(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Slow but within the grace period
+	clock.Advance(500 * time.Millisecond)
+	decoder.Feed(data[FrameHeaderLength : FrameHeaderLength+10])
+	if _, err := decoder.TryDecode(); err != nil {
+		t.Fatalf("Unexpected error within grace period: %v", err)
+	}
+
+	// 210 bytes in 1.5s stays above 100 bytes/s
+	clock.Advance(time.Second)
+	decoder.Feed(data[FrameHeaderLength+10 : FrameHeaderLength+210])
+	if _, err := decoder.TryDecode(); err != nil {
+		t.Fatalf("Unexpected error above minimum throughput: %v", err)
+	}
+
+	// Trickling one byte after 2 more seconds drops below the minimum
+	clock.Advance(2 * time.Second)
+	decoder.Feed(data[FrameHeaderLength+210 : FrameHeaderLength+211])
+	if _, err := decoder.TryDecode(); !IsFrameTimeoutError(err) {
+		t.Errorf("Expected frame timeout error, got %v", err)
+	}
+}
+
+// TestConnFrameTimeoutSilentPeer tests that a peer stalling after a header is detected without further data
+func TestConnFrameTimeoutSilentPeer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	conn := NewConn(server)
+	defer conn.Close()
+	conn.SetFrameTimeout(FrameTimeoutConfig{MaxFrameTime: 50 * time.Millisecond})
+
+	// Header announcing a 1MB body, then nothing
+	header := []byte{ProtocolVersionV1, 0, FrameTypeJSON, 0x00, 0x10, 0x00, 0x00}
+	go client.Write(header)
+
+	start := time.Now()
+	_, err := conn.ReadFrame()
+	if !IsFrameTimeoutError(err) {
+		t.Fatalf("Expected frame timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Frame timeout took too long: %v", elapsed)
+	}
+}
+
+// TestConnFrameTimeoutKeepsCallerDeadline tests that the frame timeout only tightens the caller's read deadline and restores it afterwards
+func TestConnFrameTimeoutKeepsCallerDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	conn := NewConn(server)
+	defer conn.Close()
+	conn.SetFrameTimeout(FrameTimeoutConfig{MaxFrameTime: time.Minute})
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	// A frame arriving in two parts installs and then clears the frame deadline
+	frame, _ := NewFrame(FrameTypeJSON, []byte(`{}`))
+	data, _ := frame.Encode()
+	go func() {
+		client.Write(data[:FrameHeaderLength])
+		client.Write(data[FrameHeaderLength:])
+	}()
+	if _, err := conn.ReadFrame(); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+
+	// The caller's deadline still applies to a silent peer, both before and during a partial frame
+	start := time.Now()
+	if _, err := conn.ReadFrame(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected the caller's deadline to fire, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	go client.Write(data[:FrameHeaderLength])
+	if _, err := conn.ReadFrame(); !errors.Is(err, os.ErrDeadlineExceeded) || IsFrameTimeoutError(err) {
+		t.Fatalf("Expected the caller's deadline to fire during a partial frame, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Caller deadline took too long: %v", elapsed)
+	}
+}
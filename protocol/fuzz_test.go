@@ -0,0 +1,200 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 种子语料库位于testdata/fuzz/<FuzzName>，go test默认会运行其中的所有输入；
+// 持续模糊测试使用：go test -run=^$ -fuzz=FuzzDecode ./protocol
+
+// fuzzSigner 模糊测试使用的固定签名密钥
+func fuzzSigner(t testing.TB) *FrameSigner {
+	signer, err := NewFrameSigner(1, []byte("fuzz-key"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+// addFuzzSeeds adds a handful of valid frames covering every version, type and extension
+func addFuzzSeeds(f *testing.F, add func(data []byte)) {
+	signer := fuzzSigner(f)
+	for _, version := range SupportedVersions {
+		for _, frameType := range []uint8{FrameTypeJSON, FrameTypeProtobuf, FrameTypeMsgPack} {
+			frame, _ := NewFrame(frameType, []byte(`{"k":"v"}`), WithVersion(version), WithSubVersion(version))
+			data, _ := frame.Encode()
+			add(data)
+			frame.Sequence = 42
+			data, _ = frame.Encode(WithSigner(signer))
+			add(data)
+		}
+	}
+	empty, _ := NewFrame(FrameTypeJSON, nil)
+	data, _ := empty.Encode()
+	add(data)
+	add([]byte{})
+	add([]byte{0x01, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff})
+}
+
+// expectSameFrame checks that two frames carry the same fields
+func expectSameFrame(t *testing.T, want, got *Frame) {
+	t.Helper()
+	if want.Version != got.Version || want.SubVersion != got.SubVersion || want.Type != got.Type ||
+		want.Sequence != got.Sequence || want.Signed != got.Signed || want.KeyID != got.KeyID ||
+		!bytes.Equal(want.Body, got.Body) || want.GetBodyLength() != got.GetBodyLength() {
+		t.Fatalf("Frame mismatch:\nwant %v seq=%d signed=%v\n got %v seq=%d signed=%v",
+			want, want.Sequence, want.Signed, got, got.Sequence, got.Signed)
+	}
+}
+
+// FuzzDecode tests that Decode never panics and that whatever it accepts re-encodes to an equivalent frame
+func FuzzDecode(f *testing.F) {
+	addFuzzSeeds(f, func(data []byte) { f.Add(data) })
+	signer := fuzzSigner(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, options := range [][]DecodeOption{nil, {WithSignatureVerifier(signer)}} {
+			frame, err := Decode(data, options...)
+			if err != nil {
+				if GetErrorCode(err) == ErrCodeUnknown {
+					t.Fatalf("Decode returned a non-protocol error: %v", err)
+				}
+				continue
+			}
+			if int(frame.GetBodyLength()) != len(frame.Body) || len(frame.Body) > MaxMessageLength {
+				t.Fatalf("Inconsistent body length %d for %d bytes", frame.GetBodyLength(), len(frame.Body))
+			}
+
+			var encodeOptions []EncodeOption
+			if frame.Signed {
+				encodeOptions = append(encodeOptions, WithSigner(signer))
+			}
+			encoded, err := frame.Encode(encodeOptions...)
+			if err != nil {
+				t.Fatalf("Failed to re-encode decoded frame: %v", err)
+			}
+			decoded, err := Decode(encoded, options...)
+			if err != nil {
+				t.Fatalf("Failed to decode re-encoded frame: %v", err)
+			}
+			expectSameFrame(t, frame, decoded)
+		}
+	})
+}
+
+// FuzzStreamDecoder tests that feeding a stream in arbitrary chunks yields the same frames and errors as feeding it at once
+func FuzzStreamDecoder(f *testing.F) {
+	addFuzzSeeds(f, func(data []byte) {
+		stream := append(append([]byte{}, data...), data...)
+		f.Add(stream, []byte{1, 7, 3})
+	})
+
+	f.Fuzz(func(t *testing.T, stream []byte, chunks []byte) {
+		whole := drainStream(t, stream, nil)
+		chunked := drainStream(t, stream, chunks)
+		if len(whole) != len(chunked) {
+			t.Fatalf("Got %d results at once but %d when chunked", len(whole), len(chunked))
+		}
+		for i := range whole {
+			if whole[i].code != chunked[i].code {
+				t.Fatalf("Result %d: error code %d at once but %d when chunked", i, whole[i].code, chunked[i].code)
+			}
+			if whole[i].frame != nil {
+				expectSameFrame(t, whole[i].frame, chunked[i].frame)
+			}
+		}
+	})
+}
+
+// streamResult is one frame or error produced by a StreamDecoder
+type streamResult struct {
+	frame *Frame
+	code  ErrorCode
+}
+
+// drainStream feeds stream to a fresh decoder in chunks whose sizes cycle through chunks
+// (all at once when empty) and collects every frame and error until the stream cannot advance
+func drainStream(t *testing.T, stream []byte, chunks []byte) []streamResult {
+	decoder := NewStreamDecoder()
+	var results []streamResult
+	for i := 0; ; i++ {
+		n := len(stream)
+		if len(chunks) > 0 {
+			n = min(int(chunks[i%len(chunks)])+1, len(stream))
+		}
+		if err := decoder.Feed(stream[:n]); err != nil {
+			t.Fatalf("Failed to feed %d bytes: %v", n, err)
+		}
+		stream = stream[n:]
+
+		for {
+			buffered := decoder.Buffered()
+			frame, err := decoder.TryDecode()
+			if err != nil {
+				results = append(results, streamResult{code: GetErrorCode(err)})
+				if decoder.Buffered() == buffered {
+					// 帧头错误无法确定帧边界，流无法继续
+					return results
+				}
+				continue
+			}
+			if frame == nil {
+				break
+			}
+			results = append(results, streamResult{frame: frame})
+		}
+		if len(stream) == 0 {
+			return results
+		}
+	}
+}
+
+// FuzzRoundTrip tests that every frame the encoder accepts decodes back to the same fields
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(uint8(1), uint8(0), uint8(FrameTypeJSON), uint64(0), false, []byte(`{"k":"v"}`))
+	f.Add(uint8(2), uint8(3), uint8(FrameTypeMsgPack), uint64(1<<63), true, []byte{0x80})
+	f.Add(uint8(2), uint8(255), uint8(FrameTypeProtobuf), uint64(1), true, []byte{})
+	signer := fuzzSigner(f)
+
+	f.Fuzz(func(t *testing.T, version, subVersion, frameType uint8, sequence uint64, signed bool, body []byte) {
+		frame, err := NewFrame(frameType, body, WithVersion(version), WithSubVersion(subVersion))
+		if err != nil {
+			return
+		}
+		frame.Sequence = sequence
+
+		var encodeOptions []EncodeOption
+		var decodeOptions []DecodeOption
+		if signed {
+			encodeOptions = append(encodeOptions, WithSigner(signer))
+			decodeOptions = append(decodeOptions, WithSignatureVerifier(signer))
+		}
+		encoded, err := frame.Encode(encodeOptions...)
+		if err != nil {
+			if len(body) <= MaxMessageLength-SequenceLength-SignatureTrailerLength {
+				t.Fatalf("Failed to encode frame: %v", err)
+			}
+			return
+		}
+
+		// Encode, EncodeTo and EncodeToBytes must agree
+		var buf bytes.Buffer
+		if _, err := frame.EncodeTo(&buf, encodeOptions...); err != nil || !bytes.Equal(buf.Bytes(), encoded) {
+			t.Fatalf("EncodeTo disagrees with Encode: %v", err)
+		}
+		direct := make([]byte, len(encoded))
+		if n, err := frame.EncodeToBytes(direct, encodeOptions...); err != nil || n != len(encoded) || !bytes.Equal(direct, encoded) {
+			t.Fatalf("EncodeToBytes disagrees with Encode: %v", err)
+		}
+
+		decoded, err := Decode(encoded, decodeOptions...)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+		if signed {
+			frame.Signed, frame.KeyID = true, 1
+		}
+		expectSameFrame(t, frame, decoded)
+	})
+}
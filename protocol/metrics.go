@@ -0,0 +1,335 @@
+package protocol
+
+import (
+	"expvar"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// Metrics 编解码和缓冲区池的指标接口
+// 方法在编解码的热路径上同步调用，实现必须并发安全且足够轻量，不应阻塞
+//
+// 调用时机：
+//   - FrameEncoded/EncodeFailed：Encode、EncodeTo、EncodeToBytes、EncodeShared
+//   - FrameDecoded/DecodeFailed：Decode、StreamDecoder.TryDecode（包括被去重丢弃的帧）
+//   - BytesFed：StreamDecoder.Feed，Feed超出缓冲区上限时调用DecodeFailed
+//   - BufferGet/BufferPut：分级缓冲区池的Get/Put
+type Metrics interface {
+	// FrameEncoded 成功编码一帧，size为帧的总字节数
+	FrameEncoded(frameType uint8, size int)
+	// EncodeFailed 帧无法编码（不包括写入io.Writer失败）
+	EncodeFailed(code ErrorCode)
+	// FrameDecoded 成功解码一帧，size为帧的总字节数
+	FrameDecoded(frameType uint8, size int)
+	// DecodeFailed 解码失败
+	DecodeFailed(code ErrorCode)
+	// BytesFed 流式解码器接收了size字节的数据
+	BytesFed(size int)
+	// BufferGet 从缓冲区池获取size字节的缓冲区，hit表示复用了池中已有的缓冲区
+	BufferGet(size int, hit bool)
+	// BufferPut 归还容量为capacity的缓冲区，recycled表示缓冲区被放回池中而非交给GC
+	BufferPut(capacity int, recycled bool)
+}
+
+// nopMetrics 未设置Metrics时使用的空实现
+type nopMetrics struct{}
+
+func (nopMetrics) FrameEncoded(uint8, int) {}
+func (nopMetrics) EncodeFailed(ErrorCode)  {}
+func (nopMetrics) FrameDecoded(uint8, int) {}
+func (nopMetrics) DecodeFailed(ErrorCode)  {}
+func (nopMetrics) BytesFed(int)            {}
+func (nopMetrics) BufferGet(int, bool)     {}
+func (nopMetrics) BufferPut(int, bool)     {}
+
+// metricsHolder 包装Metrics接口，便于原子替换
+type metricsHolder struct {
+	m Metrics
+}
+
+// currentMetrics 当前使用的Metrics
+var currentMetrics atomic.Pointer[metricsHolder]
+
+func init() {
+	currentMetrics.Store(&metricsHolder{m: nopMetrics{}})
+}
+
+// SetMetrics 设置全局的指标实现，m为nil时关闭指标
+// 默认不收集指标；通常在程序启动时调用一次，例如：
+//
+//	metrics, err := NewExpvarMetrics("improtocol") // 通过 /debug/vars 查看
+//	if err == nil {
+//		SetMetrics(metrics)
+//	}
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	currentMetrics.Store(&metricsHolder{m: m})
+}
+
+// loadMetrics 返回当前使用的Metrics
+func loadMetrics() Metrics {
+	return currentMetrics.Load().m
+}
+
+// observeEncode 记录一次编码的结果
+func observeEncode(frameType uint8, size int, err error) {
+	if err != nil {
+		loadMetrics().EncodeFailed(GetErrorCode(err))
+		return
+	}
+	loadMetrics().FrameEncoded(frameType, size)
+}
+
+// observeDecode 记录一次解码的结果
+func observeDecode(frame *Frame, size int, err error) {
+	if err != nil {
+		loadMetrics().DecodeFailed(GetErrorCode(err))
+		return
+	}
+	loadMetrics().FrameDecoded(frame.Type, size)
+}
+
+// errorCodeNames 错误码在指标中使用的名称
+var errorCodeNames = map[ErrorCode]string{
+	ErrCodeUnknown:            "unknown",
+	ErrCodeMessageTooLong:     "message_too_long",
+	ErrCodeInvalidFrame:       "invalid_frame",
+	ErrCodeUnsupportedVersion: "unsupported_version",
+	ErrCodeInvalidFrameType:   "invalid_frame_type",
+	ErrCodeBufferTooSmall:     "buffer_too_small",
+	ErrCodeInvalidSignature:   "invalid_signature",
+	ErrCodeReplayDetected:     "replay_detected",
+	ErrCodeUnauthenticated:    "unauthenticated",
+	ErrCodeAuthFailed:         "auth_failed",
+	ErrCodeRateLimited:        "rate_limited",
+	ErrCodeFrameTimeout:       "frame_timeout",
+	ErrCodeInvalidMessage:     "invalid_message",
+}
+
+// errorCodeName 返回错误码在指标中使用的名称
+func errorCodeName(code ErrorCode) string {
+	if name, ok := errorCodeNames[code]; ok {
+		return name
+	}
+	return "code_" + strconv.Itoa(int(code))
+}
+
+// frameTypeMetricName 返回帧类型在指标中使用的名称
+func frameTypeMetricName(frameType uint8) string {
+	switch frameType {
+	case FrameTypeJSON:
+		return "json"
+	case FrameTypeProtobuf:
+		return "protobuf"
+	case FrameTypeMsgPack:
+		return "msgpack"
+	default:
+		return "type_" + strconv.Itoa(int(frameType))
+	}
+}
+
+// bufferClassName 返回缓冲区大小对应的池级别名称
+func bufferClassName(size int) string {
+	switch {
+	case size <= smallBufferSize:
+		return "small"
+	case size <= mediumBufferSize:
+		return "medium"
+	case size <= largeBufferSize:
+		return "large"
+	default:
+		return "oversize"
+	}
+}
+
+// frameSizeBuckets 帧大小分布的桶上限，最后一个桶收集更大的帧
+var frameSizeBuckets = []int{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+// frameSizeBucketNames 与frameSizeBuckets对应的桶名称
+var frameSizeBucketNames = func() []string {
+	names := make([]string, 0, len(frameSizeBuckets)+1)
+	for _, upper := range frameSizeBuckets {
+		names = append(names, "le_"+strconv.Itoa(upper))
+	}
+	return append(names, "inf")
+}()
+
+// frameSizeBucket 返回帧大小所在的桶名称
+func frameSizeBucket(size int) string {
+	for i, upper := range frameSizeBuckets {
+		if size <= upper {
+			return frameSizeBucketNames[i]
+		}
+	}
+	return frameSizeBucketNames[len(frameSizeBuckets)]
+}
+
+// ExpvarMetrics 基于expvar的Metrics实现
+// 所有指标发布在一个expvar.Map下，可通过expvar的 /debug/vars 接口查看，结构如下：
+//
+//	encoded / decoded            按帧类型的帧数，如 {"json": 10}
+//	encoded_bytes / decoded_bytes 按帧类型的字节数
+//	encoded_sizes / decoded_sizes 帧大小分布，如 {"le_64": 3, "le_256": 7}
+//	encode_errors / decode_errors 按错误码名称的错误数，如 {"invalid_frame": 1}
+//	fed_bytes                    StreamDecoder.Feed接收的字节数
+//	pool_gets / pool_hits        按池级别（small/medium/large/oversize）的获取次数和命中次数
+//	pool_hit_rate                按池级别的命中率
+//	pool_puts / pool_recycled    按池级别的归还次数和被放回池中的次数
+//
+// 使用示例：
+//
+//	metrics, err := NewExpvarMetrics("improtocol")
+//	if err != nil {
+//		return err
+//	}
+//	SetMetrics(metrics)
+//	go http.ListenAndServe("localhost:6060", nil) // expvar自动注册 /debug/vars
+type ExpvarMetrics struct {
+	// root 发布到expvar的根节点
+	root *expvar.Map
+	// encoded 按帧类型的编码帧数
+	encoded *expvar.Map
+	// encodedBytes 按帧类型的编码字节数
+	encodedBytes *expvar.Map
+	// encodedSizes 编码帧大小分布
+	encodedSizes *expvar.Map
+	// encodeErrors 按错误码的编码错误数
+	encodeErrors *expvar.Map
+	// decoded 按帧类型的解码帧数
+	decoded *expvar.Map
+	// decodedBytes 按帧类型的解码字节数
+	decodedBytes *expvar.Map
+	// decodedSizes 解码帧大小分布
+	decodedSizes *expvar.Map
+	// decodeErrors 按错误码的解码错误数
+	decodeErrors *expvar.Map
+	// fedBytes Feed接收的字节数
+	fedBytes *expvar.Int
+	// poolGets 按池级别的获取次数
+	poolGets *expvar.Map
+	// poolHits 按池级别的命中次数
+	poolHits *expvar.Map
+	// poolPuts 按池级别的归还次数
+	poolPuts *expvar.Map
+	// poolRecycled 按池级别被放回池中的次数
+	poolRecycled *expvar.Map
+}
+
+// NewExpvarMetrics 创建ExpvarMetrics并以name发布到expvar
+// name已被发布时返回错误（expvar不支持取消发布，同一名称只能创建一次）
+func NewExpvarMetrics(name string) (*ExpvarMetrics, error) {
+	if expvar.Get(name) != nil {
+		return nil, fmt.Errorf("protocol: expvar %q is already published", name)
+	}
+	m := newExpvarMetrics()
+	expvar.Publish(name, m.root)
+	return m, nil
+}
+
+// newExpvarMetrics 创建未发布的ExpvarMetrics
+func newExpvarMetrics() *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		root:         new(expvar.Map),
+		encoded:      new(expvar.Map),
+		encodedBytes: new(expvar.Map),
+		encodedSizes: new(expvar.Map),
+		encodeErrors: new(expvar.Map),
+		decoded:      new(expvar.Map),
+		decodedBytes: new(expvar.Map),
+		decodedSizes: new(expvar.Map),
+		decodeErrors: new(expvar.Map),
+		fedBytes:     new(expvar.Int),
+		poolGets:     new(expvar.Map),
+		poolHits:     new(expvar.Map),
+		poolPuts:     new(expvar.Map),
+		poolRecycled: new(expvar.Map),
+	}
+	m.root.Set("encoded", m.encoded)
+	m.root.Set("encoded_bytes", m.encodedBytes)
+	m.root.Set("encoded_sizes", m.encodedSizes)
+	m.root.Set("encode_errors", m.encodeErrors)
+	m.root.Set("decoded", m.decoded)
+	m.root.Set("decoded_bytes", m.decodedBytes)
+	m.root.Set("decoded_sizes", m.decodedSizes)
+	m.root.Set("decode_errors", m.decodeErrors)
+	m.root.Set("fed_bytes", m.fedBytes)
+	m.root.Set("pool_gets", m.poolGets)
+	m.root.Set("pool_hits", m.poolHits)
+	m.root.Set("pool_hit_rate", expvar.Func(m.poolHitRate))
+	m.root.Set("pool_puts", m.poolPuts)
+	m.root.Set("pool_recycled", m.poolRecycled)
+	return m
+}
+
+// Map 返回发布到expvar的根节点
+func (m *ExpvarMetrics) Map() *expvar.Map {
+	return m.root
+}
+
+// FrameEncoded 实现Metrics接口
+func (m *ExpvarMetrics) FrameEncoded(frameType uint8, size int) {
+	name := frameTypeMetricName(frameType)
+	m.encoded.Add(name, 1)
+	m.encodedBytes.Add(name, int64(size))
+	m.encodedSizes.Add(frameSizeBucket(size), 1)
+}
+
+// EncodeFailed 实现Metrics接口
+func (m *ExpvarMetrics) EncodeFailed(code ErrorCode) {
+	m.encodeErrors.Add(errorCodeName(code), 1)
+}
+
+// FrameDecoded 实现Metrics接口
+func (m *ExpvarMetrics) FrameDecoded(frameType uint8, size int) {
+	name := frameTypeMetricName(frameType)
+	m.decoded.Add(name, 1)
+	m.decodedBytes.Add(name, int64(size))
+	m.decodedSizes.Add(frameSizeBucket(size), 1)
+}
+
+// DecodeFailed 实现Metrics接口
+func (m *ExpvarMetrics) DecodeFailed(code ErrorCode) {
+	m.decodeErrors.Add(errorCodeName(code), 1)
+}
+
+// BytesFed 实现Metrics接口
+func (m *ExpvarMetrics) BytesFed(size int) {
+	m.fedBytes.Add(int64(size))
+}
+
+// BufferGet 实现Metrics接口
+func (m *ExpvarMetrics) BufferGet(size int, hit bool) {
+	class := bufferClassName(size)
+	m.poolGets.Add(class, 1)
+	if hit {
+		m.poolHits.Add(class, 1)
+	}
+}
+
+// BufferPut 实现Metrics接口
+func (m *ExpvarMetrics) BufferPut(capacity int, recycled bool) {
+	class := bufferClassName(capacity)
+	m.poolPuts.Add(class, 1)
+	if recycled {
+		m.poolRecycled.Add(class, 1)
+	}
+}
+
+// poolHitRate 按池级别计算命中率
+func (m *ExpvarMetrics) poolHitRate() any {
+	rates := make(map[string]float64)
+	m.poolGets.Do(func(kv expvar.KeyValue) {
+		gets := kv.Value.(*expvar.Int).Value()
+		var hits int64
+		if v, ok := m.poolHits.Get(kv.Key).(*expvar.Int); ok {
+			hits = v.Value()
+		}
+		if gets > 0 {
+			rates[kv.Key] = float64(hits) / float64(gets)
+		}
+	})
+	return rates
+}
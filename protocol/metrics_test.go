@@ -0,0 +1,224 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// recordingMetrics records every Metrics call
+type recordingMetrics struct {
+	mu           sync.Mutex
+	encoded      map[uint8]int
+	encodedBytes int
+	encodeErrors map[ErrorCode]int
+	decoded      map[uint8]int
+	decodedBytes int
+	decodeErrors map[ErrorCode]int
+	fed          int
+	gets, hits   int
+	puts, reused int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		encoded:      make(map[uint8]int),
+		encodeErrors: make(map[ErrorCode]int),
+		decoded:      make(map[uint8]int),
+		decodeErrors: make(map[ErrorCode]int),
+	}
+}
+
+func (m *recordingMetrics) FrameEncoded(frameType uint8, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.encoded[frameType]++
+	m.encodedBytes += size
+}
+
+func (m *recordingMetrics) EncodeFailed(code ErrorCode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.encodeErrors[code]++
+}
+
+func (m *recordingMetrics) FrameDecoded(frameType uint8, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decoded[frameType]++
+	m.decodedBytes += size
+}
+
+func (m *recordingMetrics) DecodeFailed(code ErrorCode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decodeErrors[code]++
+}
+
+func (m *recordingMetrics) BytesFed(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fed += size
+}
+
+func (m *recordingMetrics) BufferGet(size int, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gets++
+	if hit {
+		m.hits++
+	}
+}
+
+func (m *recordingMetrics) BufferPut(capacity int, recycled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.puts++
+	if recycled {
+		m.reused++
+	}
+}
+
+// useMetrics installs m for the duration of the test
+func useMetrics(t *testing.T, m Metrics) {
+	SetMetrics(m)
+	t.Cleanup(func() { SetMetrics(nil) })
+}
+
+// TestMetricsHooks tests that encoding, decoding, streaming and the buffer pool report to Metrics
+func TestMetricsHooks(t *testing.T) {
+	m := newRecordingMetrics()
+	useMetrics(t, m)
+
+	frame, _ := NewFrame(FrameTypeMsgPack, []byte{0x80})
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	var buf bytes.Buffer
+	frame.EncodeTo(&buf)
+	frame.EncodeToBytes(make([]byte, 2))
+	bad := &Frame{Version: 9, Type: FrameTypeJSON}
+	bad.Encode()
+
+	if m.encoded[FrameTypeMsgPack] != 2 || m.encodedBytes != 2*len(data) {
+		t.Fatalf("Expected 2 encoded frames of %d bytes, got %v / %d", len(data), m.encoded, m.encodedBytes)
+	}
+	if m.encodeErrors[ErrCodeBufferTooSmall] != 1 || m.encodeErrors[ErrCodeUnsupportedVersion] != 1 {
+		t.Fatalf("Unexpected encode errors: %v", m.encodeErrors)
+	}
+	if m.gets == 0 || m.puts == 0 || m.reused != m.puts {
+		t.Fatalf("Expected pooled buffers to be recycled: gets %d puts %d recycled %d", m.gets, m.puts, m.reused)
+	}
+
+	// Decode counts the frame, not trailing bytes
+	if _, err := Decode(append(data, 0xff)); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	Decode(data[:3])
+
+	// TryDecode reports each frame once and errors with their code
+	decoder := NewStreamDecoder(len(data) * 3)
+	decoder.Feed(append(append([]byte{}, data...), data...))
+	for {
+		decoded, err := decoder.TryDecode()
+		if err != nil {
+			t.Fatalf("Failed to decode stream: %v", err)
+		}
+		if decoded == nil {
+			break
+		}
+	}
+	decoder.Feed([]byte{0x07, 0, 0, 0, 0, 0, 0})
+	decoder.TryDecode()
+	decoder.Feed(make([]byte, len(data)*3))
+
+	if m.decoded[FrameTypeMsgPack] != 3 || m.decodedBytes != 3*len(data) {
+		t.Fatalf("Expected 3 decoded frames of %d bytes, got %v / %d", len(data), m.decoded, m.decodedBytes)
+	}
+	if m.decodeErrors[ErrCodeInvalidFrame] != 1 || m.decodeErrors[ErrCodeUnsupportedVersion] != 1 || m.decodeErrors[ErrCodeMessageTooLong] != 1 {
+		t.Fatalf("Unexpected decode errors: %v", m.decodeErrors)
+	}
+	if m.fed != 2*len(data)+FrameHeaderLength {
+		t.Fatalf("Expected %d bytes fed, got %d", 2*len(data)+FrameHeaderLength, m.fed)
+	}
+
+	// Oversized buffers are never pool hits and never recycled
+	gets, hits, puts, reused := m.gets, m.hits, m.puts, m.reused
+	bufferPool.Put(bufferPool.Get(largeBufferSize + 1))
+	if m.gets != gets+1 || m.hits != hits || m.puts != puts+1 || m.reused != reused {
+		t.Fatalf("Oversized buffer was counted as pooled")
+	}
+}
+
+// TestExpvarMetrics tests the expvar implementation and its published layout
+func TestExpvarMetrics(t *testing.T) {
+	m := newExpvarMetrics()
+	useMetrics(t, m)
+
+	frame, _ := NewFrame(FrameTypeJSON, []byte(`{"a":1}`))
+	for i := 0; i < 100; i++ {
+		data, _ := frame.Encode()
+		Decode(data)
+	}
+	Decode([]byte{1, 0, 0x3f, 0, 0, 0, 0})
+	m.EncodeFailed(ErrorCode(200))
+
+	var snapshot struct {
+		Encoded      map[string]int64   `json:"encoded"`
+		DecodedBytes map[string]int64   `json:"decoded_bytes"`
+		DecodedSizes map[string]int64   `json:"decoded_sizes"`
+		EncodeErrors map[string]int64   `json:"encode_errors"`
+		DecodeErrors map[string]int64   `json:"decode_errors"`
+		PoolGets     map[string]int64   `json:"pool_gets"`
+		PoolHitRate  map[string]float64 `json:"pool_hit_rate"`
+		PoolRecycled map[string]int64   `json:"pool_recycled"`
+	}
+	if err := json.Unmarshal([]byte(m.Map().String()), &snapshot); err != nil {
+		t.Fatalf("Failed to parse expvar output: %v", err)
+	}
+	size := int64(FrameHeaderLength + len(frame.Body))
+	if snapshot.Encoded["json"] != 100 || snapshot.DecodedBytes["json"] != 100*size || snapshot.DecodedSizes["le_64"] != 100 {
+		t.Fatalf("Unexpected frame counters: %+v", snapshot)
+	}
+	if snapshot.DecodeErrors["invalid_frame_type"] != 1 || snapshot.EncodeErrors["code_200"] != 1 {
+		t.Fatalf("Unexpected error counters: %v %v", snapshot.DecodeErrors, snapshot.EncodeErrors)
+	}
+	if snapshot.PoolGets["small"] < 100 || snapshot.PoolRecycled["small"] < 100 {
+		t.Fatalf("Unexpected pool counters: %+v", snapshot)
+	}
+	if rate := snapshot.PoolHitRate["small"]; rate <= 0 || rate > 1 {
+		t.Fatalf("Unexpected small pool hit rate %v", rate)
+	}
+}
+
+// TestNewExpvarMetricsPublish tests publishing under a name and rejecting a name already in use
+func TestNewExpvarMetricsPublish(t *testing.T) {
+	name := fmt.Sprintf("improtocol_test_%d", publishedMetricsCount.Add(1))
+	m, err := NewExpvarMetrics(name)
+	if err != nil {
+		t.Fatalf("Failed to create expvar metrics: %v", err)
+	}
+	if expvar.Get(name) != m.Map() {
+		t.Fatalf("Metrics were not published")
+	}
+	if _, err := NewExpvarMetrics(name); err == nil {
+		t.Fatalf("Expected an error when publishing %q twice", name)
+	}
+}
+
+// publishedMetricsCount keeps expvar names unique across repeated test runs in one process
+var publishedMetricsCount atomic.Int64
+
+// TestFrameSizeBucket tests the frame size histogram boundaries
+func TestFrameSizeBucket(t *testing.T) {
+	for size, want := range map[int]string{0: "le_64", 64: "le_64", 65: "le_256", 1 << 20: "le_1048576", 1<<20 + 7: "inf"} {
+		if got := frameSizeBucket(size); got != want {
+			t.Errorf("Size %d: expected bucket %s, got %s", size, want, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// PanicError 处理器发生panic时由Recovery返回的错误
+// Error()只返回通用描述，panic的值和堆栈不会通过错误帧泄露给对端
+type PanicError struct {
+	// Value recover()得到的值
+	Value any
+	// Stack panic发生时的堆栈
+	Stack []byte
+}
+
+// Error 实现error接口
+func (e *PanicError) Error() string {
+	return "internal error: handler panic"
+}
+
+// Recovery 捕获处理器中的panic，转换为*PanicError返回，避免单个帧导致整个服务崩溃
+// 通常紧挨在Logging之内使用，使Logging能记录panic的值和堆栈：
+//
+//	srv.Use(Logging(logger), Recovery())
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next.ServeFrame(ctx, w, f)
+		})
+	}
+}
+
+// Logging 使用log/slog记录每个帧的处理结果
+// 成功以Debug级别记录，失败以Warn级别记录，panic以Error级别记录并附带堆栈
+//
+// 记录的属性：
+//   - frame：Frame.String()
+//   - remote：对端地址（连接在context中时）
+//   - principal：认证主体ID（已认证时）
+//   - duration：处理耗时
+//   - error：处理器返回的错误
+func Logging(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+			start := time.Now()
+			err := next.ServeFrame(ctx, w, f)
+
+			attrs := make([]slog.Attr, 0, 6)
+			attrs = append(attrs, slog.String("frame", f.String()))
+			if conn := ConnFromContext(ctx); conn != nil {
+				attrs = append(attrs, slog.String("remote", conn.RemoteAddr().String()))
+			}
+			if p := PrincipalFromContext(ctx); p != nil {
+				attrs = append(attrs, slog.String("principal", p.ID))
+			}
+			attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+
+			level := slog.LevelDebug
+			msg := "frame handled"
+			if err != nil {
+				level = slog.LevelWarn
+				msg = "frame handler failed"
+				attrs = append(attrs, slog.Any("error", err))
+				var panicErr *PanicError
+				if errors.As(err, &panicErr) {
+					level = slog.LevelError
+					msg = "frame handler panicked"
+					attrs = append(attrs, slog.String("panic", fmt.Sprint(panicErr.Value)), slog.String("stack", string(panicErr.Stack)))
+				}
+			}
+			logger.LogAttrs(ctx, level, msg, attrs...)
+			return err
+		})
+	}
+}
+
+// Timing 在每个帧处理完成后调用observe上报耗时，可用于对接指标系统
+//
+// 使用示例：
+//
+//	srv.Use(Timing(func(f *Frame, d time.Duration, err error) {
+//		histogram.WithLabelValues(strconv.Itoa(int(f.Type))).Observe(d.Seconds())
+//	}))
+func Timing(observe func(f *Frame, d time.Duration, err error)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+			start := time.Now()
+			err := next.ServeFrame(ctx, w, f)
+			observe(f, time.Since(start), err)
+			return err
+		})
+	}
+}
+
+// RequireAuth 拒绝未认证连接上的帧，返回ErrCodeUnauthenticated错误
+// authorize 可选，用于进一步检查主体是否有权处理该帧（如按角色），返回的错误原样返回；为nil时只要求已认证
+func RequireAuth(authorize func(p *Principal, f *Frame) error) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+			p := PrincipalFromContext(ctx)
+			if p == nil {
+				if conn := ConnFromContext(ctx); conn != nil {
+					p = conn.Principal()
+				}
+			}
+			if p == nil {
+				return NewUnauthenticatedError("frame received on unauthenticated connection")
+			}
+			if authorize != nil {
+				if err := authorize(p, f); err != nil {
+					return err
+				}
+			}
+			return next.ServeFrame(ctx, w, f)
+		})
+	}
+}
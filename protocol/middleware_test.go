@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingWriter is a FrameWriter that records written frames
+type recordingWriter struct {
+	frames []*Frame
+}
+
+func (w *recordingWriter) WriteFrame(f *Frame) error {
+	w.frames = append(w.frames, f)
+	return nil
+}
+
+// TestRecoveryAndLogging tests that panics are converted to errors and logged with their stack
+func TestRecoveryAndLogging(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	handler := Chain(Logging(logger), Recovery())(HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+		if string(f.Body) == "boom" {
+			panic("kaboom")
+		}
+		return nil
+	}))
+
+	ok, _ := NewFrame(FrameTypeJSON, []byte("fine"))
+	if err := handler.ServeFrame(context.Background(), &recordingWriter{}, ok); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	boom, _ := NewFrame(FrameTypeJSON, []byte("boom"))
+	err := handler.ServeFrame(context.Background(), &recordingWriter{}, boom)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "kaboom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("Expected PanicError with value and stack, got %v", err)
+	}
+	if strings.Contains(err.Error(), "kaboom") {
+		t.Error("Panic value must not leak through Error()")
+	}
+
+	output := logs.String()
+	for _, want := range []string{"level=DEBUG msg=\"frame handled\"", "level=ERROR msg=\"frame handler panicked\"", "panic=kaboom", `Body:\"boom\"`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected log output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+// TestTiming tests that handler durations are reported
+func TestTiming(t *testing.T) {
+	var observed time.Duration
+	var observedErr error
+	handlerErr := errors.New("failed")
+	handler := Timing(func(f *Frame, d time.Duration, err error) {
+		observed, observedErr = d, err
+	})(HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+		time.Sleep(10 * time.Millisecond)
+		return handlerErr
+	}))
+
+	frame, _ := NewFrame(FrameTypeJSON, []byte("{}"))
+	handler.ServeFrame(context.Background(), &recordingWriter{}, frame)
+	if observed < 10*time.Millisecond || observedErr != handlerErr {
+		t.Errorf("Unexpected observation: %v, %v", observed, observedErr)
+	}
+}
+
+// TestRequireAuth tests rejecting unauthenticated and unauthorized frames
+func TestRequireAuth(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	handler := RequireAuth(func(p *Principal, f *Frame) error {
+		if p.Attributes["role"] != "admin" && f.Type == FrameTypeProtobuf {
+			return errForbidden
+		}
+		return nil
+	})(HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+		return w.WriteFrame(f)
+	}))
+
+	jsonFrame, _ := NewFrame(FrameTypeJSON, []byte("{}"))
+	protoFrame, _ := NewFrame(FrameTypeProtobuf, []byte{1})
+	w := &recordingWriter{}
+
+	if err := handler.ServeFrame(context.Background(), w, jsonFrame); !IsUnauthenticatedError(err) {
+		t.Errorf("Expected unauthenticated error, got %v", err)
+	}
+
+	user := ContextWithPrincipal(context.Background(), &Principal{ID: "alice"})
+	if err := handler.ServeFrame(user, w, jsonFrame); err != nil {
+		t.Errorf("Unexpected error for authenticated user: %v", err)
+	}
+	if err := handler.ServeFrame(user, w, protoFrame); err != errForbidden {
+		t.Errorf("Expected forbidden error, got %v", err)
+	}
+
+	admin := ContextWithPrincipal(context.Background(), &Principal{ID: "root", Attributes: map[string]string{"role": "admin"}})
+	if err := handler.ServeFrame(admin, w, protoFrame); err != nil {
+		t.Errorf("Unexpected error for admin: %v", err)
+	}
+	if len(w.frames) != 2 {
+		t.Errorf("Expected 2 frames to reach the handler, got %d", len(w.frames))
+	}
+}
@@ -0,0 +1,387 @@
+package protocol
+
+import (
+	"io"
+	mrand "math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// NetSimConfig 网络模拟配置，零值表示不做任何干扰的内存管道
+// 同一配置作用于两个方向，每个方向使用由Seed派生的独立随机数序列
+type NetSimConfig struct {
+	// Seed 随机数种子，相同的种子和相同的写入序列产生相同的分段、丢弃和损坏
+	Seed uint64
+	// Datagram 数据报模式：每次Write是一个独立的包，Read每次返回一个包，
+	// 包大于读缓冲区时多余部分被丢弃；不进行分片和合并，但可以乱序
+	Datagram bool
+	// MinFragment 流模式下分片的最小字节数，默认1
+	MinFragment int
+	// MaxFragment 流模式下分片的最大字节数，每次Write被切成随机大小的分片分别投递，0表示不分片
+	MaxFragment int
+	// CoalesceRate 流模式下一次Write的最后一个分片被暂存、与下一次Write的数据合并投递的概率
+	CoalesceRate float64
+	// ReorderRate 数据报模式下一个包被暂存、排到下一个包之后投递的概率
+	ReorderRate float64
+	// DropRate 一个分片或包被丢弃的概率
+	DropRate float64
+	// CorruptRate 一个分片或包中随机翻转一位的概率
+	CorruptRate float64
+	// Delay 每个分片或包的固定投递延迟
+	Delay time.Duration
+	// Jitter 在Delay之上附加的[0, Jitter)随机延迟；投递顺序不受影响
+	Jitter time.Duration
+}
+
+// NetSimStats 单个方向的网络模拟统计
+type NetSimStats struct {
+	// Writes Write调用次数
+	Writes uint64
+	// Bytes 写入的字节数
+	Bytes uint64
+	// Segments 进入投递队列的分片或包数
+	Segments uint64
+	// Coalesced 被暂存合并的分片数
+	Coalesced uint64
+	// Reordered 被暂存乱序的包数
+	Reordered uint64
+	// Dropped 被丢弃的分片或包数
+	Dropped uint64
+	// Corrupted 被翻转一位的分片或包数
+	Corrupted uint64
+}
+
+// netSimSegment 投递队列中的一个分片或包
+type netSimSegment struct {
+	// data 数据
+	data []byte
+	// at 最早可被读取的时间
+	at time.Time
+}
+
+// netSimPipe 单个方向的模拟链路
+type netSimPipe struct {
+	// mu 互斥锁，保护以下所有字段
+	mu sync.Mutex
+	// config 模拟配置
+	config NetSimConfig
+	// rng 本方向的随机数生成器
+	rng *mrand.Rand
+	// queue 待读取的分片或包，按投递顺序排列
+	queue []netSimSegment
+	// held 被暂存等待合并或乱序的数据，nil表示没有
+	held []byte
+	// last 最后入队分片的投递时间，保证投递顺序
+	last time.Time
+	// notify 队列或状态变化时关闭并替换，唤醒等待中的Read
+	notify chan struct{}
+	// writeClosed 写端已关闭，读完队列后返回io.EOF
+	writeClosed bool
+	// readClosed 读端已关闭
+	readClosed bool
+	// readDeadline 读截止时间，零值表示不限制
+	readDeadline time.Time
+	// writeDeadline 写截止时间，零值表示不限制
+	writeDeadline time.Time
+	// stats 统计
+	stats NetSimStats
+}
+
+func newNetSimPipe(config NetSimConfig, stream uint64) *netSimPipe {
+	return &netSimPipe{
+		config: config,
+		rng:    mrand.New(mrand.NewPCG(config.Seed, stream)),
+		notify: make(chan struct{}),
+	}
+}
+
+// wake 唤醒等待中的Read，调用方需持有锁
+func (p *netSimPipe) wake() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// chance 按概率返回true，概率不大于0时不消耗随机数
+func (p *netSimPipe) chance(rate float64) bool {
+	return rate > 0 && p.rng.Float64() < rate
+}
+
+// write 按配置把一次写入的数据切分、暂存后放入投递队列
+func (p *netSimPipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writeClosed {
+		return 0, net.ErrClosed
+	}
+	if !p.writeDeadline.IsZero() && !time.Now().Before(p.writeDeadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	p.stats.Writes++
+	p.stats.Bytes += uint64(len(b))
+	data := append([]byte(nil), b...)
+
+	if p.config.Datagram {
+		switch {
+		case p.held != nil:
+			held := p.held
+			p.held = nil
+			p.enqueue(data)
+			p.enqueue(held)
+		case p.chance(p.config.ReorderRate):
+			p.held = data
+			p.stats.Reordered++
+		default:
+			p.enqueue(data)
+		}
+		return len(b), nil
+	}
+
+	if p.held != nil {
+		data = append(p.held, data...)
+		p.held = nil
+	}
+	for len(data) > 0 {
+		n := p.fragmentSize(len(data))
+		if n == len(data) && p.chance(p.config.CoalesceRate) {
+			p.held = data
+			p.stats.Coalesced++
+			break
+		}
+		p.enqueue(data[:n:n])
+		data = data[n:]
+	}
+	return len(b), nil
+}
+
+// fragmentSize 返回下一个分片的大小
+func (p *netSimPipe) fragmentSize(remaining int) int {
+	if p.config.MaxFragment <= 0 {
+		return remaining
+	}
+	lo := max(p.config.MinFragment, 1)
+	hi := max(p.config.MaxFragment, lo)
+	return min(lo+p.rng.IntN(hi-lo+1), remaining)
+}
+
+// enqueue 对一个分片或包应用丢弃、损坏和延迟后放入队列，调用方需持有锁
+func (p *netSimPipe) enqueue(data []byte) {
+	if p.chance(p.config.DropRate) {
+		p.stats.Dropped++
+		return
+	}
+	if len(data) > 0 && p.chance(p.config.CorruptRate) {
+		data[p.rng.IntN(len(data))] ^= 1 << p.rng.IntN(8)
+		p.stats.Corrupted++
+	}
+	delay := p.config.Delay
+	if p.config.Jitter > 0 {
+		delay += time.Duration(p.rng.Int64N(int64(p.config.Jitter)))
+	}
+	at := time.Now().Add(delay)
+	if at.Before(p.last) {
+		at = p.last
+	}
+	p.last = at
+	p.queue = append(p.queue, netSimSegment{data: data, at: at})
+	p.stats.Segments++
+	p.wake()
+}
+
+// flush 投递暂存的数据
+func (p *netSimPipe) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.flushLocked()
+}
+
+func (p *netSimPipe) flushLocked() {
+	if p.held != nil {
+		held := p.held
+		p.held = nil
+		p.enqueue(held)
+	}
+}
+
+// read 读取队首的分片或包，未到投递时间时等待
+func (p *netSimPipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	for {
+		if p.readClosed {
+			p.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		now := time.Now()
+		if !p.readDeadline.IsZero() && !now.Before(p.readDeadline) {
+			p.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		var wait time.Duration = -1
+		if len(p.queue) > 0 {
+			seg := &p.queue[0]
+			if !now.Before(seg.at) {
+				n := copy(b, seg.data)
+				if n < len(seg.data) && !p.config.Datagram {
+					seg.data = seg.data[n:]
+				} else {
+					p.queue = p.queue[1:]
+				}
+				p.mu.Unlock()
+				return n, nil
+			}
+			wait = seg.at.Sub(now)
+		} else if p.writeClosed {
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		if !p.readDeadline.IsZero() && (wait < 0 || p.readDeadline.Sub(now) < wait) {
+			wait = p.readDeadline.Sub(now)
+		}
+
+		notify := p.notify
+		p.mu.Unlock()
+		if wait < 0 {
+			<-notify
+		} else {
+			timer := time.NewTimer(wait)
+			select {
+			case <-notify:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		p.mu.Lock()
+	}
+}
+
+// NetSimConn 网络模拟连接，实现net.Conn
+// 在内存中模拟拆包、粘包、延迟、乱序、丢包和数据损坏，用于确定性地测试StreamDecoder及上层组件
+//
+// 使用示例：
+//
+//	client, server := NewNetSimPipe(NetSimConfig{Seed: 1, MaxFragment: 5, CoalesceRate: 0.5})
+//	go NewConn(server).WriteFrame(frame)
+//	reply, err := NewConn(client).ReadFrame() // 帧被拆成最多5字节的分片到达
+//
+// 确定性说明：
+// 对同一方向，相同的Seed和相同的Write序列总是产生相同的分片边界、丢弃和损坏；
+// Delay和Jitter只影响数据何时可读，不影响顺序。暂存的数据在下一次Write、Flush或Close时投递
+//
+// 并发安全说明：
+// NetSimConn 的所有方法均为并发安全；同一连接被多个协程同时写入时，写入顺序本身是不确定的
+type NetSimConn struct {
+	// in 读取方向
+	in *netSimPipe
+	// out 写入方向
+	out *netSimPipe
+	// local 本端地址
+	local net.Addr
+	// remote 对端地址
+	remote net.Addr
+	// closeOnce 保证只关闭一次
+	closeOnce sync.Once
+}
+
+// netSimAddr 网络模拟连接的地址
+type netSimAddr struct {
+	// network 网络类型
+	network string
+	// name 端点名称
+	name string
+}
+
+func (a netSimAddr) Network() string { return a.network }
+func (a netSimAddr) String() string  { return a.name }
+
+// NewNetSimPipe 创建一对相连的网络模拟连接
+// 两个方向各自按config模拟，a写入的数据从b读出，反之亦然
+func NewNetSimPipe(config NetSimConfig) (a, b *NetSimConn) {
+	network := "netsim"
+	if config.Datagram {
+		network = "netsim-datagram"
+	}
+	addrA := netSimAddr{network: network, name: "a"}
+	addrB := netSimAddr{network: network, name: "b"}
+	ab := newNetSimPipe(config, 1)
+	ba := newNetSimPipe(config, 2)
+	a = &NetSimConn{in: ba, out: ab, local: addrA, remote: addrB}
+	b = &NetSimConn{in: ab, out: ba, local: addrB, remote: addrA}
+	return a, b
+}
+
+// Read 读取数据
+// 流模式下每次最多返回一个分片，从而暴露分片边界；数据报模式下每次返回一个包
+func (c *NetSimConn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+// Write 写入数据，不会阻塞
+func (c *NetSimConn) Write(b []byte) (int, error) {
+	return c.out.write(b)
+}
+
+// Flush 立即投递因合并或乱序而暂存的数据
+func (c *NetSimConn) Flush() {
+	c.out.flush()
+}
+
+// Stats 返回写入方向的统计
+func (c *NetSimConn) Stats() NetSimStats {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	return c.out.stats
+}
+
+// Close 关闭连接
+// 暂存的数据会先被投递，对端读完已投递的数据后得到io.EOF；本端的读写返回net.ErrClosed
+func (c *NetSimConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.out.mu.Lock()
+		c.out.flushLocked()
+		c.out.writeClosed = true
+		c.out.wake()
+		c.out.mu.Unlock()
+
+		c.in.mu.Lock()
+		c.in.readClosed = true
+		c.in.wake()
+		c.in.mu.Unlock()
+	})
+	return nil
+}
+
+// LocalAddr 返回本端地址
+func (c *NetSimConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr 返回对端地址
+func (c *NetSimConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline 同时设置读写截止时间
+func (c *NetSimConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读截止时间，等待中的Read会按新的截止时间重新计时
+func (c *NetSimConn) SetReadDeadline(t time.Time) error {
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+	c.in.readDeadline = t
+	c.in.wake()
+	return nil
+}
+
+// SetWriteDeadline 设置写截止时间
+// Write从不阻塞，截止时间已过时直接返回os.ErrDeadlineExceeded
+func (c *NetSimConn) SetWriteDeadline(t time.Time) error {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.out.writeDeadline = t
+	return nil
+}
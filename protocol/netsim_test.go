@@ -0,0 +1,220 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// netSimFrames encodes count small frames
+func netSimFrames(t *testing.T, count int) [][]byte {
+	t.Helper()
+	frames := make([][]byte, count)
+	for i := range frames {
+		frame, err := NewFrame(FrameTypeJSON, []byte(fmt.Sprintf(`{"n":%d}`, i)))
+		if err != nil {
+			t.Fatalf("Failed to create frame: %v", err)
+		}
+		if frames[i], err = frame.Encode(); err != nil {
+			t.Fatalf("Failed to encode frame: %v", err)
+		}
+	}
+	return frames
+}
+
+// readSegments writes every chunk from a, closes it and returns the sizes of the reads b observed along with the bytes
+func readSegments(t *testing.T, config NetSimConfig, chunks [][]byte) ([]int, []byte) {
+	t.Helper()
+	a, b := NewNetSimPipe(config)
+	defer b.Close()
+	for _, chunk := range chunks {
+		if _, err := a.Write(chunk); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	a.Close()
+
+	var sizes []int
+	var data []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := b.Read(buf)
+		if err == io.EOF {
+			return sizes, data
+		}
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		sizes = append(sizes, n)
+		data = append(data, buf[:n]...)
+	}
+}
+
+// TestNetSimFragmentAndCoalesce tests that fragmentation and coalescing change read boundaries but not the stream
+func TestNetSimFragmentAndCoalesce(t *testing.T) {
+	frames := netSimFrames(t, 20)
+	config := NetSimConfig{Seed: 7, MinFragment: 1, MaxFragment: 6, CoalesceRate: 0.5}
+	sizes, data := readSegments(t, config, frames)
+	if !bytes.Equal(data, bytes.Join(frames, nil)) {
+		t.Fatalf("Stream was altered")
+	}
+	if len(sizes) <= len(frames) || slices.Max(sizes) > 6 {
+		t.Fatalf("Expected reads of at most 6 bytes, got %v", sizes)
+	}
+
+	// Without fragmentation, coalescing merges whole writes
+	sizes, data = readSegments(t, NetSimConfig{Seed: 7, CoalesceRate: 0.9}, frames)
+	if !bytes.Equal(data, bytes.Join(frames, nil)) || len(sizes) >= len(frames) {
+		t.Fatalf("Expected fewer reads than writes, got %d reads", len(sizes))
+	}
+
+	// The same seed yields the same boundaries
+	again, _ := readSegments(t, config, frames)
+	first, _ := readSegments(t, config, frames)
+	if !slices.Equal(first, again) {
+		t.Fatalf("Same seed gave different boundaries:\n%v\n%v", first, again)
+	}
+	config.Seed++
+	other, _ := readSegments(t, config, frames)
+	if slices.Equal(first, other) {
+		t.Fatalf("Different seeds gave the same boundaries")
+	}
+}
+
+// TestNetSimStreamDecoder tests that frames survive fragmentation and coalescing through Conn and StreamDecoder
+func TestNetSimStreamDecoder(t *testing.T) {
+	frames := netSimFrames(t, 50)
+	for seed := uint64(0); seed < 20; seed++ {
+		a, b := NewNetSimPipe(NetSimConfig{Seed: seed, MaxFragment: 9, CoalesceRate: 0.3})
+		go func() {
+			for _, data := range frames {
+				a.Write(data)
+			}
+			a.Close()
+		}()
+		conn := NewConn(b)
+		for i, want := range frames {
+			frame, err := conn.ReadFrame()
+			if err != nil {
+				t.Fatalf("Seed %d: failed to read frame %d: %v", seed, i, err)
+			}
+			if !bytes.Equal(frame.Body, want[FrameHeaderLength:]) {
+				t.Fatalf("Seed %d: frame %d body %q", seed, i, frame.Body)
+			}
+		}
+		conn.Close()
+	}
+}
+
+// TestNetSimDatagramReorder tests that datagram mode keeps packet boundaries and reorders deterministically
+func TestNetSimDatagramReorder(t *testing.T) {
+	var packets [][]byte
+	for i := 0; i < 30; i++ {
+		packets = append(packets, []byte{byte(i), byte(i)})
+	}
+	config := NetSimConfig{Seed: 3, Datagram: true, ReorderRate: 0.3, MaxFragment: 1}
+	sizes, data := readSegments(t, config, packets)
+	if len(sizes) != len(packets) || slices.ContainsFunc(sizes, func(n int) bool { return n != 2 }) {
+		t.Fatalf("Expected %d two-byte packets, got %v", len(packets), sizes)
+	}
+	var order, want []byte
+	for i := 0; i < len(data); i += 2 {
+		order = append(order, data[i])
+		want = append(want, byte(i/2))
+	}
+	sorted := slices.Sorted(slices.Values(order))
+	if slices.Equal(order, sorted) || !slices.Equal(sorted, want) {
+		t.Fatalf("Expected a permutation of all packets, got %v", order)
+	}
+	_, again := readSegments(t, config, packets)
+	if !bytes.Equal(data, again) {
+		t.Fatalf("Same seed gave a different order")
+	}
+
+	// A packet larger than the read buffer is truncated
+	a, b := NewNetSimPipe(NetSimConfig{Datagram: true})
+	a.Write([]byte("hello"))
+	a.Write([]byte("world"))
+	buf := make([]byte, 3)
+	if n, _ := b.Read(buf); string(buf[:n]) != "hel" {
+		t.Fatalf("Expected truncated packet, got %q", buf[:n])
+	}
+	if n, _ := b.Read(buf); string(buf[:n]) != "wor" {
+		t.Fatalf("Expected next packet, got %q", buf[:n])
+	}
+}
+
+// TestNetSimDropAndCorrupt tests dropping and corrupting segments and their statistics
+func TestNetSimDropAndCorrupt(t *testing.T) {
+	// Identical packets, so that a corrupted packet can never look like another intact one
+	packet := netSimFrames(t, 1)[0]
+	a, b := NewNetSimPipe(NetSimConfig{Seed: 11, Datagram: true, DropRate: 0.2, CorruptRate: 0.2})
+	for i := 0; i < 100; i++ {
+		a.Write(packet)
+	}
+	a.Close()
+
+	stats := a.Stats()
+	if stats.Writes != 100 || stats.Dropped == 0 || stats.Corrupted == 0 || stats.Segments != 100-stats.Dropped {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	buf := make([]byte, 64)
+	var intact, damaged uint64
+	for {
+		n, err := b.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if bytes.Equal(packet, buf[:n]) {
+			intact++
+		} else {
+			damaged++
+		}
+	}
+	if intact+damaged != stats.Segments || damaged != stats.Corrupted {
+		t.Fatalf("Read %d intact and %d damaged packets, stats %+v", intact, damaged, stats)
+	}
+}
+
+// TestNetSimDelayAndDeadline tests delayed delivery, read deadlines and close semantics
+func TestNetSimDelayAndDeadline(t *testing.T) {
+	a, b := NewNetSimPipe(NetSimConfig{Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	start := time.Now()
+	a.Write([]byte("late"))
+
+	b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	buf := make([]byte, 16)
+	if _, err := b.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	b.SetReadDeadline(time.Time{})
+	n, err := b.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Fatalf("Failed to read delayed data: %q %v", buf[:n], err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Data arrived after %v, before the delay", elapsed)
+	}
+
+	// Data written before Close is still delivered, then EOF
+	a.Write([]byte("bye"))
+	a.Close()
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "bye" {
+		t.Fatalf("Failed to read data before EOF: %q %v", buf[:n], err)
+	}
+	if _, err := b.Read(buf); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if _, err := a.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Expected write on closed conn to fail, got %v", err)
+	}
+	if _, err := a.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Expected read on closed conn to fail, got %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package protocol
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PresenceDeviceAttribute Principal.Attributes中表示设备ID的属性名，见PresenceConfig.Device
+const PresenceDeviceAttribute = "device"
+
+// PresenceConfig 在线状态注册表配置，零值字段使用默认值
+type PresenceConfig struct {
+	// FrameType 在线状态更新帧的帧类型，默认FrameTypeJSON，需已注册BodyCodec
+	FrameType uint8
+	// Delivery 向关注者投递更新的队列配置，慢关注者会被逐出并断开，见Broker
+	Delivery BrokerConfig
+	// Device 返回连接对应的设备ID
+	// 默认取Principal.Attributes[PresenceDeviceAttribute]，不存在时使用对端地址
+	Device func(c *Conn) string
+	// CanWatch 可选，检查主体是否允许关注用户的在线状态（如只允许关注联系人）
+	// 返回的错误原样回复给对端
+	CanWatch func(p *Principal, user string) error
+	// OnChange 可选，用户的聚合在线状态变化后的回调，在注册表锁内调用，不能再调用注册表的方法
+	OnChange func(p *Presence)
+}
+
+// devicePresence 一个设备连接的在线状态
+type devicePresence struct {
+	// device 设备ID
+	device string
+	// status 设备上报的状态
+	status PresenceStatus
+	// text 设备上报的状态文本
+	text string
+	// updated 最近一次状态变化的时间
+	updated time.Time
+}
+
+// userPresence 一个用户所有设备的在线状态
+type userPresence struct {
+	// devices 连接到设备状态的映射
+	devices map[*Conn]*devicePresence
+	// status 最近一次通知的聚合状态
+	status PresenceStatus
+	// text 最近一次通知的聚合状态文本
+	text string
+	// lastSeen 最后活跃时间（Unix毫秒）
+	lastSeen int64
+}
+
+// PresenceRegistry 内存中的在线状态注册表
+//
+// 状态来源：
+//   - 连接生命周期：Connect登记设备在线，Disconnect移除设备（通常接入ServerConfig.OnConnect/OnClose）
+//   - Presence帧：客户端上报自己设备的状态（如away）和状态文本，User和Device字段以连接为准
+//
+// 同一用户的多个设备按online > away > offline聚合，状态文本取决定聚合状态的设备中最近更新的一个。
+// 聚合状态变化时向关注该用户的连接发送Device为空的Presence帧；
+// 开始关注时立即发送一次当前状态，之后只发送变化。
+//
+// 使用示例：
+//
+//	presence := NewPresenceRegistry(PresenceConfig{CanWatch: isContact})
+//	srv := NewServer(ServerConfig{
+//		Authenticators: authenticators,
+//		OnConnect:      presence.Connect,
+//		OnClose:        presence.Disconnect,
+//	})
+//	srv.Use(presence.Middleware())
+//
+// 并发安全说明：
+// 所有方法都可以并发调用
+type PresenceRegistry struct {
+	config PresenceConfig
+	// broker 以用户ID为主题向关注者投递更新
+	broker *Broker
+
+	mu sync.Mutex
+	// users 用户ID到在线状态的映射，用户最后一个设备断开后移除
+	users map[string]*userPresence
+	// conns 已登记的连接到用户ID的映射
+	conns map[*Conn]string
+
+	// now 返回当前时间，测试时可替换
+	now func() time.Time
+}
+
+// NewPresenceRegistry 创建在线状态注册表
+func NewPresenceRegistry(config PresenceConfig) *PresenceRegistry {
+	if config.FrameType == 0 {
+		config.FrameType = FrameTypeJSON
+	}
+	if config.Device == nil {
+		config.Device = defaultPresenceDevice
+	}
+	return &PresenceRegistry{
+		config: config,
+		broker: NewBroker(config.Delivery),
+		users:  make(map[string]*userPresence),
+		conns:  make(map[*Conn]string),
+		now:    time.Now,
+	}
+}
+
+// defaultPresenceDevice 从主体属性中取设备ID，不存在时使用对端地址
+func defaultPresenceDevice(c *Conn) string {
+	if p := c.Principal(); p != nil {
+		if device := p.Attributes[PresenceDeviceAttribute]; device != "" {
+			return device
+		}
+	}
+	return c.RemoteAddr().String()
+}
+
+// Connect 将已认证的连接登记为其用户的一个在线设备
+// 连接未认证时返回ErrCodeUnauthenticated错误
+func (r *PresenceRegistry) Connect(c *Conn) error {
+	p := c.Principal()
+	if p == nil {
+		return NewUnauthenticatedError("presence requires an authenticated connection")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.conns[c]; ok {
+		return nil
+	}
+	r.conns[c] = p.ID
+	u, ok := r.users[p.ID]
+	if !ok {
+		u = &userPresence{devices: make(map[*Conn]*devicePresence), status: PresenceOffline}
+		r.users[p.ID] = u
+	}
+	u.devices[c] = &devicePresence{device: r.config.Device(c), status: PresenceOnline, updated: r.now()}
+	r.refresh(p.ID, u)
+	return nil
+}
+
+// Disconnect 移除连接对应的设备及其关注，用户最后一个设备断开时变为离线
+// 离线通知携带最后活跃时间，之后注册表不再保留该用户
+func (r *PresenceRegistry) Disconnect(c *Conn) {
+	r.broker.Remove(c)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.conns[c]
+	if !ok {
+		return
+	}
+	delete(r.conns, c)
+	u := r.users[user]
+	delete(u.devices, c)
+	if len(u.devices) == 0 {
+		u.lastSeen = r.now().UnixMilli()
+	}
+	r.refresh(user, u)
+	if len(u.devices) == 0 {
+		delete(r.users, user)
+	}
+}
+
+// Update 更新连接对应设备的状态和状态文本
+// 连接未通过Connect登记时返回ErrCodeUnauthenticated错误
+func (r *PresenceRegistry) Update(c *Conn, status PresenceStatus, text string) error {
+	switch status {
+	case PresenceOnline, PresenceAway, PresenceOffline:
+	default:
+		return NewInvalidMessageError("unknown presence status " + string(status))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.conns[c]
+	if !ok {
+		return NewUnauthenticatedError("presence update on unregistered connection")
+	}
+	u := r.users[user]
+	d := u.devices[c]
+	d.status, d.text, d.updated = status, text, r.now()
+	r.refresh(user, u)
+	return nil
+}
+
+// Get 返回用户的聚合在线状态，未知或已离线的用户返回离线状态，不含最后活跃时间
+func (r *PresenceRegistry) Get(user string) *Presence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot(user)
+}
+
+// Devices 返回用户各在线设备的状态，按设备ID排序
+func (r *PresenceRegistry) Devices(user string) []*Presence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[user]
+	if !ok {
+		return nil
+	}
+	devices := make([]*Presence, 0, len(u.devices))
+	for _, d := range u.devices {
+		devices = append(devices, &Presence{
+			User:     user,
+			Device:   d.device,
+			Status:   d.status,
+			Text:     d.text,
+			LastSeen: d.updated.UnixMilli(),
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device < devices[j].Device })
+	return devices
+}
+
+// Watch 让连接关注用户的在线状态，并立即向连接发送这些用户的当前状态
+// 不执行PresenceConfig.CanWatch检查，由调用方负责
+func (r *PresenceRegistry) Watch(c *Conn, users ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.broker.Subscribe(c, users...); err != nil {
+		return err
+	}
+	// 在锁内入队，保证当前状态先于之后的变化到达
+	for _, user := range users {
+		ef, err := r.encode(r.snapshot(user))
+		if err != nil {
+			return err
+		}
+		r.broker.SendTo(c, ef)
+		ef.Release()
+	}
+	return nil
+}
+
+// Unwatch 取消连接对用户在线状态的关注
+func (r *PresenceRegistry) Unwatch(c *Conn, users ...string) {
+	r.broker.Unsubscribe(c, users...)
+}
+
+// Middleware 返回处理Presence、Watch、Unwatch消息的中间件，其余帧交给后续处理器
+// 消息带有ID时回复Ack；关注被CanWatch拒绝时返回其错误，由Server回复错误帧
+func (r *PresenceRegistry) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+			op, ok := envelopeOpcode(f)
+			if !ok || (op != OpPresence && op != OpWatch && op != OpUnwatch) {
+				return next.ServeFrame(ctx, w, f)
+			}
+
+			conn := ConnFromContext(ctx)
+			if conn == nil {
+				return NewInvalidMessageError("presence message outside of a connection")
+			}
+			msg, err := ParseMessage(f)
+			if err != nil {
+				return err
+			}
+
+			switch m := msg.(type) {
+			case *Presence:
+				err = r.Update(conn, m.Status, m.Text)
+			case *Watch:
+				if err = r.canWatch(ctx, conn, m.Users); err == nil {
+					err = r.Watch(conn, m.Users...)
+				}
+			case *Unwatch:
+				r.Unwatch(conn, m.Users...)
+			}
+			if err != nil {
+				return err
+			}
+
+			id := msg.header().ID
+			if id == "" {
+				return nil
+			}
+			ack, err := EncodeMessage(f.Type, &Ack{MessageID: id})
+			if err != nil {
+				return err
+			}
+			return w.WriteFrame(ack)
+		})
+	}
+}
+
+// canWatch 检查连接的主体是否允许关注所有用户
+func (r *PresenceRegistry) canWatch(ctx context.Context, conn *Conn, users []string) error {
+	if r.config.CanWatch == nil {
+		return nil
+	}
+	p := PrincipalFromContext(ctx)
+	if p == nil {
+		p = conn.Principal()
+	}
+	for _, user := range users {
+		if err := r.config.CanWatch(p, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refresh 重新聚合用户的状态，变化时通知关注者，调用方需持有锁
+func (r *PresenceRegistry) refresh(user string, u *userPresence) {
+	status, text := PresenceOffline, ""
+	var latest time.Time
+	for _, d := range u.devices {
+		if presenceRank(d.status) > presenceRank(status) ||
+			(d.status == status && d.updated.After(latest)) {
+			status, text, latest = d.status, d.text, d.updated
+		}
+	}
+	if status == u.status && text == u.text {
+		return
+	}
+	u.status, u.text = status, text
+
+	p := r.snapshot(user)
+	if r.config.OnChange != nil {
+		r.config.OnChange(p)
+	}
+	ef, err := r.encode(p)
+	if err != nil {
+		return
+	}
+	r.broker.PublishEncoded(user, ef)
+	ef.Release()
+}
+
+// snapshot 返回用户当前的聚合状态，调用方需持有锁
+func (r *PresenceRegistry) snapshot(user string) *Presence {
+	p := &Presence{User: user, Status: PresenceOffline}
+	if u, ok := r.users[user]; ok {
+		p.Status, p.Text, p.LastSeen = u.status, u.text, u.lastSeen
+	}
+	return p
+}
+
+// encode 将在线状态编码为可共享的帧
+func (r *PresenceRegistry) encode(p *Presence) (*EncodedFrame, error) {
+	p.Timestamp = r.now().UnixMilli()
+	f, err := EncodeMessage(r.config.FrameType, p)
+	if err != nil {
+		return nil, err
+	}
+	return f.EncodeShared()
+}
+
+// presenceRank 聚合时状态的优先级
+func presenceRank(status PresenceStatus) int {
+	switch status {
+	case PresenceOnline:
+		return 2
+	case PresenceAway:
+		return 1
+	default:
+		return 0
+	}
+}
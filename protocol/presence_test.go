@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// presencePipe returns a registry-side connection authenticated as user and the peer end
+func presencePipe(t *testing.T, user, device string) (*Conn, *Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	conn := NewConn(server)
+	conn.SetPrincipal(&Principal{ID: user, Attributes: map[string]string{PresenceDeviceAttribute: device}})
+	return conn, NewConn(client)
+}
+
+// expectPresence reads the next frame and checks that it is a presence update
+func expectPresence(t *testing.T, conn *Conn, user string, status PresenceStatus, text string) *Presence {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	f, err := conn.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read presence update: %v", err)
+	}
+	msg, err := ParseMessage(f)
+	if err != nil {
+		t.Fatalf("Failed to parse presence update: %v", err)
+	}
+	p, ok := msg.(*Presence)
+	if !ok || p.User != user || p.Device != "" || p.Status != status || p.Text != text {
+		t.Fatalf("Expected %s %s %q, got %#v", user, status, text, msg)
+	}
+	return p
+}
+
+// TestPresenceAggregation tests multi-device aggregation and updates to watchers
+func TestPresenceAggregation(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var changes []PresenceStatus
+	registry := NewPresenceRegistry(PresenceConfig{
+		OnChange: func(p *Presence) { changes = append(changes, p.Status) },
+	})
+	registry.now = clock.Now
+
+	bob, bobPeer := presencePipe(t, "bob", "phone")
+	if err := registry.Connect(bob); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if err := registry.Watch(bob, "alice"); err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+	expectPresence(t, bobPeer, "alice", PresenceOffline, "")
+
+	phone, _ := presencePipe(t, "alice", "phone")
+	laptop, _ := presencePipe(t, "alice", "laptop")
+	registry.Connect(phone)
+	expectPresence(t, bobPeer, "alice", PresenceOnline, "")
+
+	// The laptop coming online and the phone going away leave alice online
+	clock.Advance(time.Second)
+	registry.Connect(laptop)
+	clock.Advance(time.Second)
+	if err := registry.Update(phone, PresenceAway, "commuting"); err != nil {
+		t.Fatalf("Failed to update presence: %v", err)
+	}
+	clock.Advance(time.Second)
+	registry.Update(laptop, PresenceAway, "in a meeting")
+	expectPresence(t, bobPeer, "alice", PresenceAway, "in a meeting")
+
+	devices := registry.Devices("alice")
+	if len(devices) != 2 || devices[0].Device != "laptop" || devices[1].Text != "commuting" {
+		t.Errorf("Unexpected devices: %+v, %+v", devices[0], devices[1])
+	}
+
+	registry.Disconnect(laptop)
+	expectPresence(t, bobPeer, "alice", PresenceAway, "commuting")
+	clock.Advance(time.Minute)
+	registry.Disconnect(phone)
+	offline := expectPresence(t, bobPeer, "alice", PresenceOffline, "")
+	if offline.LastSeen != clock.now.UnixMilli() {
+		t.Errorf("Expected last seen %d, got %d", clock.now.UnixMilli(), offline.LastSeen)
+	}
+	if got := registry.Get("alice"); got.Status != PresenceOffline {
+		t.Errorf("Unexpected aggregate presence: %+v", got)
+	}
+	if _, ok := registry.users["alice"]; ok {
+		t.Error("Expected the offline user to be removed from the registry")
+	}
+
+	expected := []PresenceStatus{PresenceOnline, PresenceOnline, PresenceAway, PresenceAway, PresenceOffline}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected changes %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Change %d: expected %s, got %s", i, expected[i], changes[i])
+		}
+	}
+
+	if err := registry.Update(NewConn(nil), PresenceAway, ""); !IsUnauthenticatedError(err) {
+		t.Errorf("Expected unauthenticated error for unregistered connection, got %v", err)
+	}
+}
+
+// TestPresenceServer tests presence driven by the server lifecycle and presence frames
+func TestPresenceServer(t *testing.T) {
+	registry := NewPresenceRegistry(PresenceConfig{
+		CanWatch: func(p *Principal, user string) error {
+			if user == "mallory" {
+				return NewUnauthenticatedError("not a contact")
+			}
+			return nil
+		},
+	})
+	srv := NewServer(ServerConfig{
+		Authenticators: []Authenticator{&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+			return &Principal{ID: token}, nil
+		}}},
+		OnConnect: registry.Connect,
+		OnClose:   registry.Disconnect,
+	})
+	srv.Use(registry.Middleware())
+	addr := startTestServer(t, srv)
+
+	dial := func(user string) *Conn {
+		conn, err := Dial(context.Background(), addr, nil)
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if _, err := ClientHandshake(conn.NetConn(), conn.decoder, &TokenCredentials{Token: user}); err != nil {
+			t.Fatalf("Failed to authenticate: %v", err)
+		}
+		return conn
+	}
+	send := func(conn *Conn, msg Message) {
+		frame, err := EncodeMessage(FrameTypeJSON, msg)
+		if err != nil {
+			t.Fatalf("Failed to encode %s: %v", msg.Opcode(), err)
+		}
+		if err := conn.WriteFrame(frame); err != nil {
+			t.Fatalf("Failed to write %s: %v", msg.Opcode(), err)
+		}
+	}
+
+	bob := dial("bob")
+	send(bob, &Watch{Users: []string{"alice"}})
+	expectPresence(t, bob, "alice", PresenceOffline, "")
+
+	send(bob, &Watch{Users: []string{"mallory"}})
+	reply, err := bob.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	var errMsg ErrorMessage
+	if err := json.Unmarshal(reply.Body, &errMsg); err != nil || errMsg.Code != ErrCodeUnauthenticated {
+		t.Errorf("Expected unauthenticated error frame, got %s", reply)
+	}
+
+	alice := dial("alice")
+	expectPresence(t, bob, "alice", PresenceOnline, "")
+	send(alice, &Presence{Status: PresenceAway, Text: "lunch"})
+	expectPresence(t, bob, "alice", PresenceAway, "lunch")
+
+	alice.Close()
+	expectPresence(t, bob, "alice", PresenceOffline, "")
+}
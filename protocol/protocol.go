@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 // 常量定义
@@ -21,6 +23,14 @@ const (
 	// FrameTypeMsgPack MsgPack格式
 	FrameTypeMsgPack = 3
 
+	// 帧类型字节的高位用作扩展标志位，低位为实际消息类型
+	// FrameFlagSigned 签名标志位，置位表示消息体之后附带HMAC签名尾部
+	FrameFlagSigned uint8 = 0x80
+	// FrameFlagSequenced 序列号标志位，置位表示消息体之前附带8字节序列号
+	FrameFlagSequenced uint8 = 0x40
+	// frameFlagMask 所有已定义扩展标志位的掩码
+	frameFlagMask = FrameFlagSigned | FrameFlagSequenced
+
 	// 版本常量
 	ProtocolVersionV1      uint8 = 1                 // 初始版本（当前实现）
 	ProtocolVersionV2      uint8 = 2                 // 迭代版本（比如新增字段/调整格式）
@@ -41,43 +51,37 @@ type tieredBufferPool struct {
 }
 
 // 全局分级缓冲区池实例
-var bufferPool = &tieredBufferPool{
-	smallPool: sync.Pool{
-		New: func() interface{} {
-			buf := make([]byte, smallBufferSize)
-			return &buf
-		},
-	},
-	mediumPool: sync.Pool{
-		New: func() interface{} {
-			buf := make([]byte, mediumBufferSize)
-			return &buf
-		},
-	},
-	largePool: sync.Pool{
-		New: func() interface{} {
-			buf := make([]byte, largeBufferSize)
-			return &buf
-		},
-	},
-}
+// 各级池不设置New，池为空时由Get创建缓冲区，以便区分命中与未命中
+var bufferPool = &tieredBufferPool{}
 
 // Get 根据所需大小从合适的池中获取缓冲区
 func (p *tieredBufferPool) Get(size int) *[]byte {
 	switch {
 	case size <= smallBufferSize:
-		return p.smallPool.Get().(*[]byte)
+		return getPooledBuffer(&p.smallPool, smallBufferSize)
 	case size <= mediumBufferSize:
-		return p.mediumPool.Get().(*[]byte)
+		return getPooledBuffer(&p.mediumPool, mediumBufferSize)
 	case size <= largeBufferSize:
-		return p.largePool.Get().(*[]byte)
+		return getPooledBuffer(&p.largePool, largeBufferSize)
 	default:
 		// 超大缓冲区直接创建，不缓存
+		loadMetrics().BufferGet(size, false)
 		buf := make([]byte, size)
 		return &buf
 	}
 }
 
+// getPooledBuffer 从池中取出缓冲区，池为空时创建指定大小的缓冲区
+func getPooledBuffer(pool *sync.Pool, size int) *[]byte {
+	if bufPtr, ok := pool.Get().(*[]byte); ok {
+		loadMetrics().BufferGet(size, true)
+		return bufPtr
+	}
+	loadMetrics().BufferGet(size, false)
+	buf := make([]byte, size)
+	return &buf
+}
+
 // Put 将缓冲区放回合适的池中
 func (p *tieredBufferPool) Put(bufPtr *[]byte) {
 	if bufPtr == nil {
@@ -97,8 +101,12 @@ func (p *tieredBufferPool) Put(bufPtr *[]byte) {
 		p.mediumPool.Put(bufPtr)
 	case capacity == largeBufferSize:
 		p.largePool.Put(bufPtr)
+	default:
 		// 其他大小的缓冲区不回收，让GC处理
+		loadMetrics().BufferPut(capacity, false)
+		return
 	}
+	loadMetrics().BufferPut(capacity, true)
 }
 
 // SupportedVersions 支持的协议版本列表
@@ -121,6 +129,20 @@ const (
 	ErrCodeInvalidFrameType ErrorCode = 4
 	// ErrCodeBufferTooSmall 缓冲区太小
 	ErrCodeBufferTooSmall ErrorCode = 5
+	// ErrCodeInvalidSignature 帧签名无效或缺失
+	ErrCodeInvalidSignature ErrorCode = 6
+	// ErrCodeReplayDetected 检测到重放帧（序列号重复、过旧或缺失）
+	ErrCodeReplayDetected ErrorCode = 7
+	// ErrCodeUnauthenticated 认证完成前收到非认证帧
+	ErrCodeUnauthenticated ErrorCode = 8
+	// ErrCodeAuthFailed 认证失败
+	ErrCodeAuthFailed ErrorCode = 9
+	// ErrCodeRateLimited 超出速率限制
+	ErrCodeRateLimited ErrorCode = 10
+	// ErrCodeFrameTimeout 不完整帧未在限定时间内完成（慢速攻击防护）
+	ErrCodeFrameTimeout ErrorCode = 11
+	// ErrCodeInvalidMessage 无效的信封消息（未知操作码、无法序列化等）
+	ErrCodeInvalidMessage ErrorCode = 12
 )
 
 // ProtocolError 自定义协议错误类型
@@ -163,6 +185,20 @@ var (
 	ErrInvalidFrameType = &ProtocolError{Code: ErrCodeInvalidFrameType, Message: "invalid frame type"}
 	// ErrBufferTooSmall 缓冲区太小
 	ErrBufferTooSmall = &ProtocolError{Code: ErrCodeBufferTooSmall, Message: "buffer too small"}
+	// ErrInvalidSignature 帧签名无效或缺失
+	ErrInvalidSignature = &ProtocolError{Code: ErrCodeInvalidSignature, Message: "invalid frame signature"}
+	// ErrReplayDetected 检测到重放帧
+	ErrReplayDetected = &ProtocolError{Code: ErrCodeReplayDetected, Message: "replay detected"}
+	// ErrUnauthenticated 认证完成前收到非认证帧
+	ErrUnauthenticated = &ProtocolError{Code: ErrCodeUnauthenticated, Message: "unauthenticated"}
+	// ErrAuthFailed 认证失败
+	ErrAuthFailed = &ProtocolError{Code: ErrCodeAuthFailed, Message: "authentication failed"}
+	// ErrRateLimited 超出速率限制
+	ErrRateLimited = &ProtocolError{Code: ErrCodeRateLimited, Message: "rate limited"}
+	// ErrFrameTimeout 不完整帧接收超时
+	ErrFrameTimeout = &ProtocolError{Code: ErrCodeFrameTimeout, Message: "frame timeout"}
+	// ErrInvalidMessage 无效的信封消息
+	ErrInvalidMessage = &ProtocolError{Code: ErrCodeInvalidMessage, Message: "invalid message"}
 )
 
 // NewMessageTooLongError 创建消息过长错误，包含实际长度和最大长度信息
@@ -197,6 +233,62 @@ func NewInvalidFrameTypeError(actualType uint8, supportedTypes []uint8) error {
 	}
 }
 
+// NewInvalidSignatureError 创建签名无效错误，包含详细信息
+func NewInvalidSignatureError(detail string) error {
+	return &ProtocolError{
+		Code:    ErrCodeInvalidSignature,
+		Message: fmt.Sprintf("invalid frame signature: %s", detail),
+	}
+}
+
+// NewReplayError 创建重放检测错误，包含序列号和详细原因
+func NewReplayError(sequence uint64, detail string) error {
+	return &ProtocolError{
+		Code:    ErrCodeReplayDetected,
+		Message: fmt.Sprintf("replay detected: sequence %d %s", sequence, detail),
+	}
+}
+
+// NewUnauthenticatedError 创建未认证错误，包含详细信息
+func NewUnauthenticatedError(detail string) error {
+	return &ProtocolError{
+		Code:    ErrCodeUnauthenticated,
+		Message: fmt.Sprintf("unauthenticated: %s", detail),
+	}
+}
+
+// NewAuthFailedError 创建认证失败错误，包含失败原因
+func NewAuthFailedError(reason string) error {
+	return &ProtocolError{
+		Code:    ErrCodeAuthFailed,
+		Message: fmt.Sprintf("authentication failed: %s", reason),
+	}
+}
+
+// NewRateLimitedError 创建超出速率限制错误，包含帧类型信息
+func NewRateLimitedError(frameType uint8) error {
+	return &ProtocolError{
+		Code:    ErrCodeRateLimited,
+		Message: fmt.Sprintf("rate limited: frame type %d exceeds the configured limit", frameType),
+	}
+}
+
+// NewFrameTimeoutError 创建不完整帧接收超时错误，包含详细信息
+func NewFrameTimeoutError(detail string) error {
+	return &ProtocolError{
+		Code:    ErrCodeFrameTimeout,
+		Message: fmt.Sprintf("frame timeout: %s", detail),
+	}
+}
+
+// NewInvalidMessageError 创建无效信封消息错误，包含详细信息
+func NewInvalidMessageError(detail string) error {
+	return &ProtocolError{
+		Code:    ErrCodeInvalidMessage,
+		Message: fmt.Sprintf("invalid message: %s", detail),
+	}
+}
+
 // IsFrameTypeError 检查错误是否为无效帧类型错误
 func IsFrameTypeError(err error) bool {
 	var pErr *ProtocolError
@@ -221,6 +313,48 @@ func IsInvalidFrameError(err error) bool {
 	return errors.As(err, &pErr) && pErr.Code == ErrCodeInvalidFrame
 }
 
+// IsSignatureError 检查错误是否为签名校验错误
+func IsSignatureError(err error) bool {
+	var pErr *ProtocolError
+	return errors.As(err, &pErr) && pErr.Code == ErrCodeInvalidSignature
+}
+
+// IsReplayError 检查错误是否为重放检测错误
+func IsReplayError(err error) bool {
+	var pErr *ProtocolError
+	return errors.As(err, &pErr) && pErr.Code == ErrCodeReplayDetected
+}
+
+// IsUnauthenticatedError 检查错误是否为未认证错误
+func IsUnauthenticatedError(err error) bool {
+	var pErr *ProtocolError
+	return errors.As(err, &pErr) && pErr.Code == ErrCodeUnauthenticated
+}
+
+// IsAuthFailedError 检查错误是否为认证失败错误
+func IsAuthFailedError(err error) bool {
+	var pErr *ProtocolError
+	return errors.As(err, &pErr) && pErr.Code == ErrCodeAuthFailed
+}
+
+// IsRateLimitedError 检查错误是否为超出速率限制错误
+func IsRateLimitedError(err error) bool {
+	var pErr *ProtocolError
+	return errors.As(err, &pErr) && pErr.Code == ErrCodeRateLimited
+}
+
+// IsFrameTimeoutError 检查错误是否为不完整帧接收超时错误
+func IsFrameTimeoutError(err error) bool {
+	var pErr *ProtocolError
+	return errors.As(err, &pErr) && pErr.Code == ErrCodeFrameTimeout
+}
+
+// IsInvalidMessageError 检查错误是否为无效信封消息错误
+func IsInvalidMessageError(err error) bool {
+	var pErr *ProtocolError
+	return errors.As(err, &pErr) && pErr.Code == ErrCodeInvalidMessage
+}
+
 // GetErrorCode 从错误中提取错误码
 func GetErrorCode(err error) ErrorCode {
 	var pErr *ProtocolError
@@ -250,6 +384,12 @@ type Frame struct {
 	bodyLength uint32
 	// Body 消息体
 	Body []byte
+	// Signed 解码时是否携带并通过了HMAC签名校验
+	Signed bool
+	// KeyID 签名所用的密钥ID，仅当Signed为true时有效
+	KeyID uint8
+	// Sequence 发送方序列号，用于防重放，0表示不携带序列号
+	Sequence uint64
 }
 
 // GetBodyLength 获取消息体长度
@@ -312,73 +452,31 @@ func NewSyncFrame(frameType uint8, body []byte, options ...ConstructorOption) (*
 
 // Encode 并发安全的编码方法
 // 优化：减少临界区范围，提高并发性能
-func (sf *SyncFrame) Encode() ([]byte, error) {
-	// 第一步：读锁下读取必要字段
+func (sf *SyncFrame) Encode(options ...EncodeOption) ([]byte, error) {
+	// 第一步：读锁下复制帧快照
 	sf.mu.RLock()
 
-	// 复制必要字段到局部变量
-	version := sf.Version
-	subVersion := sf.SubVersion
-	frameType := sf.Type
-	body := make([]byte, len(sf.Body))
-	copy(body, sf.Body)
-	bodyLength := sf.bodyLength
+	snapshot := Frame{
+		Version:    sf.Version,
+		SubVersion: sf.SubVersion,
+		Type:       sf.Type,
+		bodyLength: sf.bodyLength,
+		Body:       make([]byte, len(sf.Body)),
+		Sequence:   sf.Sequence,
+	}
+	copy(snapshot.Body, sf.Body)
 
 	// 释放读锁，减少临界区范围
 	sf.mu.RUnlock()
 
-	// 验证消息体长度是否超过限制
-	if len(body) > MaxMessageLength {
-		return nil, NewMessageTooLongError(len(body), MaxMessageLength)
-	}
-
-	// 验证版本是否为支持的版本
-	if !isSupportedVersion(version) {
-		return nil, NewUnsupportedVersionError(version, SupportedVersions)
-	}
-
 	// 第二步：无锁编码
-	// 计算总长度：帧头长度 + 消息体长度
-	totalLength := FrameHeaderLength + len(body)
-
-	// 从分级池中获取合适大小的缓冲区
-	bufPtr := bufferPool.Get(totalLength)
-	buf := *bufPtr
-
-	// 如果是超大缓冲区（超过8KB），直接使用所需大小
-	if cap(buf) < totalLength {
-		buf = make([]byte, totalLength)
-		*bufPtr = buf // 更新指针指向新缓冲区
-	}
-
-	// 重置缓冲区长度
-	buf = buf[:totalLength]
-
-	// 写入版本号
-	buf[0] = version
-	// 写入子版本号
-	buf[1] = subVersion
-	// 写入消息类型
-	buf[2] = frameType
-	// 写入消息体长度
-	binary.BigEndian.PutUint32(buf[3:7], bodyLength)
-	// 写入消息体
-	copy(buf[7:], body)
-
-	// 创建返回值副本，避免池中的缓冲区被修改
-	result := make([]byte, totalLength)
-	copy(result, buf)
-
-	// 将缓冲区放回合适的池中
-	bufferPool.Put(bufPtr)
-
-	return result, nil
+	return snapshot.Encode(options...)
 }
 
 // Decode 并发安全的解码方法
 // 注意：这个方法不会修改当前SyncFrame实例，而是返回一个新的SyncFrame实例
-func (sf *SyncFrame) Decode(data []byte) (*SyncFrame, error) {
-	frame, err := Decode(data)
+func (sf *SyncFrame) Decode(data []byte, options ...DecodeOption) (*SyncFrame, error) {
+	frame, err := Decode(data, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -487,6 +585,9 @@ func (sf *SyncFrame) Clone() *SyncFrame {
 			Type:       sf.Type,
 			bodyLength: sf.bodyLength,
 			Body:       body,
+			Signed:     sf.Signed,
+			KeyID:      sf.KeyID,
+			Sequence:   sf.Sequence,
 		},
 	}
 }
@@ -523,14 +624,19 @@ type ConstructorOption interface {
 }
 
 // EncodeOption 编码期选项接口
-// 用于Encode方法的选项配置
-// 实现了FrameOption接口，可用于Encode相关方法
+// 用于Encode相关方法的选项配置，不修改Frame本身
 type EncodeOption interface {
-	FrameOption
 	// 标记为编码期选项
 	isEncodeOption()
 }
 
+// DecodeOption 解码期选项接口
+// 用于Decode函数和StreamDecoder的选项配置
+type DecodeOption interface {
+	// 标记为解码期选项
+	isDecodeOption()
+}
+
 // 版本选项实现
 type versionOption struct {
 	version uint8
@@ -689,6 +795,11 @@ func NewFrame(frameType uint8, body []byte, options ...ConstructorOption) (*Fram
 //
 // 将Frame结构体编码为字节数组，用于网络传输
 //
+// 参数：
+//
+//	options - 可选的编码期选项，支持：
+//	  - WithSigner(signer *FrameSigner): 附加HMAC-SHA256签名尾部
+//
 // 返回值：
 //
 //	 []byte - 成功时返回编码后的字节数组
@@ -723,17 +834,15 @@ func NewFrame(frameType uint8, body []byte, options ...ConstructorOption) (*Fram
 //   - 使用大端序编码消息体长度
 //   - 帧格式：[1字节版本号][1字节消息类型][4字节消息体长度][消息体]
 //   - 总长度为帧头长度（6字节）加上消息体长度
-func (f *Frame) Encode() ([]byte, error) {
-	// 验证版本是否为支持的版本
-	if !isSupportedVersion(f.Version) {
-		return nil, NewUnsupportedVersionError(f.Version, SupportedVersions)
-	}
-	if len(f.Body) > MaxMessageLength {
-		return nil, NewMessageTooLongError(len(f.Body), MaxMessageLength)
+func (f *Frame) Encode(options ...EncodeOption) ([]byte, error) {
+	cfg := newEncodeConfig(options)
+	if err := f.checkEncodable(cfg); err != nil {
+		observeEncode(f.Type, 0, err)
+		return nil, err
 	}
 
-	// 计算总长度：帧头长度 + 消息体长度
-	totalLength := FrameHeaderLength + len(f.Body)
+	// 计算总长度：帧头长度 + 消息体长度（含扩展字段）
+	totalLength := FrameHeaderLength + f.payloadLength(cfg)
 
 	// 从分级池中获取合适大小的缓冲区
 	bufPtr := bufferPool.Get(totalLength)
@@ -748,16 +857,8 @@ func (f *Frame) Encode() ([]byte, error) {
 	// 重置缓冲区长度
 	buf = buf[:totalLength]
 
-	// 写入版本号
-	buf[0] = f.Version
-	// 写入子版本号
-	buf[1] = f.SubVersion
-	// 写入消息类型
-	buf[2] = f.Type
-	// 写入消息体长度
-	binary.BigEndian.PutUint32(buf[3:7], f.bodyLength)
-	// 写入消息体
-	copy(buf[7:], f.Body)
+	// 写入帧头、消息体及扩展字段
+	f.writeWire(buf, cfg)
 
 	// 创建返回值副本，避免池中的缓冲区被修改
 	result := make([]byte, totalLength)
@@ -766,15 +867,103 @@ func (f *Frame) Encode() ([]byte, error) {
 	// 将缓冲区放回合适的池中
 	bufferPool.Put(bufPtr)
 
+	observeEncode(f.Type, totalLength, nil)
 	return result, nil
 }
 
+// encodeConfig 编码期选项的解析结果
+type encodeConfig struct {
+	// signer 帧签名器，为nil时不签名
+	signer *FrameSigner
+}
+
+// newEncodeConfig 解析编码期选项
+func newEncodeConfig(options []EncodeOption) *encodeConfig {
+	cfg := &encodeConfig{}
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case *signerOption:
+			cfg.signer = o.signer
+		}
+	}
+	return cfg
+}
+
+// checkEncodable 校验帧是否可以编码
+func (f *Frame) checkEncodable(cfg *encodeConfig) error {
+	// 验证版本是否为支持的版本
+	if !isSupportedVersion(f.Version) {
+		return NewUnsupportedVersionError(f.Version, SupportedVersions)
+	}
+	if len(f.Body) > MaxMessageLength {
+		return NewMessageTooLongError(len(f.Body), MaxMessageLength)
+	}
+	// 扩展字段计入消息体长度，同样受最大长度限制
+	if payloadLength := f.payloadLength(cfg); payloadLength > MaxMessageLength {
+		return NewMessageTooLongError(payloadLength, MaxMessageLength)
+	}
+	return nil
+}
+
+// wireType 返回写入帧头的类型字节（消息类型 + 扩展标志位）
+func (f *Frame) wireType(cfg *encodeConfig) uint8 {
+	frameType := f.Type
+	if f.Sequence != 0 {
+		frameType |= FrameFlagSequenced
+	}
+	if cfg.signer != nil {
+		frameType |= FrameFlagSigned
+	}
+	return frameType
+}
+
+// payloadLength 返回帧头中记录的消息体长度（含扩展字段）
+func (f *Frame) payloadLength(cfg *encodeConfig) int {
+	length := int(f.bodyLength)
+	if f.Sequence != 0 {
+		length += SequenceLength
+	}
+	if cfg.signer != nil {
+		length += SignatureTrailerLength
+	}
+	return length
+}
+
+// writeWire 将帧头、消息体和扩展字段写入buf，buf长度必须足够
+func (f *Frame) writeWire(buf []byte, cfg *encodeConfig) int {
+	// 写入版本号
+	buf[0] = f.Version
+	// 写入子版本号
+	buf[1] = f.SubVersion
+	// 写入消息类型
+	buf[2] = f.wireType(cfg)
+	// 写入消息体长度
+	binary.BigEndian.PutUint32(buf[3:7], uint32(f.payloadLength(cfg)))
+	n := FrameHeaderLength
+
+	// 写入序列号
+	if f.Sequence != 0 {
+		binary.BigEndian.PutUint64(buf[n:n+SequenceLength], f.Sequence)
+		n += SequenceLength
+	}
+
+	// 写入消息体
+	n += copy(buf[n:], f.Body)
+
+	// 追加签名尾部
+	if cfg.signer != nil {
+		n += cfg.signer.signTo(buf[:n], buf[n:])
+	}
+	return n
+}
+
 // EncodeTo 将帧编码并写入io.Writer
 // 支持直接写入网络连接、文件等，避免中间缓冲区分配
 //
 // 参数：
 //
 //	w - 目标io.Writer
+//	options - 可选的编码期选项，同Encode
 //
 // 返回值：
 //
@@ -785,21 +974,26 @@ func (f *Frame) Encode() ([]byte, error) {
 //  1. 版本不支持：返回0, NewUnsupportedVersionError
 //  2. 消息体过长：返回0, NewMessageTooLongError
 //  3. 写入失败：返回已写入字节数, 具体io错误
-func (f *Frame) EncodeTo(w io.Writer) (n int, err error) {
-	// 验证版本是否为支持的版本
-	if !isSupportedVersion(f.Version) {
-		return 0, NewUnsupportedVersionError(f.Version, SupportedVersions)
-	}
-	if len(f.Body) > MaxMessageLength {
-		return 0, NewMessageTooLongError(len(f.Body), MaxMessageLength)
+func (f *Frame) EncodeTo(w io.Writer, options ...EncodeOption) (n int, err error) {
+	cfg := newEncodeConfig(options)
+	if err := f.checkEncodable(cfg); err != nil {
+		observeEncode(f.Type, 0, err)
+		return 0, err
 	}
 
-	// 构造帧头
-	header := make([]byte, FrameHeaderLength)
+	// 构造帧头（携带序列号时一并写入帧头之后）
+	headerLength := FrameHeaderLength
+	if f.Sequence != 0 {
+		headerLength += SequenceLength
+	}
+	header := make([]byte, headerLength)
 	header[0] = f.Version
 	header[1] = f.SubVersion
-	header[2] = f.Type
-	binary.BigEndian.PutUint32(header[3:7], f.bodyLength)
+	header[2] = f.wireType(cfg)
+	binary.BigEndian.PutUint32(header[3:7], uint32(f.payloadLength(cfg)))
+	if f.Sequence != 0 {
+		binary.BigEndian.PutUint64(header[FrameHeaderLength:], f.Sequence)
+	}
 
 	// 写入帧头
 	n, err = w.Write(header)
@@ -815,6 +1009,17 @@ func (f *Frame) EncodeTo(w io.Writer) (n int, err error) {
 		return n, err
 	}
 
+	// 写入签名尾部
+	if cfg.signer != nil {
+		var trailerN int
+		trailerN, err = w.Write(cfg.signer.trailer(header, f.Body))
+		n += trailerN
+		if err != nil {
+			return n, err
+		}
+	}
+
+	observeEncode(f.Type, n, nil)
 	return n, nil
 }
 
@@ -843,38 +1048,30 @@ func (f *Frame) EncodeTo(w io.Writer) (n int, err error) {
 //	if err == nil {
 //		// 使用 buf[:n] 作为编码结果
 //	}
-func (f *Frame) EncodeToBytes(buf []byte) (n int, err error) {
-	// 验证版本是否为支持的版本
-	if !isSupportedVersion(f.Version) {
-		return 0, NewUnsupportedVersionError(f.Version, SupportedVersions)
-	}
-	if len(f.Body) > MaxMessageLength {
-		return 0, NewMessageTooLongError(len(f.Body), MaxMessageLength)
+func (f *Frame) EncodeToBytes(buf []byte, options ...EncodeOption) (n int, err error) {
+	cfg := newEncodeConfig(options)
+	if err := f.checkEncodable(cfg); err != nil {
+		observeEncode(f.Type, 0, err)
+		return 0, err
 	}
 
-	// 计算总长度：帧头长度 + 消息体长度
-	totalLength := FrameHeaderLength + len(f.Body)
+	// 计算总长度：帧头长度 + 消息体长度（含扩展字段）
+	totalLength := FrameHeaderLength + f.payloadLength(cfg)
 
 	// 检查缓冲区大小是否足够
 	if len(buf) < totalLength {
-		return 0, &ProtocolError{
+		err := &ProtocolError{
 			Code:    ErrCodeBufferTooSmall,
 			Message: fmt.Sprintf("buffer too small: need %d bytes, got %d bytes", totalLength, len(buf)),
 		}
+		observeEncode(f.Type, 0, err)
+		return 0, err
 	}
 
-	// 写入版本号
-	buf[0] = f.Version
-	// 写入子版本号
-	buf[1] = f.SubVersion
-	// 写入消息类型
-	buf[2] = f.Type
-	// 写入消息体长度
-	binary.BigEndian.PutUint32(buf[3:7], f.bodyLength)
-	// 写入消息体
-	copy(buf[7:7+len(f.Body)], f.Body)
-
-	return totalLength, nil
+	// 写入帧头、消息体及扩展字段
+	n = f.writeWire(buf, cfg)
+	observeEncode(f.Type, n, nil)
+	return n, nil
 }
 
 // Decode 解码协议帧
@@ -885,6 +1082,10 @@ func (f *Frame) EncodeToBytes(buf []byte) (n int, err error) {
 //
 //  data - 要解码的字节数组
 //
+//  options - 可选的解码期选项，支持：
+//    - WithSignatureVerifier(signer *FrameSigner): 校验HMAC签名，拒绝未签名的帧
+//    - WithReplayWindow(window *ReplayWindow): 校验序列号，拒绝重放帧
+//
 // 返回值：
 //
 //  *Frame - 成功时返回解码后的Frame结构体指针
@@ -928,12 +1129,27 @@ func (f *Frame) EncodeToBytes(buf []byte) (n int, err error) {
 //     当帧类型不合法时
 //     返回值: nil, ErrInvalidFrameType
 //
+//  5. 消息体过长
+//     当帧头中的消息体长度超过MaxMessageLength时（与StreamDecoder和Encode的限制一致）
+//     返回值: nil, NewMessageTooLongError
+//
 // 实现中的重要细节：
 //
 //   - 使用大端序解码消息长度
 //   - 版本字段在前1个字节，用于区分不同的协议版本
 //   - 根据版本号直接调用对应的解码函数
-func Decode(data []byte) (*Frame, error) {
+func Decode(data []byte, options ...DecodeOption) (*Frame, error) {
+	frame, err := decode(data, newDecodeConfig(options))
+	if err != nil {
+		observeDecode(nil, 0, err)
+		return nil, err
+	}
+	observeDecode(frame, FrameHeaderLength+int(binary.BigEndian.Uint32(data[3:7])), nil)
+	return frame, nil
+}
+
+// decode 解码协议帧，不记录指标
+func decode(data []byte, cfg *decodeConfig) (*Frame, error) {
 	if len(data) < FrameHeaderLength {
 		return nil, NewInvalidFrameError(fmt.Sprintf("data length %d is less than header length %d", len(data), FrameHeaderLength))
 	}
@@ -944,9 +1160,9 @@ func Decode(data []byte) (*Frame, error) {
 	// 根据版本号调用对应的解码函数
 	switch version {
 	case ProtocolVersionV1:
-		return decodeV1(data)
+		return decodeV1(data, cfg)
 	case ProtocolVersionV2:
-		return decodeV2(data)
+		return decodeV2(data, cfg)
 	default:
 		return nil, NewUnsupportedVersionError(version, SupportedVersions)
 	}
@@ -954,14 +1170,19 @@ func Decode(data []byte) (*Frame, error) {
 
 // decodeV1 解码V1版本的协议帧
 // 帧格式：[1字节版本号][1字节消息类型][4字节消息体长度][消息体]
-func decodeV1(data []byte) (*Frame, error) {
+func decodeV1(data []byte, cfg *decodeConfig) (*Frame, error) {
 	// 解析子版本号
 	subVersion := data[1]
-	// 解析消息类型
-	frameType := data[2]
+	// 解析消息类型（去除扩展标志位）
+	frameType := data[2] &^ frameFlagMask
 	// 解析消息体长度
 	bodyLength := binary.BigEndian.Uint32(data[3:7])
 
+	// 先校验长度上限，与StreamDecoder一致；同时避免32位平台上int转换溢出导致切片越界
+	if bodyLength > uint32(MaxMessageLength) {
+		return nil, NewMessageTooLongError(int(bodyLength), MaxMessageLength)
+	}
+
 	// 检查数据是否完整
 	expectedLength := FrameHeaderLength + int(bodyLength)
 	if len(data) < expectedLength {
@@ -973,29 +1194,32 @@ func decodeV1(data []byte) (*Frame, error) {
 		return nil, NewInvalidFrameTypeError(frameType, []uint8{FrameTypeJSON, FrameTypeProtobuf, FrameTypeMsgPack})
 	}
 
-	// 解析消息体并深拷贝，避免原始数据修改影响Frame
-	body := make([]byte, bodyLength)
-	copy(body, data[FrameHeaderLength:expectedLength])
-
-	return &Frame{
+	frame := &Frame{
 		Version:    ProtocolVersionV1,
 		SubVersion: subVersion,
 		Type:       frameType,
-		bodyLength: bodyLength,
-		Body:       body,
-	}, nil
+	}
+	if err := decodePayload(frame, data[:expectedLength], cfg); err != nil {
+		return nil, err
+	}
+	return frame, nil
 }
 
 // decodeV2 解码V2版本的协议帧
 // 帧格式：[1字节版本号][1字节子版本号][1字节消息类型][4字节消息体长度][消息体]
-func decodeV2(data []byte) (*Frame, error) {
+func decodeV2(data []byte, cfg *decodeConfig) (*Frame, error) {
 	// 解析子版本号
 	subVersion := data[1]
-	// 解析消息类型
-	frameType := data[2]
+	// 解析消息类型（去除扩展标志位）
+	frameType := data[2] &^ frameFlagMask
 	// 解析消息体长度
 	bodyLength := binary.BigEndian.Uint32(data[3:7])
 
+	// 先校验长度上限，与StreamDecoder一致；同时避免32位平台上int转换溢出导致切片越界
+	if bodyLength > uint32(MaxMessageLength) {
+		return nil, NewMessageTooLongError(int(bodyLength), MaxMessageLength)
+	}
+
 	// 检查数据是否完整
 	expectedLength := FrameHeaderLength + int(bodyLength)
 	if len(data) < expectedLength {
@@ -1007,17 +1231,87 @@ func decodeV2(data []byte) (*Frame, error) {
 		return nil, NewInvalidFrameTypeError(frameType, []uint8{FrameTypeJSON, FrameTypeProtobuf, FrameTypeMsgPack})
 	}
 
-	// 解析消息体并深拷贝，避免原始数据修改影响Frame
-	body := make([]byte, bodyLength)
-	copy(body, data[FrameHeaderLength:expectedLength])
-
-	return &Frame{
+	frame := &Frame{
 		Version:    ProtocolVersionV2,
 		SubVersion: subVersion,
 		Type:       frameType,
-		bodyLength: bodyLength,
-		Body:       body,
-	}, nil
+	}
+	if err := decodePayload(frame, data[:expectedLength], cfg); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// decodeConfig 解码期选项的解析结果
+type decodeConfig struct {
+	// verifier 签名校验器，为nil时不接受签名帧
+	verifier *FrameSigner
+	// replayWindow 重放检测窗口，为nil时不做重放检测
+	replayWindow *ReplayWindow
+}
+
+// newDecodeConfig 解析解码期选项
+func newDecodeConfig(options []DecodeOption) *decodeConfig {
+	cfg := &decodeConfig{}
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case *verifierOption:
+			cfg.verifier = o.signer
+		case *replayWindowOption:
+			cfg.replayWindow = o.window
+		}
+	}
+	return cfg
+}
+
+// decodePayload 根据扩展标志位处理签名尾部、序列号等扩展字段，并填充帧的消息体
+// raw 为完整的帧数据（帧头 + 消息体）
+func decodePayload(frame *Frame, raw []byte, cfg *decodeConfig) error {
+	flags := raw[2] & frameFlagMask
+	payload := raw[FrameHeaderLength:]
+
+	// 校验签名尾部
+	if flags&FrameFlagSigned != 0 {
+		if cfg.verifier == nil {
+			return NewInvalidSignatureError("signed frame received but no verifier configured")
+		}
+		if len(payload) < SignatureTrailerLength {
+			return NewInvalidFrameError(fmt.Sprintf("signed frame body length %d is less than signature trailer length %d", len(payload), SignatureTrailerLength))
+		}
+		keyID, err := cfg.verifier.verify(raw)
+		if err != nil {
+			return err
+		}
+		frame.Signed = true
+		frame.KeyID = keyID
+		payload = payload[:len(payload)-SignatureTrailerLength]
+	} else if cfg.verifier != nil {
+		// 配置了校验器时拒绝未签名的帧，防止签名被剥离
+		return NewInvalidSignatureError("frame is not signed")
+	}
+
+	// 解析序列号
+	if flags&FrameFlagSequenced != 0 {
+		if len(payload) < SequenceLength {
+			return NewInvalidFrameError(fmt.Sprintf("sequenced frame body length %d is less than sequence length %d", len(payload), SequenceLength))
+		}
+		frame.Sequence = binary.BigEndian.Uint64(payload[:SequenceLength])
+		payload = payload[SequenceLength:]
+	}
+
+	// 重放检测放在签名校验之后，避免伪造的序列号污染窗口
+	if cfg.replayWindow != nil {
+		if err := cfg.replayWindow.Check(frame.Sequence); err != nil {
+			return err
+		}
+	}
+
+	// 解析消息体并深拷贝，避免原始数据修改影响Frame
+	body := make([]byte, len(payload))
+	copy(body, payload)
+	frame.Body = body
+	frame.bodyLength = uint32(len(body))
+	return nil
 }
 
 // Clone 创建Frame的深拷贝
@@ -1037,6 +1331,9 @@ func (f *Frame) Clone() *Frame {
 		Type:       f.Type,
 		bodyLength: f.bodyLength,
 		Body:       body,
+		Signed:     f.Signed,
+		KeyID:      f.KeyID,
+		Sequence:   f.Sequence,
 	}
 }
 
@@ -1163,6 +1460,16 @@ type StreamDecoder struct {
 	buffer []byte
 	// maxBufferSize 缓冲区最大大小，防止内存耗尽攻击
 	maxBufferSize int
+	// decodeOptions 解码每一帧时使用的解码期选项（如签名校验）
+	decodeOptions []DecodeOption
+	// frameTimeout 慢速攻击防护配置
+	frameTimeout FrameTimeoutConfig
+	// partial 正在接收的不完整帧的计时状态
+	partial partialFrameState
+	// dedup 去重缓存，为nil时不去重
+	dedup *DedupCache
+	// now 时钟，为nil时使用time.Now，便于测试替换
+	now func() time.Time
 }
 
 // NewStreamDecoder 从池中获取StreamDecoder实例
@@ -1183,6 +1490,10 @@ func NewStreamDecoderFromPool(maxBufferSize ...int) *StreamDecoder {
 func (sd *StreamDecoder) Release() {
 	// 重置缓冲区，但不释放到池中，因为解码器本身会被重用
 	sd.buffer = sd.buffer[:0]
+	sd.decodeOptions = nil
+	sd.frameTimeout = FrameTimeoutConfig{}
+	sd.partial = partialFrameState{}
+	sd.dedup = nil
 
 	// 将解码器放回池中
 	streamDecoderPool.Put(sd)
@@ -1202,6 +1513,12 @@ func NewStreamDecoder(maxBufferSize ...int) *StreamDecoder {
 	}
 }
 
+// SetDecodeOptions 设置解码每一帧时使用的解码期选项
+// 例如：sd.SetDecodeOptions(WithSignatureVerifier(signer))
+func (sd *StreamDecoder) SetDecodeOptions(options ...DecodeOption) {
+	sd.decodeOptions = options
+}
+
 // Feed 向解码器提供数据
 // 这些数据会被追加到内部缓冲区中
 // 返回错误如果缓冲区大小超过限制
@@ -1212,7 +1529,9 @@ func (sd *StreamDecoder) Feed(data []byte) error {
 
 	// 检查添加数据后是否会超过缓冲区大小限制
 	if len(sd.buffer)+len(data) > sd.maxBufferSize {
-		return NewMessageTooLongError(len(sd.buffer)+len(data), sd.maxBufferSize)
+		err := NewMessageTooLongError(len(sd.buffer)+len(data), sd.maxBufferSize)
+		observeDecode(nil, 0, err)
+		return err
 	}
 
 	// 确保缓冲区有足够容量
@@ -1241,8 +1560,10 @@ func (sd *StreamDecoder) Feed(data []byte) error {
 		// 释放旧缓冲区（如果它来自池）
 		if cap(sd.buffer) == smallBufferSize || cap(sd.buffer) == mediumBufferSize || cap(sd.buffer) == largeBufferSize {
 			// 如果旧缓冲区是池中的大小，放回池中
-			oldBufPtr := &sd.buffer
-			bufferPool.Put(oldBufPtr)
+			// 必须放入切片的副本：放入&sd.buffer会让池持有解码器字段的指针，
+			// 该字段随后指向新缓冲区，被其他使用方取出后两者共享同一块内存
+			oldBuf := sd.buffer
+			bufferPool.Put(&oldBuf)
 		}
 
 		// 使用新缓冲区
@@ -1251,6 +1572,7 @@ func (sd *StreamDecoder) Feed(data []byte) error {
 
 	// 追加新数据
 	sd.buffer = append(sd.buffer, data...)
+	loadMetrics().BytesFed(len(data))
 	return nil
 }
 
@@ -1258,7 +1580,22 @@ func (sd *StreamDecoder) Feed(data []byte) error {
 // 如果缓冲区中没有足够的数据，返回nil, nil
 // 如果有足够数据，返回解码的帧和更新后的缓冲区
 // 如果数据格式错误，返回nil, error
+// 设置了去重缓存时，重复的帧被丢弃，继续解码缓冲区中的下一帧
 func (sd *StreamDecoder) TryDecode() (*Frame, error) {
+	for {
+		buffered := len(sd.buffer)
+		frame, err := sd.decodeNext()
+		if err != nil || frame != nil {
+			observeDecode(frame, buffered-len(sd.buffer), err)
+		}
+		if err != nil || frame == nil || sd.dedup == nil || !sd.dedup.IsDuplicate(frame) {
+			return frame, err
+		}
+	}
+}
+
+// decodeNext 从缓冲区中解码下一个完整的帧，不做去重
+func (sd *StreamDecoder) decodeNext() (*Frame, error) {
 	// 检查是否有足够的数据读取帧头
 	if len(sd.buffer) < FrameHeaderLength {
 		return nil, nil // 数据不足，等待更多数据
@@ -1285,8 +1622,10 @@ func (sd *StreamDecoder) TryDecode() (*Frame, error) {
 
 	// 检查是否有足够的数据读取完整帧
 	if len(sd.buffer) < frameLength {
-		return nil, nil // 数据不足，等待更多数据
+		// 数据不足，等待更多数据；帧头已到达，检查慢速攻击防护限制
+		return nil, sd.checkPartialFrame()
 	}
+	sd.partial = partialFrameState{}
 
 	// 提取完整的帧数据
 	frameData := sd.buffer[:frameLength]
@@ -1303,8 +1642,8 @@ func (sd *StreamDecoder) TryDecode() (*Frame, error) {
 		sd.buffer = sd.buffer[frameLength:]
 	}
 
-	// 使用现有的Decode函数解码帧
-	return Decode(frameData)
+	// 使用与Decode相同的解码逻辑，指标由TryDecode记录
+	return decode(frameData, newDecodeConfig(sd.decodeOptions))
 }
 
 // DecodeFromReader 从io.Reader中读取数据并尝试解码帧
@@ -1349,17 +1688,79 @@ func (sd *StreamDecoder) DecodeFromReader(reader io.Reader) (*Frame, error) {
 	return sd.TryDecode()
 }
 
+// ReadFrame 从io.Reader中阻塞读取，直到解码出一个完整的帧
+// 与DecodeFromReader不同，数据不足时会继续读取而不是返回nil
+//
+// 错误处理：
+//  1. 流在帧边界处结束：返回nil, io.EOF
+//  2. 流在帧中间结束：返回nil, io.ErrUnexpectedEOF
+//  3. 数据格式错误：返回nil, 对应的ProtocolError
+func (sd *StreamDecoder) ReadFrame(reader io.Reader) (*Frame, error) {
+	tempBufPtr := bufferPool.Get(smallBufferSize)
+	defer bufferPool.Put(tempBufPtr)
+	tempBuf := *tempBufPtr
+
+	// 等待不完整帧期间收紧读截止时间，返回前恢复调用方设置的截止时间
+	deadlineSetter, _ := reader.(frameDeadlineSetter)
+	deadlineSet := false
+	defer func() {
+		if deadlineSet {
+			deadlineSetter.setFrameDeadline(time.Time{})
+		}
+	}()
+
+	for {
+		frame, err := sd.TryDecode()
+		if err != nil || frame != nil {
+			return frame, err
+		}
+
+		if deadlineSetter != nil {
+			if deadline, ok := sd.partialFrameDeadline(); ok {
+				deadlineSetter.setFrameDeadline(deadline)
+				deadlineSet = true
+			}
+		}
+
+		n, err := reader.Read(tempBuf)
+		if n > 0 {
+			if feedErr := sd.Feed(tempBuf[:n]); feedErr != nil {
+				return nil, feedErr
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				// 先尝试解码最后读取的数据
+				if frame, decodeErr := sd.TryDecode(); decodeErr != nil || frame != nil {
+					return frame, decodeErr
+				}
+				if sd.Buffered() > 0 {
+					return nil, io.ErrUnexpectedEOF
+				}
+			}
+			if deadlineSet && errors.Is(err, os.ErrDeadlineExceeded) {
+				// 由慢速攻击防护设置的截止时间触发，转换为帧超时错误
+				if timeoutErr := sd.checkPartialFrame(); timeoutErr != nil {
+					return nil, timeoutErr
+				}
+			}
+			return nil, err
+		}
+	}
+}
+
 // Reset 重置解码器的内部缓冲区
 // 在连接错误或需要重新开始解码时使用
 func (sd *StreamDecoder) Reset() {
 	// 如果缓冲区来自池，将其放回池中
 	if cap(sd.buffer) == smallBufferSize || cap(sd.buffer) == mediumBufferSize || cap(sd.buffer) == largeBufferSize {
-		bufPtr := &sd.buffer
-		bufferPool.Put(bufPtr)
+		oldBuf := sd.buffer
+		bufferPool.Put(&oldBuf)
 	}
 
 	// 创建一个新的小缓冲区，减少内存占用
 	sd.buffer = make([]byte, 0, smallBufferSize)
+	sd.partial = partialFrameState{}
 }
 
 // Buffered 返回当前缓冲区中的数据量
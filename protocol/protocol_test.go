@@ -7,6 +7,7 @@ import (
 	"io"
 	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 // TestFrameCreation tests creating a new Frame with different options
@@ -484,6 +485,36 @@ func TestStreamDecoderReset(t *testing.T) {
 	}
 }
 
+// TestStreamDecoderGrowReleasesCopy tests that growing the buffer does not hand the live buffer to the pool
+func TestStreamDecoderGrowReleasesCopy(t *testing.T) {
+	frame, _ := NewFrame(FrameTypeJSON, bytes.Repeat([]byte("x"), 1500))
+	data, _ := frame.Encode()
+
+	decoder := NewStreamDecoder()
+	// Grow from the initial buffer into a pooled 2KB buffer, then past it into a pooled 4KB buffer
+	decoder.Feed(data)
+	decoder.Feed(data)
+	decoder.Feed(data[:1000])
+
+	// Whatever the pool hands out next must not share memory with the decoder
+	for i := 0; i < 8; i++ {
+		buf := bufferPool.Get(smallBufferSize)
+		for j := range *buf {
+			(*buf)[j] = 0xff
+		}
+	}
+	decoder.Feed(data[1000:])
+	for i := 0; i < 3; i++ {
+		decoded, err := decoder.TryDecode()
+		if err != nil || decoded == nil {
+			t.Fatalf("Failed to decode frame %d: %v", i, err)
+		}
+		if !bytes.Equal(decoded.Body, frame.Body) {
+			t.Fatalf("Frame %d was corrupted by a pooled buffer", i)
+		}
+	}
+}
+
 // TestErrorHandling tests various error scenarios
 func TestErrorHandling(t *testing.T) {
 	// Test message too long - should be caught during encoding
@@ -552,6 +583,16 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+// TestDecodeOversizedBody tests that Decode rejects body lengths Encode would refuse, even when the data is complete
+func TestDecodeOversizedBody(t *testing.T) {
+	data := make([]byte, FrameHeaderLength+MaxMessageLength+1)
+	data[0], data[2] = ProtocolVersionV2, FrameTypeJSON
+	binary.BigEndian.PutUint32(data[3:7], MaxMessageLength+1)
+	if _, err := Decode(data); !IsMessageTooLongError(err) {
+		t.Errorf("Expected message too long error, got %v", err)
+	}
+}
+
 // TestStreamDecoderMaxBufferSize tests the max buffer size limit
 func TestStreamDecoderMaxBufferSize(t *testing.T) {
 	decoder := NewStreamDecoder(100) // Max buffer size 100 bytes
@@ -570,3 +611,38 @@ func TestStreamDecoderMaxBufferSize(t *testing.T) {
 		t.Error("Expected message too long error")
 	}
 }
+
+// TestStreamDecoderReadFrame tests blocking frame reads across chunk boundaries
+func TestStreamDecoderReadFrame(t *testing.T) {
+	frame, err := NewFrame(FrameTypeJSON, []byte("message1"))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	// One byte per Read call
+	decoder := NewStreamDecoder()
+	decoded, err := decoder.ReadFrame(iotest.OneByteReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+
+	if !bytes.Equal(decoded.Body, frame.Body) {
+		t.Errorf("Expected body %v, got %v", frame.Body, decoded.Body)
+	}
+
+	// Clean end of stream
+	if _, err := decoder.ReadFrame(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	// Stream ends in the middle of a frame
+	decoder = NewStreamDecoder()
+	if _, err := decoder.ReadFrame(bytes.NewReader(data[:len(data)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package protocol
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimitMaxDelay 延迟动作下单帧允许的最大等待时间
+const DefaultRateLimitMaxDelay = time.Second
+
+// RateLimitAction 超出速率限制时执行的动作
+type RateLimitAction uint8
+
+// 限流动作定义
+const (
+	// RateLimitDrop 丢弃超限的帧，继续读取下一帧
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitDelay 延迟处理超限的帧，等待时间超过MaxDelay时丢弃
+	RateLimitDelay
+	// RateLimitErrorFrame 丢弃超限的帧，并向对端回复错误帧
+	RateLimitErrorFrame
+	// RateLimitDisconnect 断开连接
+	RateLimitDisconnect
+)
+
+// String 返回限流动作的字符串表示
+func (a RateLimitAction) String() string {
+	switch a {
+	case RateLimitDrop:
+		return "drop"
+	case RateLimitDelay:
+		return "delay"
+	case RateLimitErrorFrame:
+		return "error_frame"
+	case RateLimitDisconnect:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("RateLimitAction(%d)", uint8(a))
+	}
+}
+
+// RateLimit 单个维度的速率限制，零值表示不限制
+type RateLimit struct {
+	// FramesPerSecond 每秒允许的帧数
+	FramesPerSecond float64
+	// FrameBurst 帧数突发容量，默认等于FramesPerSecond（至少为1）
+	FrameBurst int
+	// BytesPerSecond 每秒允许的字节数（按帧头 + 消息体计算）
+	BytesPerSecond float64
+	// ByteBurst 字节突发容量，默认等于BytesPerSecond
+	ByteBurst int
+}
+
+// RateLimiterConfig 限流器配置
+type RateLimiterConfig struct {
+	// Connection 整个连接的速率限制
+	Connection RateLimit
+	// PerType 按帧类型的速率限制，与连接限制同时生效
+	PerType map[uint8]RateLimit
+	// Action 超限时执行的动作
+	Action RateLimitAction
+	// MaxDelay 延迟动作下单帧允许的最大等待时间，默认DefaultRateLimitMaxDelay
+	MaxDelay time.Duration
+}
+
+// RateLimitDecision 限流检查结果
+type RateLimitDecision struct {
+	// Allowed 帧是否在限额内，可以立即处理
+	Allowed bool
+	// Action 超限时需要执行的动作
+	Action RateLimitAction
+	// Delay 延迟动作下处理该帧前需要等待的时间
+	Delay time.Duration
+}
+
+// RateLimitStats 限流统计
+type RateLimitStats struct {
+	// Allowed 限额内直接放行的帧数
+	Allowed uint64
+	// Delayed 被延迟处理的帧数
+	Delayed uint64
+	// Dropped 被丢弃的帧数（含回复错误帧的情况）
+	Dropped uint64
+	// ErrorFrames 回复的错误帧数
+	ErrorFrames uint64
+	// Disconnects 因超限触发的断开次数
+	Disconnects uint64
+	// LimitedByType 按帧类型统计的超限次数
+	LimitedByType map[uint8]uint64
+}
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	// rate 每秒补充的令牌数
+	rate float64
+	// burst 桶容量
+	burst float64
+	// tokens 当前令牌数，延迟动作预支后可能为负
+	tokens float64
+	// last 上次补充令牌的时间
+	last time.Time
+}
+
+// newTokenBucket 创建令牌桶，rate<=0时返回nil表示不限制
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	if b < 1 {
+		b = 1
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+// wait 返回获得n个令牌需要等待的时间
+// 超过桶容量的请求只需等待桶满即可，避免大帧永远无法通过
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	need := n
+	if need > b.burst {
+		need = b.burst
+	}
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take 扣除n个令牌
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+// rateBuckets 一个维度的帧数和字节数令牌桶
+type rateBuckets struct {
+	frames *tokenBucket
+	bytes  *tokenBucket
+}
+
+func newRateBuckets(limit RateLimit, now time.Time) *rateBuckets {
+	return &rateBuckets{
+		frames: newTokenBucket(limit.FramesPerSecond, limit.FrameBurst, now),
+		bytes:  newTokenBucket(limit.BytesPerSecond, limit.ByteBurst, now),
+	}
+}
+
+func (r *rateBuckets) wait(size float64, now time.Time) time.Duration {
+	return max(r.frames.wait(1, now), r.bytes.wait(size, now))
+}
+
+func (r *rateBuckets) take(size float64) {
+	r.frames.take(1)
+	r.bytes.take(size)
+}
+
+// RateLimiter 单个连接的令牌桶限流器
+// 同时按连接和帧类型限制帧数/秒和字节数/秒，任一维度超限即视为超限
+//
+// 使用示例：
+//
+//	limiter := NewRateLimiter(RateLimiterConfig{
+//		Connection: RateLimit{FramesPerSecond: 100, BytesPerSecond: 1 << 20},
+//		PerType:    map[uint8]RateLimit{FrameTypeJSON: {FramesPerSecond: 20}},
+//		Action:     RateLimitErrorFrame,
+//	})
+//	conn.SetRateLimiter(limiter)
+//
+// 并发安全说明：
+// RateLimiter 的所有方法均为并发安全，但每个连接应使用独立实例
+type RateLimiter struct {
+	// mu 互斥锁，保护令牌桶和统计
+	mu sync.Mutex
+	// config 限流配置
+	config RateLimiterConfig
+	// connection 连接维度的令牌桶
+	connection *rateBuckets
+	// perType 帧类型维度的令牌桶
+	perType map[uint8]*rateBuckets
+	// stats 统计
+	stats RateLimitStats
+	// now 时钟，便于测试替换
+	now func() time.Time
+}
+
+// NewRateLimiter 创建限流器
+func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
+	return newRateLimiter(config, time.Now)
+}
+
+// newRateLimiter 使用指定时钟创建限流器
+func newRateLimiter(config RateLimiterConfig, now func() time.Time) *RateLimiter {
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = DefaultRateLimitMaxDelay
+	}
+	l := &RateLimiter{
+		config:  config,
+		perType: make(map[uint8]*rateBuckets, len(config.PerType)),
+		stats:   RateLimitStats{LimitedByType: make(map[uint8]uint64)},
+		now:     now,
+	}
+
+	start := now()
+	l.connection = newRateBuckets(config.Connection, start)
+	for frameType, limit := range config.PerType {
+		l.perType[frameType] = newRateBuckets(limit, start)
+	}
+	return l
+}
+
+// Check 为一帧申请配额，返回需要执行的动作
+// 放行或延迟的帧会扣除配额，被丢弃或导致断开的帧不扣除
+func (l *RateLimiter) Check(f *Frame) RateLimitDecision {
+	size := float64(FrameHeaderLength + len(f.Body))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	wait := l.connection.wait(size, now)
+	typeBuckets := l.perType[f.Type]
+	if typeBuckets != nil {
+		wait = max(wait, typeBuckets.wait(size, now))
+	}
+
+	if wait == 0 {
+		l.take(typeBuckets, size)
+		l.stats.Allowed++
+		return RateLimitDecision{Allowed: true}
+	}
+
+	l.stats.LimitedByType[f.Type]++
+	decision := RateLimitDecision{Action: l.config.Action}
+	switch l.config.Action {
+	case RateLimitDelay:
+		if wait <= l.config.MaxDelay {
+			// 预支配额，等待结束后处理该帧
+			l.take(typeBuckets, size)
+			l.stats.Delayed++
+			decision.Delay = wait
+			return decision
+		}
+		decision.Action = RateLimitDrop
+		l.stats.Dropped++
+	case RateLimitErrorFrame:
+		l.stats.Dropped++
+		l.stats.ErrorFrames++
+	case RateLimitDisconnect:
+		l.stats.Disconnects++
+	default:
+		l.stats.Dropped++
+	}
+	return decision
+}
+
+// take 扣除连接维度和帧类型维度的配额
+// 调用方必须持有锁
+func (l *RateLimiter) take(typeBuckets *rateBuckets, size float64) {
+	l.connection.take(size)
+	if typeBuckets != nil {
+		typeBuckets.take(size)
+	}
+}
+
+// Stats 返回限流统计的快照
+func (l *RateLimiter) Stats() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.stats
+	stats.LimitedByType = make(map[uint8]uint64, len(l.stats.LimitedByType))
+	for frameType, n := range l.stats.LimitedByType {
+		stats.LimitedByType[frameType] = n
+	}
+	return stats
+}
@@ -0,0 +1,277 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for rate limiter tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestRateLimiter creates a limiter driven by a fake clock
+func newTestRateLimiter(config RateLimiterConfig) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	return newRateLimiter(config, clock.Now), clock
+}
+
+// TestRateLimiterFramesAndBytes tests per-connection and per-type token buckets
+func TestRateLimiterFramesAndBytes(t *testing.T) {
+	limiter, clock := newTestRateLimiter(RateLimiterConfig{
+		Connection: RateLimit{FramesPerSecond: 3, BytesPerSecond: 1000},
+		PerType:    map[uint8]RateLimit{FrameTypeMsgPack: {FramesPerSecond: 1}},
+	})
+
+	jsonFrame, _ := NewFrame(FrameTypeJSON, []byte("x"))
+	msgpack, _ := NewFrame(FrameTypeMsgPack, []byte("x"))
+
+	// Per-type limit: one MsgPack frame per second
+	if !limiter.Check(msgpack).Allowed {
+		t.Error("Expected first MsgPack frame to be allowed")
+	}
+	if limiter.Check(msgpack).Allowed {
+		t.Error("Expected second MsgPack frame to be limited")
+	}
+
+	// Connection limit: three frames per second in total
+	if !limiter.Check(jsonFrame).Allowed || !limiter.Check(jsonFrame).Allowed {
+		t.Error("Expected JSON frames within connection limit to be allowed")
+	}
+	if limiter.Check(jsonFrame).Allowed {
+		t.Error("Expected JSON frame beyond connection limit to be limited")
+	}
+
+	clock.Advance(time.Second)
+	if !limiter.Check(jsonFrame).Allowed {
+		t.Error("Expected frame to be allowed after refill")
+	}
+
+	// Byte limit: a frame bigger than the remaining byte budget is limited
+	big, _ := NewFrame(FrameTypeJSON, make([]byte, 990))
+	clock.Advance(time.Second)
+	if !limiter.Check(big).Allowed {
+		t.Error("Expected big frame within byte budget to be allowed")
+	}
+	if limiter.Check(jsonFrame).Allowed {
+		t.Error("Expected frame to be limited by bytes per second")
+	}
+
+	stats := limiter.Stats()
+	if stats.Allowed != 5 || stats.Dropped != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.LimitedByType[FrameTypeMsgPack] != 1 || stats.LimitedByType[FrameTypeJSON] != 2 {
+		t.Errorf("Unexpected per-type stats: %+v", stats.LimitedByType)
+	}
+}
+
+// TestRateLimiterDelay tests the delay action and its max delay fallback
+func TestRateLimiterDelay(t *testing.T) {
+	limiter, _ := newTestRateLimiter(RateLimiterConfig{
+		Connection: RateLimit{FramesPerSecond: 10, FrameBurst: 1},
+		Action:     RateLimitDelay,
+		MaxDelay:   150 * time.Millisecond,
+	})
+
+	frame, _ := NewFrame(FrameTypeJSON, []byte("x"))
+
+	if !limiter.Check(frame).Allowed {
+		t.Fatal("Expected first frame to be allowed")
+	}
+
+	decision := limiter.Check(frame)
+	if decision.Allowed || decision.Action != RateLimitDelay || decision.Delay != 100*time.Millisecond {
+		t.Errorf("Expected 100ms delay, got %+v", decision)
+	}
+
+	// The delayed frame borrowed a token, so the next one would wait 200ms
+	decision = limiter.Check(frame)
+	if decision.Action != RateLimitDrop {
+		t.Errorf("Expected drop when delay exceeds max delay, got %+v", decision)
+	}
+
+	stats := limiter.Stats()
+	if stats.Delayed != 1 || stats.Dropped != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+// TestConnRateLimitDelay tests that delayed frames give up on deadline or close
+func TestConnRateLimitDelay(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewConn(server)
+	conn.SetRateLimiter(NewRateLimiter(RateLimiterConfig{
+		Connection: RateLimit{FramesPerSecond: 1, FrameBurst: 1},
+		Action:     RateLimitDelay,
+		MaxDelay:   time.Minute,
+	}))
+	go func() {
+		clientConn := NewConn(client)
+		for i := 0; i < 3; i++ {
+			frame, _ := NewFrame(FrameTypeJSON, []byte("payload"))
+			if err := clientConn.WriteFrame(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, err := conn.ReadFrame(); err != nil {
+		t.Fatalf("Failed to read first frame: %v", err)
+	}
+
+	// The delay is cut short by the read deadline
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := conn.ReadFrame(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Delay ignored the read deadline, returned after %v", elapsed)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := conn.ReadFrame()
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-read:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("Expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Close did not interrupt the rate limit delay")
+	}
+}
+
+// TestConnRateLimitDelayOrder tests that concurrent readers receive frames in order while one of them waits out a delay
+func TestConnRateLimitDelayOrder(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewConn(server)
+	defer conn.Close()
+	conn.SetRateLimiter(NewRateLimiter(RateLimiterConfig{
+		PerType:  map[uint8]RateLimit{FrameTypeJSON: {FramesPerSecond: 5, FrameBurst: 1}},
+		Action:   RateLimitDelay,
+		MaxDelay: time.Minute,
+	}))
+	go func() {
+		clientConn := NewConn(client)
+		for _, frameType := range []uint8{FrameTypeJSON, FrameTypeJSON, FrameTypeProtobuf} {
+			frame, _ := NewFrame(frameType, []byte("payload"))
+			if err := clientConn.WriteFrame(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, err := conn.ReadFrame(); err != nil {
+		t.Fatalf("Failed to read first frame: %v", err)
+	}
+
+	// The first reader waits 200ms for the second JSON frame; the unlimited frame behind it must not overtake it
+	type result struct {
+		frame *Frame
+		err   error
+	}
+	read := func() <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			f, err := conn.ReadFrame()
+			ch <- result{f, err}
+		}()
+		return ch
+	}
+	first := read()
+	time.Sleep(20 * time.Millisecond)
+	second := read()
+
+	select {
+	case r := <-second:
+		t.Fatalf("Second reader returned %v, %v while the first was delayed", r.frame, r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if r := <-first; r.err != nil || r.frame.Type != FrameTypeJSON {
+		t.Fatalf("Expected the delayed JSON frame, got %v, %v", r.frame, r.err)
+	}
+	if r := <-second; r.err != nil || r.frame.Type != FrameTypeProtobuf {
+		t.Fatalf("Expected the protobuf frame, got %v, %v", r.frame, r.err)
+	}
+}
+
+// TestConnRateLimitActions tests rate limit actions enforced by Conn.ReadFrame
+func TestConnRateLimitActions(t *testing.T) {
+	for _, action := range []RateLimitAction{RateLimitDrop, RateLimitErrorFrame, RateLimitDisconnect} {
+		t.Run(action.String(), func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			serverConn := NewConn(server)
+			defer serverConn.Close()
+			serverConn.SetRateLimiter(NewRateLimiter(RateLimiterConfig{
+				PerType: map[uint8]RateLimit{FrameTypeJSON: {FramesPerSecond: 0.001, FrameBurst: 1}},
+				Action:  action,
+			}))
+
+			clientConn := NewConn(client)
+			go func() {
+				for _, frameType := range []uint8{FrameTypeJSON, FrameTypeJSON, FrameTypeProtobuf} {
+					frame, _ := NewFrame(frameType, []byte("payload"))
+					if err := clientConn.WriteFrame(frame); err != nil {
+						return
+					}
+				}
+			}()
+
+			received := make(chan *Frame, 1)
+			go func() {
+				// Error frames sent back by the server end up here
+				if frame, err := clientConn.ReadFrame(); err == nil {
+					received <- frame
+				}
+				close(received)
+			}()
+
+			first, err := serverConn.ReadFrame()
+			if err != nil || first.Type != FrameTypeJSON {
+				t.Fatalf("Expected first JSON frame, got %v, %v", first, err)
+			}
+
+			second, err := serverConn.ReadFrame()
+			if action == RateLimitDisconnect {
+				if !IsRateLimitedError(err) {
+					t.Fatalf("Expected rate limited error, got %v", err)
+				}
+				return
+			}
+			if err != nil || second.Type != FrameTypeProtobuf {
+				t.Fatalf("Expected limited JSON frame to be skipped, got %v, %v", second, err)
+			}
+
+			if action == RateLimitErrorFrame {
+				errFrame := <-received
+				var msg ErrorMessage
+				if errFrame == nil || json.Unmarshal(errFrame.Body, &msg) != nil || msg.Code != ErrCodeRateLimited {
+					t.Errorf("Expected rate limited error frame, got %v", errFrame)
+				}
+			}
+		})
+	}
+}
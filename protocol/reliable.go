@@ -0,0 +1,554 @@
+package protocol
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	mrand "math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 重传策略默认值
+const (
+	// DefaultRetryInitialInterval 首次重传前的等待时间
+	DefaultRetryInitialInterval = time.Second
+	// DefaultRetryMaxInterval 重传间隔上限
+	DefaultRetryMaxInterval = 30 * time.Second
+	// DefaultRetryMultiplier 每次重传后间隔的增长倍数
+	DefaultRetryMultiplier = 2.0
+)
+
+// RetryPolicy 指数退避重传策略，零值字段使用默认值
+type RetryPolicy struct {
+	// InitialInterval 首次重传前的等待时间，默认DefaultRetryInitialInterval
+	InitialInterval time.Duration
+	// MaxInterval 重传间隔上限，默认DefaultRetryMaxInterval
+	MaxInterval time.Duration
+	// Multiplier 每次重传后间隔的增长倍数，默认DefaultRetryMultiplier
+	Multiplier float64
+	// MaxAttempts 最大发送次数（含首次发送），0表示不限制
+	MaxAttempts int
+	// Jitter 随机抖动比例（0~1），实际间隔在interval*(1±Jitter)内均匀分布，避免大量客户端同时重试
+	Jitter float64
+}
+
+// withDefaults 填充默认值
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialInterval <= 0 {
+		p.InitialInterval = DefaultRetryInitialInterval
+	}
+	if p.MaxInterval <= 0 {
+		p.MaxInterval = DefaultRetryMaxInterval
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryMultiplier
+	}
+	return p
+}
+
+// interval 返回第attempts次发送之后到下一次重传的等待时间
+func (p RetryPolicy) interval(attempts int) time.Duration {
+	interval := float64(p.InitialInterval)
+	for i := 1; i < attempts && interval < float64(p.MaxInterval); i++ {
+		interval *= p.Multiplier
+	}
+	return min(time.Duration(interval), p.MaxInterval)
+}
+
+// backoff 返回带随机抖动的第attempts次等待时间
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	interval := p.interval(attempts)
+	if p.Jitter <= 0 {
+		return interval
+	}
+	jitter := min(p.Jitter, 1)
+	return time.Duration(float64(interval) * (1 + jitter*(2*mrand.Float64()-1)))
+}
+
+// NewMessageID 生成随机消息ID（32位十六进制字符串）
+func NewMessageID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// errNotConnected 帧写入器当前没有连接
+var errNotConnected = errors.New("not connected")
+
+// frameWriter 串行化写入帧，保证每帧通过一次Write写出
+// 发件箱和接收端共享同一个frameWriter时，重传帧与确认帧不会交错
+type frameWriter struct {
+	// mu 写锁
+	mu sync.Mutex
+	// w 底层写入器
+	w io.Writer
+	// conn 底层连接，设置时通过Conn写入，沿用其写锁、写超时和编码选项
+	conn *Conn
+	// options 编码选项
+	options []EncodeOption
+}
+
+// write 编码并写入一帧
+func (fw *frameWriter) write(f *Frame) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.conn != nil && fw.options == nil {
+		return fw.conn.WriteFrame(f)
+	}
+	if fw.w == nil && fw.conn == nil {
+		return errNotConnected
+	}
+	data, err := f.Encode(fw.options...)
+	if err != nil {
+		return err
+	}
+	if fw.conn != nil {
+		return fw.conn.WriteEncoded(data)
+	}
+	_, err = fw.w.Write(data)
+	return err
+}
+
+// reset 切换底层写入器，w为nil表示断开
+func (fw *frameWriter) reset(w io.Writer) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.w = w
+	fw.conn = nil
+}
+
+// resetConn 切换底层连接，conn为nil表示断开
+// 未设置编码选项时使用连接自身的编码选项
+func (fw *frameWriter) resetConn(conn *Conn) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.w = nil
+	fw.conn = conn
+}
+
+// setOptions 设置编码选项
+func (fw *frameWriter) setOptions(options []EncodeOption) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.options = options
+}
+
+// OutboxConfig 发件箱配置
+type OutboxConfig struct {
+	// FrameType 消息体格式，默认FrameTypeJSON，必须已注册BodyCodec
+	FrameType uint8
+	// Retry 重传策略
+	Retry RetryPolicy
+	// OnFailed 消息达到最大发送次数仍未确认时的回调，在Retransmit所在的goroutine中调用
+	OnFailed func(id string, msg Message)
+}
+
+// pendingMessage 等待确认的消息
+type pendingMessage struct {
+	// order 发送顺序
+	order uint64
+	// msg 原始消息
+	msg Message
+	// frame 编码后的帧
+	frame *Frame
+	// attempts 已发送次数
+	attempts int
+	// nextRetry 下一次重传的时间
+	nextRetry time.Time
+}
+
+// Outbox 发送端发件箱
+// 为消息分配ID，保留未确认的消息，超时后按指数退避重传，收到Ack后移除
+//
+// 使用示例：
+//
+//	outbox := NewOutbox(conn, OutboxConfig{Retry: RetryPolicy{MaxAttempts: 5}})
+//	go outbox.Run(ctx, 200*time.Millisecond)
+//	id, err := outbox.Send(&ChatMessage{From: "alice", To: "bob", Content: "hi"})
+//	// 读循环中：
+//	if outbox.HandleFrame(frame) {
+//		continue // 确认帧已处理
+//	}
+//
+// 并发安全说明：
+// Outbox 的所有方法均为并发安全
+type Outbox struct {
+	// mu 互斥锁，保护pending和nextOrder
+	mu sync.Mutex
+	// writer 帧写入器
+	writer *frameWriter
+	// config 发件箱配置
+	config OutboxConfig
+	// pending 按消息ID索引的未确认消息
+	pending map[string]*pendingMessage
+	// nextOrder 下一条消息的发送顺序
+	nextOrder uint64
+	// now 时钟，便于测试替换
+	now func() time.Time
+}
+
+// NewOutbox 创建发件箱，帧写入w
+func NewOutbox(w io.Writer, config OutboxConfig) *Outbox {
+	return newOutbox(&frameWriter{w: w}, config)
+}
+
+// newOutbox 使用指定的帧写入器创建发件箱
+func newOutbox(writer *frameWriter, config OutboxConfig) *Outbox {
+	if config.FrameType == 0 {
+		config.FrameType = FrameTypeJSON
+	}
+	config.Retry = config.Retry.withDefaults()
+	return &Outbox{
+		writer:  writer,
+		config:  config,
+		pending: make(map[string]*pendingMessage),
+		now:     time.Now,
+	}
+}
+
+// SetEncodeOptions 设置编码选项（如签名），对之后的发送和重传生效
+func (o *Outbox) SetEncodeOptions(options ...EncodeOption) {
+	o.writer.setOptions(options)
+}
+
+// Send 发送消息并跟踪确认
+// 消息ID为空时自动分配，返回消息ID
+// 写入失败时消息仍保留在发件箱中，等待重传
+func (o *Outbox) Send(msg Message) (string, error) {
+	header := msg.header()
+	if header.ID == "" {
+		header.ID = NewMessageID()
+	}
+	if header.Timestamp == 0 {
+		header.Timestamp = o.now().UnixMilli()
+	}
+
+	frame, err := EncodeMessage(o.config.FrameType, msg)
+	if err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	o.nextOrder++
+	o.pending[header.ID] = &pendingMessage{
+		order:     o.nextOrder,
+		msg:       msg,
+		frame:     frame,
+		attempts:  1,
+		nextRetry: o.now().Add(o.config.Retry.backoff(1)),
+	}
+	o.mu.Unlock()
+
+	return header.ID, o.writer.write(frame)
+}
+
+// HandleAck 处理消息确认，消息在发件箱中时返回true
+func (o *Outbox) HandleAck(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.pending[id]; !ok {
+		return false
+	}
+	delete(o.pending, id)
+	return true
+}
+
+// HandleFrame 检查收到的帧是否为确认帧，是则处理并返回true
+// 非信封消息或非确认消息返回false，调用方应继续处理该帧
+func (o *Outbox) HandleFrame(f *Frame) bool {
+	msg, err := ParseMessage(f)
+	if err != nil {
+		return false
+	}
+	ack, ok := msg.(*Ack)
+	if !ok {
+		return false
+	}
+	o.HandleAck(ack.MessageID)
+	return true
+}
+
+// Retransmit 按发送顺序重传所有到期的未确认消息，返回重传的消息数
+// 达到最大发送次数的消息会被移除并触发OnFailed回调
+func (o *Outbox) Retransmit() (int, error) {
+	now := o.now()
+	var due []*Frame
+	var failed []*pendingMessage
+
+	o.mu.Lock()
+	for _, p := range o.sortedPending() {
+		if now.Before(p.nextRetry) {
+			continue
+		}
+		if o.config.Retry.MaxAttempts > 0 && p.attempts >= o.config.Retry.MaxAttempts {
+			delete(o.pending, p.msg.header().ID)
+			failed = append(failed, p)
+			continue
+		}
+		p.attempts++
+		p.nextRetry = now.Add(o.config.Retry.backoff(p.attempts))
+		due = append(due, p.frame)
+	}
+	o.mu.Unlock()
+
+	if o.config.OnFailed != nil {
+		for _, p := range failed {
+			o.config.OnFailed(p.msg.header().ID, p.msg)
+		}
+	}
+
+	for i, frame := range due {
+		if err := o.writer.write(frame); err != nil {
+			return i, err
+		}
+	}
+	return len(due), nil
+}
+
+// Replay 按发送顺序立即重发所有未确认的消息，并重置它们的重传计时
+// 用于重新建立连接后恢复发送，重发不计入MaxAttempts
+func (o *Outbox) Replay() (int, error) {
+	now := o.now()
+	o.mu.Lock()
+	pending := o.sortedPending()
+	for _, p := range pending {
+		p.nextRetry = now.Add(o.config.Retry.backoff(p.attempts))
+	}
+	o.mu.Unlock()
+
+	for i, p := range pending {
+		if err := o.writer.write(p.frame); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// Run 按interval周期性重传到期消息，直到ctx取消或写入失败
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := o.Retransmit(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Pending 按发送顺序返回所有未确认的消息
+func (o *Outbox) Pending() []Message {
+	o.mu.Lock()
+	pending := o.sortedPending()
+	o.mu.Unlock()
+
+	messages := make([]Message, len(pending))
+	for i, p := range pending {
+		messages[i] = p.msg
+	}
+	return messages
+}
+
+// sortedPending 按发送顺序返回未确认的消息
+// 调用方必须持有锁
+func (o *Outbox) sortedPending() []*pendingMessage {
+	pending := make([]*pendingMessage, 0, len(o.pending))
+	for _, p := range o.pending {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].order < pending[j].order })
+	return pending
+}
+
+// Len 返回未确认的消息数
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// ReceiverConfig 接收端配置
+type ReceiverConfig struct {
+	// DedupCapacity 记录的消息ID数量上限，默认DefaultDedupCapacity
+	DedupCapacity int
+	// DedupTTL 消息ID的保留时间，默认DefaultDedupTTL
+	DedupTTL time.Duration
+	// Dedup 自定义去重缓存（如启用布隆过滤器），设置后忽略DedupCapacity和DedupTTL
+	Dedup *DedupCache
+}
+
+// Receiver 接收端确认生成器与去重器
+// 对每条带ID的消息回复Ack（重复消息也回复，因为之前的Ack可能已丢失），
+// 并在保留期内识别重复的消息ID
+//
+// 并发安全说明：
+// Receiver 的所有方法均为并发安全
+type Receiver struct {
+	// writer 帧写入器
+	writer *frameWriter
+	// dedup 去重缓存
+	dedup *DedupCache
+}
+
+// NewReceiver 创建接收端，确认帧写入w
+func NewReceiver(w io.Writer, config ReceiverConfig) *Receiver {
+	return newReceiver(&frameWriter{w: w}, config)
+}
+
+// newReceiver 使用指定的帧写入器创建接收端
+func newReceiver(writer *frameWriter, config ReceiverConfig) *Receiver {
+	dedup := config.Dedup
+	if dedup == nil {
+		dedup = NewDedupCache(DedupConfig{Capacity: config.DedupCapacity, TTL: config.DedupTTL})
+	}
+	return &Receiver{writer: writer, dedup: dedup}
+}
+
+// Dedup 返回接收端使用的去重缓存，可用于读取统计
+func (r *Receiver) Dedup() *DedupCache {
+	return r.dedup
+}
+
+// Accept 解析收到的帧，回复确认并检查重复
+//
+// 返回值：
+//
+//	msg - 解析出的消息
+//	duplicate - 消息ID在保留期内已出现过，调用方应丢弃该消息
+//	err - 解析或回复确认失败
+//
+// 回复确认失败时仍返回msg和duplicate，调用方应照常投递未重复的消息：
+// 消息ID已记录，发送方未收到确认而重传时会作为重复消息再次确认，不会重复投递
+//
+// Ack消息和没有ID的消息不回复确认，也不参与去重
+func (r *Receiver) Accept(f *Frame) (msg Message, duplicate bool, err error) {
+	msg, err = ParseMessage(f)
+	if err != nil {
+		return nil, false, err
+	}
+	id := msg.header().ID
+	if _, isAck := msg.(*Ack); isAck || id == "" {
+		return msg, false, nil
+	}
+
+	duplicate = r.dedup.Seen(id)
+
+	// 确认帧使用与收到的消息相同的消息体格式
+	ackFrame, err := EncodeMessage(f.Type, &Ack{MessageID: id})
+	if err != nil {
+		return msg, duplicate, err
+	}
+	return msg, duplicate, r.writer.write(ackFrame)
+}
+
+// ReliableConfig 可靠传输配置
+type ReliableConfig struct {
+	// Outbox 发件箱配置
+	Outbox OutboxConfig
+	// Receiver 接收端配置
+	Receiver ReceiverConfig
+}
+
+// ReliableConn 基于任意io.ReadWriter的可靠消息传输
+// 组合Outbox和Receiver：发送的消息在确认前自动重传，收到的消息自动确认并去重
+//
+// 使用示例：
+//
+//	rc := NewReliableConn(conn, ReliableConfig{})
+//	go rc.Run(ctx, 200*time.Millisecond)
+//	rc.Send(&ChatMessage{From: "alice", To: "bob", Content: "hi"})
+//	for {
+//		msg, err := rc.ReadMessage()
+//		if err != nil {
+//			break
+//		}
+//		// 处理msg
+//	}
+//
+// 并发安全说明：
+// Send、Run可与ReadMessage并发调用，ReadMessage本身只能在一个goroutine中调用
+type ReliableConn struct {
+	// rw 底层连接
+	rw io.ReadWriter
+	// decoder 流式解码器
+	decoder *StreamDecoder
+	// outbox 发件箱
+	outbox *Outbox
+	// receiver 接收端
+	receiver *Receiver
+}
+
+// NewReliableConn 创建可靠消息传输
+func NewReliableConn(rw io.ReadWriter, config ReliableConfig) *ReliableConn {
+	writer := &frameWriter{w: rw}
+	return &ReliableConn{
+		rw:       rw,
+		decoder:  NewStreamDecoder(),
+		outbox:   newOutbox(writer, config.Outbox),
+		receiver: newReceiver(writer, config.Receiver),
+	}
+}
+
+// Outbox 返回发件箱
+func (c *ReliableConn) Outbox() *Outbox {
+	return c.outbox
+}
+
+// Receiver 返回接收端
+func (c *ReliableConn) Receiver() *Receiver {
+	return c.receiver
+}
+
+// Decoder 返回流式解码器，可用于设置解码选项和慢速攻击防护
+func (c *ReliableConn) Decoder() *StreamDecoder {
+	return c.decoder
+}
+
+// SetEncodeOptions 设置编码选项（如签名），同时作用于消息和确认帧
+func (c *ReliableConn) SetEncodeOptions(options ...EncodeOption) {
+	c.outbox.SetEncodeOptions(options...)
+}
+
+// Send 发送消息并跟踪确认，返回消息ID
+func (c *ReliableConn) Send(msg Message) (string, error) {
+	return c.outbox.Send(msg)
+}
+
+// Run 周期性重传到期消息，直到ctx取消或写入失败
+func (c *ReliableConn) Run(ctx context.Context, interval time.Duration) error {
+	return c.outbox.Run(ctx, interval)
+}
+
+// ReadMessage 读取下一条消息
+// 确认帧由发件箱消费，重复消息被确认后丢弃，均不会返回给调用方
+func (c *ReliableConn) ReadMessage() (Message, error) {
+	for {
+		f, err := c.decoder.ReadFrame(c.rw)
+		if err != nil {
+			return nil, err
+		}
+		if c.outbox.HandleFrame(f) {
+			continue
+		}
+
+		msg, duplicate, err := c.receiver.Accept(f)
+		if msg == nil {
+			return nil, err
+		}
+		// 确认写入失败不影响投递，发送方会重传并再次得到确认
+		if !duplicate {
+			return msg, nil
+		}
+	}
+}
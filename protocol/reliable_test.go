@@ -0,0 +1,337 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// decodeAll decodes every frame written to buf
+func decodeAll(t *testing.T, buf *bytes.Buffer) []Message {
+	t.Helper()
+	decoder := NewStreamDecoder()
+	var messages []Message
+	for {
+		f, err := decoder.ReadFrame(buf)
+		if err != nil {
+			return messages
+		}
+		msg, err := ParseMessage(f)
+		if err != nil {
+			t.Fatalf("Failed to parse message: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+}
+
+// TestRetryPolicyInterval tests exponential backoff capped at the max interval
+func TestRetryPolicyInterval(t *testing.T) {
+	policy := RetryPolicy{InitialInterval: time.Second, MaxInterval: 5 * time.Second}.withDefaults()
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := policy.interval(i + 1); got != want {
+			t.Errorf("Attempt %d: expected %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+// TestOutboxRetransmit tests retransmission with backoff until acknowledged
+func TestOutboxRetransmit(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	outbox := NewOutbox(&buf, OutboxConfig{Retry: RetryPolicy{InitialInterval: time.Second}})
+	outbox.now = clock.Now
+
+	id, err := outbox.Send(&ChatMessage{From: "alice", To: "bob", Content: "hi"})
+	if err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if id == "" {
+		t.Fatal("Expected message ID to be assigned")
+	}
+
+	// Not due yet
+	if n, err := outbox.Retransmit(); n != 0 || err != nil {
+		t.Fatalf("Expected no retransmission, got %d, %v", n, err)
+	}
+
+	clock.Advance(time.Second)
+	if n, err := outbox.Retransmit(); n != 1 || err != nil {
+		t.Fatalf("Expected 1 retransmission, got %d, %v", n, err)
+	}
+
+	// Backoff doubled: 1s later is not enough
+	clock.Advance(time.Second)
+	if n, _ := outbox.Retransmit(); n != 0 {
+		t.Errorf("Expected backoff to delay retransmission, got %d", n)
+	}
+	clock.Advance(time.Second)
+	if n, _ := outbox.Retransmit(); n != 1 {
+		t.Errorf("Expected retransmission after backoff, got %d", n)
+	}
+
+	messages := decodeAll(t, &buf)
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 transmissions, got %d", len(messages))
+	}
+	for _, msg := range messages {
+		if msg.header().ID != id {
+			t.Errorf("Expected retransmission with ID %s, got %s", id, msg.header().ID)
+		}
+	}
+
+	ack, err := EncodeMessage(FrameTypeJSON, &Ack{MessageID: id})
+	if err != nil {
+		t.Fatalf("Failed to encode ack: %v", err)
+	}
+	if !outbox.HandleFrame(ack) {
+		t.Fatal("Expected ack frame to be handled")
+	}
+	if outbox.Len() != 0 {
+		t.Errorf("Expected empty outbox after ack, got %d", outbox.Len())
+	}
+	clock.Advance(time.Minute)
+	if n, _ := outbox.Retransmit(); n != 0 {
+		t.Errorf("Expected no retransmission after ack, got %d", n)
+	}
+}
+
+// TestOutboxRetransmitOrder tests that due messages are retransmitted in send order
+func TestOutboxRetransmitOrder(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	outbox := NewOutbox(&buf, OutboxConfig{Retry: RetryPolicy{InitialInterval: time.Second}})
+	outbox.now = clock.Now
+
+	var ids []string
+	for i := 0; i < 20; i++ {
+		id, err := outbox.Send(&ChatMessage{From: "alice", To: "bob", Content: "hi"})
+		if err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	buf.Reset()
+
+	clock.Advance(time.Minute)
+	if n, err := outbox.Retransmit(); n != len(ids) || err != nil {
+		t.Fatalf("Expected %d retransmissions, got %d, %v", len(ids), n, err)
+	}
+	for i, msg := range decodeAll(t, &buf) {
+		if msg.header().ID != ids[i] {
+			t.Fatalf("Retransmission %d: expected %s, got %s", i, ids[i], msg.header().ID)
+		}
+	}
+}
+
+// TestOutboxRetryJitter tests that retransmission deadlines are spread by the retry jitter
+func TestOutboxRetryJitter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	outbox := NewOutbox(io.Discard, OutboxConfig{Retry: RetryPolicy{InitialInterval: time.Second, Jitter: 0.5}})
+	outbox.now = clock.Now
+
+	retries := make(map[time.Time]bool)
+	for i := 0; i < 10; i++ {
+		id, err := outbox.Send(&ChatMessage{From: "alice", To: "bob", Content: "hi"})
+		if err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		next := outbox.pending[id].nextRetry
+		if d := next.Sub(clock.now); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Retry after %v is outside the jitter range", d)
+		}
+		retries[next] = true
+	}
+	if len(retries) == 1 {
+		t.Errorf("Expected jittered retry deadlines, all were equal")
+	}
+}
+
+// TestOutboxMaxAttempts tests that messages are given up after the max attempts
+func TestOutboxMaxAttempts(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var failed []string
+	outbox := NewOutbox(&buf, OutboxConfig{
+		Retry:    RetryPolicy{InitialInterval: time.Second, MaxAttempts: 2},
+		OnFailed: func(id string, msg Message) { failed = append(failed, id) },
+	})
+	outbox.now = clock.Now
+
+	first, _ := outbox.Send(&ChatMessage{Content: "1"})
+	second, _ := outbox.Send(&ChatMessage{Content: "2"})
+	pending := outbox.Pending()
+	if len(pending) != 2 || pending[0].header().ID != first || pending[1].header().ID != second {
+		t.Fatalf("Expected pending messages in send order, got %v", pending)
+	}
+
+	clock.Advance(time.Second)
+	outbox.Retransmit()
+	clock.Advance(time.Hour)
+	if n, _ := outbox.Retransmit(); n != 0 {
+		t.Errorf("Expected no retransmission after max attempts, got %d", n)
+	}
+	if len(failed) != 2 || outbox.Len() != 0 {
+		t.Errorf("Expected 2 failed messages and empty outbox, got %v, %d", failed, outbox.Len())
+	}
+}
+
+// TestReceiverAckAndDedup tests ack generation and duplicate detection
+func TestReceiverAckAndDedup(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	receiver := NewReceiver(&buf, ReceiverConfig{DedupCapacity: 2, DedupTTL: time.Minute})
+	receiver.dedup.now = clock.Now
+
+	frameFor := func(id string) *Frame {
+		f, err := EncodeMessage(FrameTypeJSON, &ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: id}, Content: id})
+		if err != nil {
+			t.Fatalf("Failed to encode message: %v", err)
+		}
+		return f
+	}
+
+	steps := []struct {
+		id        string
+		duplicate bool
+	}{
+		{"a", false},
+		{"a", true},
+		{"b", false},
+		{"c", false}, // evicts "a" by capacity
+		{"a", false},
+	}
+	for i, step := range steps {
+		_, duplicate, err := receiver.Accept(frameFor(step.id))
+		if err != nil {
+			t.Fatalf("Step %d: failed to accept: %v", i, err)
+		}
+		if duplicate != step.duplicate {
+			t.Errorf("Step %d (%s): expected duplicate=%v, got %v", i, step.id, step.duplicate, duplicate)
+		}
+	}
+
+	// Expired IDs are no longer duplicates
+	clock.Advance(time.Minute)
+	if _, duplicate, _ := receiver.Accept(frameFor("c")); duplicate {
+		t.Error("Expected expired ID not to be a duplicate")
+	}
+
+	// Every message, including duplicates, is acknowledged
+	acks := decodeAll(t, &buf)
+	if len(acks) != len(steps)+1 {
+		t.Fatalf("Expected %d acks, got %d", len(steps)+1, len(acks))
+	}
+	if ack, ok := acks[1].(*Ack); !ok || ack.MessageID != "a" {
+		t.Errorf("Expected ack for duplicate message, got %#v", acks[1])
+	}
+}
+
+// flakyReadWriter reads from a fixed buffer and fails the first failWrites writes
+type flakyReadWriter struct {
+	bytes.Buffer
+	failWrites int
+	written    bytes.Buffer
+}
+
+func (rw *flakyReadWriter) Write(p []byte) (int, error) {
+	if rw.failWrites > 0 {
+		rw.failWrites--
+		return 0, errors.New("write failed")
+	}
+	return rw.written.Write(p)
+}
+
+// TestReliableConnAckWriteFailure tests that a message whose ack could not be written is delivered exactly once
+func TestReliableConnAckWriteFailure(t *testing.T) {
+	f, err := EncodeMessage(FrameTypeJSON, &ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: "m1"}, Content: "hello"})
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	data, _ := f.Encode()
+	// The original transmission followed by the sender's retransmission
+	rw := &flakyReadWriter{failWrites: 1}
+	rw.Buffer.Write(data)
+	rw.Buffer.Write(data)
+
+	rc := NewReliableConn(rw, ReliableConfig{})
+	var delivered int
+	for {
+		msg, err := rc.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("Failed to read message: %v", err)
+			}
+			break
+		}
+		if msg.header().ID != "m1" {
+			t.Fatalf("Unexpected message: %#v", msg)
+		}
+		delivered++
+	}
+	if delivered != 1 {
+		t.Fatalf("Expected the message to be delivered once, got %d", delivered)
+	}
+	if acks := decodeAll(t, &rw.written); len(acks) != 1 {
+		t.Fatalf("Expected the retransmission to be acknowledged, got %d acks", len(acks))
+	}
+}
+
+// TestReliableConnLoss tests end-to-end delivery when the first transmission is lost
+func TestReliableConnLoss(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	sender := NewReliableConn(clientConn, ReliableConfig{})
+	receiver := NewReliableConn(serverConn, ReliableConfig{})
+
+	// Simulate loss: track a message in the outbox without it reaching the peer
+	clock := &fakeClock{now: time.Now()}
+	sender.outbox.now = clock.Now
+	lost := &ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: "m1"}, Content: "hello"}
+	sender.outbox.writer = &frameWriter{w: &bytes.Buffer{}}
+	if _, err := sender.Send(lost); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	sender.outbox.writer = &frameWriter{w: clientConn}
+
+	done := make(chan Message, 1)
+	go func() {
+		msg, err := receiver.ReadMessage()
+		if err != nil {
+			t.Errorf("Failed to read message: %v", err)
+		}
+		done <- msg
+	}()
+
+	clock.Advance(DefaultRetryInitialInterval)
+	go func() {
+		if _, err := sender.outbox.Retransmit(); err != nil {
+			t.Errorf("Failed to retransmit: %v", err)
+		}
+	}()
+
+	// The sender's read loop consumes the ack
+	go sender.ReadMessage()
+
+	select {
+	case msg := <-done:
+		if chat, ok := msg.(*ChatMessage); !ok || chat.Content != "hello" {
+			t.Fatalf("Unexpected message: %#v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for retransmitted message")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for sender.outbox.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sender.outbox.Len() != 0 {
+		t.Error("Expected outbox to be empty after ack")
+	}
+}
@@ -0,0 +1,163 @@
+package protocol
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 序列号相关常量
+const (
+	// SequenceLength 序列号长度：8字节，大端序
+	SequenceLength = 8
+	// DefaultReplayWindowSize 默认重放窗口大小（可容忍的乱序跨度）
+	DefaultReplayWindowSize = 1024
+)
+
+// Sequencer 发送方序列号生成器
+// 每个连接使用独立的Sequencer，序列号从1开始单调递增
+//
+// 序列号帧格式：
+// +--------+--------+--------+--------+--------+--------+--------+--------+--------+
+// | 版本号 | 子版本号 | 消息类型|FrameFlagSequenced |     消息体长度（含序列号）     |
+// +--------+--------+--------+--------+--------+--------+--------+--------+--------+
+// |      序列号 (8字节，大端序)       |              消息体 (可变长度)              |
+// +--------+--------+--------+--------+--------+--------+--------+--------+--------+
+//
+// 与WithSigner同时使用时，签名覆盖序列号，防止序列号被篡改
+//
+// 并发安全说明：
+// Sequencer 的所有方法均为并发安全
+type Sequencer struct {
+	// last 最近一次分配的序列号
+	last atomic.Uint64
+}
+
+// NewSequencer 创建序列号生成器
+func NewSequencer() *Sequencer {
+	return &Sequencer{}
+}
+
+// Next 分配下一个序列号
+func (s *Sequencer) Next() uint64 {
+	return s.last.Add(1)
+}
+
+// Stamp 为帧分配序列号，编码时会写入帧中
+func (s *Sequencer) Stamp(f *Frame) {
+	f.Sequence = s.Next()
+}
+
+// ReplayWindow 接收方滑动窗口重放检测器
+// 记录最近窗口大小范围内已接收的序列号，允许窗口内的乱序到达
+//
+// 拒绝规则：
+//   - 帧未携带序列号（序列号为0）
+//   - 序列号落后最大已接收序列号超过窗口大小（过旧）
+//   - 序列号在窗口内且已接收过（重复）
+//
+// 并发安全说明：
+// ReplayWindow 的所有方法均为并发安全，但通常每个连接使用独立实例
+type ReplayWindow struct {
+	// mu 互斥锁，保护窗口状态
+	mu sync.Mutex
+	// size 窗口大小（位数）
+	size uint64
+	// highest 已接收的最大序列号
+	highest uint64
+	// bitmap 窗口位图，第 seq%size 位表示序列号seq是否已接收
+	bitmap []uint64
+}
+
+// NewReplayWindow 创建重放检测窗口
+// size: 窗口大小，默认为DefaultReplayWindowSize，会向上取整为64的倍数
+func NewReplayWindow(size ...int) *ReplayWindow {
+	windowSize := DefaultReplayWindowSize
+	if len(size) > 0 && size[0] > 0 {
+		windowSize = size[0]
+	}
+	words := (windowSize + 63) / 64
+
+	return &ReplayWindow{
+		size:   uint64(words * 64),
+		bitmap: make([]uint64, words),
+	}
+}
+
+// Check 检查序列号是否为重放，未重放时将其记录到窗口中
+// 重放时返回ErrCodeReplayDetected错误
+func (w *ReplayWindow) Check(sequence uint64) error {
+	if sequence == 0 {
+		return NewReplayError(sequence, "is missing")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if sequence > w.highest {
+		// 窗口前移，清除被滑过的位
+		shift := sequence - w.highest
+		if shift >= w.size {
+			clear(w.bitmap)
+		} else {
+			for seq := w.highest + 1; seq < sequence; seq++ {
+				w.clearBit(seq)
+			}
+		}
+		w.highest = sequence
+		w.setBit(sequence)
+		return nil
+	}
+
+	if w.highest-sequence >= w.size {
+		return NewReplayError(sequence, "is too old")
+	}
+	if w.testBit(sequence) {
+		return NewReplayError(sequence, "is duplicated")
+	}
+	w.setBit(sequence)
+	return nil
+}
+
+// Highest 返回已接收的最大序列号
+func (w *ReplayWindow) Highest() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.highest
+}
+
+// Reset 清空窗口状态，用于新的连接
+func (w *ReplayWindow) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.highest = 0
+	clear(w.bitmap)
+}
+
+func (w *ReplayWindow) setBit(seq uint64) {
+	idx := seq % w.size
+	w.bitmap[idx/64] |= 1 << (idx % 64)
+}
+
+func (w *ReplayWindow) clearBit(seq uint64) {
+	idx := seq % w.size
+	w.bitmap[idx/64] &^= 1 << (idx % 64)
+}
+
+func (w *ReplayWindow) testBit(seq uint64) bool {
+	idx := seq % w.size
+	return w.bitmap[idx/64]&(1<<(idx%64)) != 0
+}
+
+// 重放检测选项实现
+type replayWindowOption struct {
+	window *ReplayWindow
+}
+
+func (o *replayWindowOption) isDecodeOption() {}
+
+// WithReplayWindow 使用滑动窗口检测重放帧
+// 解码期选项，用于Decode和StreamDecoder.SetDecodeOptions
+// 未携带序列号、重复或过旧的帧会被拒绝，返回ErrCodeReplayDetected错误
+func WithReplayWindow(window *ReplayWindow) DecodeOption {
+	return &replayWindowOption{window: window}
+}
@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSequencedFrameEncodeDecode tests stamping and decoding sequence numbers
+func TestSequencedFrameEncodeDecode(t *testing.T) {
+	sequencer := NewSequencer()
+
+	frame, err := NewFrame(FrameTypeJSON, []byte("login"))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+	sequencer.Stamp(frame)
+
+	if frame.Sequence != 1 {
+		t.Errorf("Expected first sequence 1, got %d", frame.Sequence)
+	}
+
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	if data[2] != FrameTypeJSON|FrameFlagSequenced {
+		t.Errorf("Expected sequenced flag in type byte, got %#x", data[2])
+	}
+
+	if len(data) != FrameHeaderLength+SequenceLength+len(frame.Body) {
+		t.Errorf("Unexpected sequenced frame length %d", len(data))
+	}
+
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+
+	if decoded.Sequence != 1 || !bytes.Equal(decoded.Body, frame.Body) {
+		t.Errorf("Unexpected decoded frame: %v (sequence %d)", decoded, decoded.Sequence)
+	}
+
+	// EncodeTo must produce identical bytes
+	var buf bytes.Buffer
+	if _, err := frame.EncodeTo(&buf); err != nil {
+		t.Fatalf("Failed to encode frame to writer: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("EncodeTo output differs from Encode output")
+	}
+
+	// Signature covers the sequence number
+	signer, err := NewFrameSigner(1, []byte("key"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	signed, err := frame.Encode(WithSigner(signer))
+	if err != nil {
+		t.Fatalf("Failed to encode signed frame: %v", err)
+	}
+	signed[FrameHeaderLength+SequenceLength-1] = 2
+	if _, err := Decode(signed, WithSignatureVerifier(signer)); !IsSignatureError(err) {
+		t.Errorf("Expected signature error for tampered sequence, got %v", err)
+	}
+}
+
+// TestReplayWindow tests duplicate, too-old and out-of-order sequence handling
+func TestReplayWindow(t *testing.T) {
+	window := NewReplayWindow(64)
+
+	for _, seq := range []uint64{1, 2, 5, 3} {
+		if err := window.Check(seq); err != nil {
+			t.Fatalf("Unexpected error for sequence %d: %v", seq, err)
+		}
+	}
+
+	if window.Highest() != 5 {
+		t.Errorf("Expected highest sequence 5, got %d", window.Highest())
+	}
+
+	// Duplicates
+	for _, seq := range []uint64{1, 3, 5} {
+		if err := window.Check(seq); !IsReplayError(err) {
+			t.Errorf("Expected replay error for duplicate sequence %d, got %v", seq, err)
+		}
+	}
+
+	// Missing sequence
+	if err := window.Check(0); !IsReplayError(err) {
+		t.Errorf("Expected replay error for missing sequence, got %v", err)
+	}
+
+	// Gap inside the window is still accepted
+	if err := window.Check(4); err != nil {
+		t.Errorf("Unexpected error for in-window sequence 4: %v", err)
+	}
+
+	// Advance the window so that early sequences become too old
+	if err := window.Check(100); err != nil {
+		t.Fatalf("Unexpected error for sequence 100: %v", err)
+	}
+	if err := window.Check(6); !IsReplayError(err) {
+		t.Errorf("Expected replay error for too-old sequence, got %v", err)
+	}
+	if err := window.Check(99); err != nil {
+		t.Errorf("Unexpected error for in-window sequence 99: %v", err)
+	}
+
+	window.Reset()
+	if err := window.Check(1); err != nil {
+		t.Errorf("Unexpected error after reset: %v", err)
+	}
+}
+
+// TestStreamDecoderReplayProtection tests rejecting replayed frames in TryDecode
+func TestStreamDecoderReplayProtection(t *testing.T) {
+	sequencer := NewSequencer()
+
+	frame, err := NewFrame(FrameTypeJSON, []byte("send message"))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+	sequencer.Stamp(frame)
+
+	data, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	decoder := NewStreamDecoder()
+	decoder.SetDecodeOptions(WithReplayWindow(NewReplayWindow()))
+
+	// The captured frame is replayed right after the original
+	if err := decoder.Feed(append(append([]byte(nil), data...), data...)); err != nil {
+		t.Fatalf("Failed to feed data: %v", err)
+	}
+
+	decoded, err := decoder.TryDecode()
+	if err != nil || decoded == nil {
+		t.Fatalf("Expected original frame, got %v, %v", decoded, err)
+	}
+
+	if _, err := decoder.TryDecode(); !IsReplayError(err) {
+		t.Errorf("Expected replay error for replayed frame, got %v", err)
+	}
+}
@@ -0,0 +1,522 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务端错误
+var (
+	// ErrServerClosed Serve在Shutdown或Close之后返回的错误
+	ErrServerClosed = errors.New("protocol: server closed")
+	// ErrTooManyConnections 连接数达到上限时拒绝新连接的原因
+	ErrTooManyConnections = errors.New("protocol: too many connections")
+)
+
+// shutdownPollInterval Shutdown检查连接是否已排空的间隔
+const shutdownPollInterval = 20 * time.Millisecond
+
+// DefaultServerAuthTimeout 连接完成认证的默认时限
+const DefaultServerAuthTimeout = 10 * time.Second
+
+// FrameWriter 向对端写入帧，*Conn实现了该接口
+type FrameWriter interface {
+	// WriteFrame 编码并写入一个帧
+	WriteFrame(f *Frame) error
+}
+
+// Handler 帧处理器
+// 同一连接上的帧按到达顺序串行处理，返回的错误会以错误帧通知对端，连接继续处理后续帧
+type Handler interface {
+	// ServeFrame 处理一个帧，w用于回复对端
+	ServeFrame(ctx context.Context, w FrameWriter, f *Frame) error
+}
+
+// HandlerFunc 将普通函数适配为Handler
+type HandlerFunc func(ctx context.Context, w FrameWriter, f *Frame) error
+
+// ServeFrame 实现Handler接口
+func (fn HandlerFunc) ServeFrame(ctx context.Context, w FrameWriter, f *Frame) error {
+	return fn(ctx, w, f)
+}
+
+// Middleware 处理器中间件，包装Handler以实现横切逻辑
+type Middleware func(next Handler) Handler
+
+// Chain 按顺序组合中间件，第一个中间件位于最外层
+//
+// 使用示例：
+//
+//	h := Chain(Logging(logger), Recovery())(handler)
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// connContextKey 用于在context中存放*Conn的键
+type connContextKey struct{}
+
+// ContextWithConn 返回携带连接的context
+func ContextWithConn(ctx context.Context, c *Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// ConnFromContext 从context中取出连接，不存在时返回nil
+func ConnFromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(connContextKey{}).(*Conn)
+	return c
+}
+
+// ServerConfig 服务端配置
+type ServerConfig struct {
+	// MaxConnections 最大并发连接数，0表示不限制；超出时新连接被立即关闭
+	MaxConnections int
+	// Authenticators 认证器，非空时每个连接在处理业务帧前必须完成认证
+	Authenticators []Authenticator
+	// Sessions 会话存储，设置后认证成功时签发会话令牌并接受会话恢复
+	Sessions *SessionStore
+	// AuthTimeout 连接必须在该时限内完成TLS握手和认证，否则关闭连接，默认DefaultServerAuthTimeout
+	AuthTimeout time.Duration
+	// ConfigureConn 连接建立后、开始读取前的回调，可用于设置限流器、慢速攻击防护、签名等
+	ConfigureConn func(c *Conn)
+	// ConnContext 为每个连接派生context的回调，可用于注入连接级的值
+	ConnContext func(ctx context.Context, c *Conn) context.Context
+	// OnError 连接级错误（读取失败、认证失败、处理器错误、拒绝连接）的回调
+	OnError func(c *Conn, err error)
+	// OnConnect 连接认证完成（未配置认证器时为接受连接后）、开始处理帧之前的回调
+	// 可用于登记在线状态等，返回错误时拒绝连接
+	OnConnect func(c *Conn) error
+	// OnClose 已接受的连接关闭后的回调，可用于清理订阅、在线状态等连接级资源
+	OnClose func(c *Conn)
+}
+
+// Server 按帧类型路由的服务端框架
+// 负责接受连接、为每个连接启动协程、执行认证、解码帧并交给对应的Handler处理
+//
+// 使用示例：
+//
+//	srv := NewServer(ServerConfig{MaxConnections: 10000})
+//	srv.Use(Recovery())
+//	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+//		return w.WriteFrame(f) // echo
+//	})
+//	go srv.ListenAndServe("tcp", ":9000", nil)
+//	...
+//	srv.Shutdown(ctx)
+//
+// 并发安全说明：
+// 所有方法均为并发安全；Handle和Use应在Serve之前调用
+type Server struct {
+	// config 服务端配置
+	config ServerConfig
+
+	// mu 保护以下字段
+	mu sync.Mutex
+	// handlers 帧类型到处理器的映射
+	handlers map[uint8]Handler
+	// defaultHandler 没有对应帧类型处理器时使用的处理器
+	defaultHandler Handler
+	// middlewares 全局中间件
+	middlewares []Middleware
+	// listeners 正在服务的监听器
+	listeners map[*Listener]struct{}
+	// conns 活跃连接
+	conns map[*serverConn]struct{}
+
+	// inShutdown 是否已开始关闭
+	inShutdown atomic.Bool
+	// wg 等待所有连接协程退出
+	wg sync.WaitGroup
+}
+
+// serverConn 服务端的一个连接
+type serverConn struct {
+	// conn 连接
+	conn *Conn
+	// handling 是否正在处理帧
+	handling atomic.Bool
+}
+
+// NewServer 创建服务端
+func NewServer(config ServerConfig) *Server {
+	if config.AuthTimeout <= 0 {
+		config.AuthTimeout = DefaultServerAuthTimeout
+	}
+	return &Server{
+		config:    config,
+		handlers:  make(map[uint8]Handler),
+		listeners: make(map[*Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+	}
+}
+
+// Handle 注册帧类型的处理器，重复注册会覆盖
+func (s *Server) Handle(frameType uint8, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[frameType] = h
+}
+
+// HandleFunc 注册帧类型的处理函数
+func (s *Server) HandleFunc(frameType uint8, fn func(ctx context.Context, w FrameWriter, f *Frame) error) {
+	s.Handle(frameType, HandlerFunc(fn))
+}
+
+// HandleDefault 注册没有对应帧类型处理器时使用的处理器
+// 未注册时这类帧会以ErrCodeInvalidFrameType错误帧回复
+func (s *Server) HandleDefault(h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultHandler = h
+}
+
+// Use 添加全局中间件，作用于所有处理器，先添加的位于外层
+func (s *Server) Use(middlewares ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// ConnCount 返回当前活跃连接数
+func (s *Server) ConnCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// ListenAndServe 在指定地址上监听并服务，config为nil时使用明文TCP
+func (s *Server) ListenAndServe(network, addr string, config *tls.Config) error {
+	if s.inShutdown.Load() {
+		return ErrServerClosed
+	}
+	ln, err := Listen(network, addr, config)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve 在监听器上接受连接并服务，直到监听器出错或服务端关闭
+// 服务端关闭时返回ErrServerClosed
+func (s *Server) Serve(ln *Listener) error {
+	if !s.trackListener(ln, true) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(ln, false)
+
+	handler := s.buildHandler()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.inShutdown.Load() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		sc := &serverConn{conn: conn}
+		if !s.trackConn(sc) {
+			s.reportError(conn, ErrTooManyConnections)
+			conn.Close()
+			continue
+		}
+		go s.serveConn(sc, handler)
+	}
+}
+
+// trackListener 登记或注销监听器，服务端已关闭时登记失败
+func (s *Server) trackListener(ln *Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.listeners, ln)
+		return true
+	}
+	if s.inShutdown.Load() {
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+// trackConn 登记连接，服务端已关闭或连接数达到上限时返回false
+func (s *Server) trackConn(sc *serverConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown.Load() {
+		return false
+	}
+	if s.config.MaxConnections > 0 && len(s.conns) >= s.config.MaxConnections {
+		return false
+	}
+	s.conns[sc] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// buildHandler 组合路由与全局中间件
+func (s *Server) buildHandler() Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Chain(s.middlewares...)(HandlerFunc(s.route))
+}
+
+// route 按帧类型分发到处理器
+func (s *Server) route(ctx context.Context, w FrameWriter, f *Frame) error {
+	s.mu.Lock()
+	h, ok := s.handlers[f.Type]
+	if !ok {
+		h = s.defaultHandler
+	}
+	s.mu.Unlock()
+
+	if h == nil {
+		return NewInvalidFrameTypeError(f.Type, s.registeredTypes())
+	}
+	return h.ServeFrame(ctx, w, f)
+}
+
+// registeredTypes 返回已注册处理器的帧类型
+func (s *Server) registeredTypes() []uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := make([]uint8, 0, len(s.handlers))
+	for frameType := range s.handlers {
+		types = append(types, frameType)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// serveConn 服务一个连接，直到连接关闭或服务端关闭
+func (s *Server) serveConn(sc *serverConn, handler Handler) {
+	conn := sc.conn
+	defer func() {
+		conn.Close()
+		if s.config.OnClose != nil {
+			s.config.OnClose(conn)
+		}
+		s.mu.Lock()
+		delete(s.conns, sc)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = ContextWithConn(ctx, conn)
+	if s.config.ConnContext != nil {
+		ctx = s.config.ConnContext(ctx, conn)
+	}
+	if s.config.ConfigureConn != nil {
+		s.config.ConfigureConn(conn)
+	}
+
+	// TLS握手与认证共用AuthTimeout，未配置认证器时同样限制握手时间
+	setupDeadline := time.Now().Add(s.config.AuthTimeout)
+	handshakeCtx, cancelHandshake := context.WithDeadline(ctx, setupDeadline)
+	err := conn.Handshake(handshakeCtx)
+	cancelHandshake()
+	if err != nil {
+		if !s.inShutdown.Load() {
+			s.reportError(conn, err)
+		}
+		return
+	}
+
+	if len(s.config.Authenticators) > 0 {
+		principal, err := s.authenticate(ctx, conn, setupDeadline)
+		if err != nil {
+			if !s.inShutdown.Load() {
+				s.reportError(conn, err)
+			}
+			return
+		}
+		conn.SetPrincipal(principal)
+		ctx = ContextWithPrincipal(ctx, principal)
+	}
+
+	if s.config.OnConnect != nil {
+		if err := s.config.OnConnect(conn); err != nil {
+			s.reportError(conn, err)
+			if errFrame, frameErr := NewErrorFrame(err); frameErr == nil {
+				conn.WriteFrame(errFrame)
+			}
+			return
+		}
+	}
+
+	for !s.inShutdown.Load() {
+		f, err := conn.ReadFrame()
+		if err != nil {
+			if s.inShutdown.Load() {
+				s.drainConn(ctx, sc, handler)
+				return
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.reportError(conn, err)
+			var pErr *ProtocolError
+			if errors.As(err, &pErr) {
+				// 通知对端协议错误后断开
+				if errFrame, frameErr := NewErrorFrame(err); frameErr == nil {
+					conn.WriteFrame(errFrame)
+				}
+			}
+			return
+		}
+		if !s.serveFrame(ctx, sc, handler, f) {
+			return
+		}
+	}
+	s.drainConn(ctx, sc, handler)
+}
+
+// serveFrame 交给处理器处理一个帧，返回false表示回复错误帧失败、应断开连接
+func (s *Server) serveFrame(ctx context.Context, sc *serverConn, handler Handler, f *Frame) bool {
+	sc.handling.Store(true)
+	err := handler.ServeFrame(ctx, sc.conn, f)
+	sc.handling.Store(false)
+	if err != nil {
+		s.reportError(sc.conn, err)
+		if errFrame, frameErr := NewErrorFrame(err); frameErr == nil {
+			if sc.conn.WriteFrame(errFrame) != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// drainConn 关闭期间处理解码器中已完整缓冲的帧，对端已发出并被读入的帧不会丢失
+// 不再从连接读取，尚在网络中的数据被丢弃
+func (s *Server) drainConn(ctx context.Context, sc *serverConn, handler Handler) {
+	for {
+		f, err := sc.conn.bufferedFrame()
+		if err != nil || f == nil {
+			return
+		}
+		if !s.serveFrame(ctx, sc, handler, f) {
+			return
+		}
+	}
+}
+
+// authenticate 在连接上执行服务端认证流程
+// 认证期间将读取截止时间收紧到deadline，防止连接不发送认证帧而长期占用协程，
+// 认证成功后恢复ConfigureConn等设置的原截止时间
+func (s *Server) authenticate(ctx context.Context, conn *Conn, deadline time.Time) (*Principal, error) {
+	hs := NewServerHandshake(s.config.Authenticators...)
+	if s.config.Sessions != nil {
+		hs.SetSessionStore(s.config.Sessions)
+	}
+	previous := conn.callerReadDeadline()
+	if !previous.IsZero() && previous.Before(deadline) {
+		deadline = previous
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for hs.State() != AuthStateAuthenticated {
+		f, err := conn.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		reply, err := hs.HandleFrame(ctx, f)
+		if reply != nil {
+			if writeErr := conn.WriteFrame(reply); writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.SetReadDeadline(previous); err != nil {
+		return nil, err
+	}
+	return hs.Principal(), nil
+}
+
+// reportError 调用错误回调
+func (s *Server) reportError(c *Conn, err error) {
+	if s.config.OnError != nil {
+		s.config.OnError(c, err)
+	}
+}
+
+// Shutdown 优雅关闭服务端
+// 立即停止接受新连接，各连接处理完正在处理的帧以及已读入缓冲区的完整帧后关闭，不再读取新数据；
+// ctx到期时强制关闭剩余连接并返回ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.beginShutdown()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		// 使空闲连接阻塞中的读取立即返回，正在处理帧的连接在处理完后自行退出
+		s.mu.Lock()
+		for sc := range s.conns {
+			if !sc.handling.Load() {
+				sc.conn.SetReadDeadline(time.Now())
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			s.closeConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close 立即关闭所有监听器和连接
+func (s *Server) Close() error {
+	s.beginShutdown()
+	s.closeConns()
+	s.wg.Wait()
+	return nil
+}
+
+// beginShutdown 标记关闭并关闭所有监听器
+// 在锁内设置标记，保证之后不会再有连接登记
+func (s *Server) beginShutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inShutdown.Store(true)
+	for ln := range s.listeners {
+		ln.Close()
+	}
+}
+
+// closeConns 关闭所有连接
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sc := range s.conns {
+		sc.conn.Close()
+	}
+}
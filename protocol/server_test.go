@@ -0,0 +1,384 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestServer starts srv on a loopback listener and returns its address
+func startTestServer(t *testing.T, srv *Server) string {
+	t.Helper()
+	ln, err := Listen("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// TestServerRouting tests handler routing, middleware order and unknown frame types
+func TestServerRouting(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				return next.ServeFrame(ctx, w, f)
+			})
+		}
+	}
+
+	srv := NewServer(ServerConfig{})
+	srv.Use(trace("outer"), trace("inner"))
+	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+		if ConnFromContext(ctx) == nil {
+			t.Error("Expected connection in context")
+		}
+		reply, _ := NewFrame(FrameTypeJSON, append([]byte("echo:"), f.Body...))
+		return w.WriteFrame(reply)
+	})
+	addr := startTestServer(t, srv)
+
+	conn, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	frame, _ := NewFrame(FrameTypeJSON, []byte("hi"))
+	if err := conn.WriteFrame(frame); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	reply, err := conn.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if string(reply.Body) != "echo:hi" {
+		t.Errorf("Expected echo:hi, got %q", reply.Body)
+	}
+
+	// No handler for protobuf frames: an error frame is returned and the connection stays open
+	frame, _ = NewFrame(FrameTypeProtobuf, []byte{1})
+	conn.WriteFrame(frame)
+	reply, err = conn.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	var errMsg ErrorMessage
+	if err := json.Unmarshal(reply.Body, &errMsg); err != nil || errMsg.Code != ErrCodeInvalidFrameType {
+		t.Errorf("Expected invalid frame type error frame, got %s", reply.Body)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(order, ",") != "outer,inner,outer,inner" {
+		t.Errorf("Unexpected middleware order: %v", order)
+	}
+}
+
+// TestServerMaxConnections tests that connections beyond the limit are rejected
+func TestServerMaxConnections(t *testing.T) {
+	rejected := make(chan error, 1)
+	srv := NewServer(ServerConfig{
+		MaxConnections: 1,
+		OnError:        func(c *Conn, err error) { rejected <- err },
+	})
+	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error { return w.WriteFrame(f) })
+	addr := startTestServer(t, srv)
+
+	first, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer first.Close()
+	// Round trip to make sure the first connection is registered
+	frame, _ := NewFrame(FrameTypeJSON, []byte("{}"))
+	first.WriteFrame(frame)
+	if _, err := first.ReadFrame(); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+
+	second, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer second.Close()
+	if _, err := second.ReadFrame(); err == nil {
+		t.Error("Expected second connection to be closed")
+	}
+	if err := <-rejected; !errors.Is(err, ErrTooManyConnections) {
+		t.Errorf("Expected ErrTooManyConnections, got %v", err)
+	}
+	if n := srv.ConnCount(); n != 1 {
+		t.Errorf("Expected 1 connection, got %d", n)
+	}
+}
+
+// TestServerGracefulShutdown tests that in-flight frames finish before shutdown completes
+func TestServerGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := NewServer(ServerConfig{})
+	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+		close(started)
+		<-release
+		return w.WriteFrame(f)
+	})
+	addr := startTestServer(t, srv)
+
+	// An idle connection must not block shutdown
+	idle, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer idle.Close()
+
+	conn, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	frame, _ := NewFrame(FrameTypeJSON, []byte(`{"slow":true}`))
+	conn.WriteFrame(frame)
+	<-started
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- srv.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdownDone:
+		t.Fatal("Shutdown returned while a frame was in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	reply, err := conn.ReadFrame()
+	if err != nil || string(reply.Body) != `{"slow":true}` {
+		t.Fatalf("Expected in-flight reply, got %v, %v", reply, err)
+	}
+
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			t.Errorf("Unexpected shutdown error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for shutdown")
+	}
+	if srv.ConnCount() != 0 {
+		t.Errorf("Expected all connections to be closed, got %d", srv.ConnCount())
+	}
+	if err := srv.ListenAndServe("tcp", "127.0.0.1:0", nil); !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected ErrServerClosed, got %v", err)
+	}
+}
+
+// TestServerAuthentication tests that the server authenticates connections before routing frames
+func TestServerAuthentication(t *testing.T) {
+	srv := NewServer(ServerConfig{
+		Authenticators: []Authenticator{&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+			if token != "secret" {
+				return nil, errors.New("bad token")
+			}
+			return &Principal{ID: "alice"}, nil
+		}}},
+	})
+	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+		reply, _ := NewFrame(FrameTypeJSON, []byte(PrincipalFromContext(ctx).ID))
+		return w.WriteFrame(reply)
+	})
+	addr := startTestServer(t, srv)
+
+	conn, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := ClientHandshake(conn.NetConn(), conn.decoder, &TokenCredentials{Token: "secret"}); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+
+	frame, _ := NewFrame(FrameTypeJSON, []byte("{}"))
+	conn.WriteFrame(frame)
+	reply, err := conn.ReadFrame()
+	if err != nil || string(reply.Body) != "alice" {
+		t.Errorf("Expected principal alice, got %v, %v", reply, err)
+	}
+
+	bad, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer bad.Close()
+	if _, err := ClientHandshake(bad.NetConn(), bad.decoder, &TokenCredentials{Token: "wrong"}); !IsAuthFailedError(err) {
+		t.Errorf("Expected auth failure, got %v", err)
+	}
+}
+
+// TestServerAuthTimeout tests that connections which never authenticate are closed and authenticated ones are not
+func TestServerAuthTimeout(t *testing.T) {
+	errs := make(chan error, 2)
+	srv := NewServer(ServerConfig{
+		AuthTimeout: 50 * time.Millisecond,
+		Authenticators: []Authenticator{&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+			return &Principal{ID: "alice"}, nil
+		}}},
+		OnError: func(c *Conn, err error) { errs <- err },
+	})
+	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+		return w.WriteFrame(f)
+	})
+	addr := startTestServer(t, srv)
+
+	idle, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := idle.ReadFrame(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the server to close the connection, got %v", err)
+	}
+	if err := <-errs; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	// The deadline is cleared once authenticated
+	conn, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := ClientHandshake(conn.NetConn(), conn.decoder, &TokenCredentials{Token: "secret"}); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	frame, _ := NewFrame(FrameTypeJSON, []byte("{}"))
+	conn.WriteFrame(frame)
+	if _, err := conn.ReadFrame(); err != nil {
+		t.Errorf("Failed to read echo after the auth timeout: %v", err)
+	}
+}
+
+// TestServerAuthKeepsConnDeadline tests that authentication restores the read deadline set in ConfigureConn
+func TestServerAuthKeepsConnDeadline(t *testing.T) {
+	configured := time.Now().Add(time.Hour)
+	restored := make(chan time.Time, 1)
+	srv := NewServer(ServerConfig{
+		Authenticators: []Authenticator{&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+			return &Principal{ID: "alice"}, nil
+		}}},
+		ConfigureConn: func(c *Conn) { c.SetReadDeadline(configured) },
+		OnConnect: func(c *Conn) error {
+			restored <- c.callerReadDeadline()
+			return nil
+		},
+	})
+	addr := startTestServer(t, srv)
+
+	conn, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := ClientHandshake(conn.NetConn(), conn.decoder, &TokenCredentials{Token: "secret"}); err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	if deadline := <-restored; !deadline.Equal(configured) {
+		t.Errorf("Expected read deadline %v after authentication, got %v", configured, deadline)
+	}
+}
+
+// TestServerTLSHandshakeTimeout tests that AuthTimeout bounds the TLS handshake without authenticators
+func TestServerTLSHandshakeTimeout(t *testing.T) {
+	serverTLS, _ := newSelfSignedTLSConfigs(t)
+	ln, err := Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	errs := make(chan error, 1)
+	srv := NewServer(ServerConfig{
+		AuthTimeout: 50 * time.Millisecond,
+		OnError:     func(c *Conn, err error) { errs <- err },
+	})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// A raw TCP client that never starts the TLS handshake
+	raw, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer raw.Close()
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := raw.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the server to close the connection, got %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected handshake deadline, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the handshake failure to be reported")
+	}
+}
+
+// TestServerShutdownDrainsBufferedFrames tests that frames already read into the decoder are handled during shutdown
+func TestServerShutdownDrainsBufferedFrames(t *testing.T) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	srv := NewServer(ServerConfig{})
+	srv.HandleFunc(FrameTypeJSON, func(ctx context.Context, w FrameWriter, f *Frame) error {
+		started <- struct{}{}
+		<-release
+		return w.WriteFrame(f)
+	})
+	addr := startTestServer(t, srv)
+
+	conn, err := Dial(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Three frames in one write arrive together, so the last two wait in the decoder while the first is handled
+	var data []byte
+	for i := 0; i < 3; i++ {
+		frame, _ := NewFrame(FrameTypeJSON, []byte(fmt.Sprintf(`{"n":%d}`, i)))
+		encoded, _ := frame.Encode()
+		data = append(data, encoded...)
+	}
+	if err := conn.WriteEncoded(data); err != nil {
+		t.Fatalf("Failed to write frames: %v", err)
+	}
+	<-started
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- srv.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		reply, err := conn.ReadFrame()
+		if err != nil || string(reply.Body) != fmt.Sprintf(`{"n":%d}`, i) {
+			t.Fatalf("Reply %d: got %v, %v", i, reply, err)
+		}
+	}
+	if err := <-shutdownDone; err != nil {
+		t.Errorf("Unexpected shutdown error: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package protocol
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// DefaultSessionTTL 会话令牌的默认有效期
+const DefaultSessionTTL = 24 * time.Hour
+
+// sessionEntry 一个已签发的会话
+type sessionEntry struct {
+	// principal 会话对应的主体
+	principal *Principal
+	// expires 过期时间
+	expires time.Time
+}
+
+// SessionStore 服务端会话存储
+// 认证成功后签发会话令牌，客户端断线重连时可凭令牌恢复身份，无需重新提交凭据
+//
+// 使用示例：
+//
+//	sessions := NewSessionStore(time.Hour)
+//	hs := NewServerHandshake(&TokenAuthenticator{Validate: validate})
+//	hs.SetSessionStore(sessions)
+//
+// 过期的会话在恢复时删除，签发新会话时每隔一个有效期清理一次，无需定期调用Prune
+//
+// 并发安全说明：
+// SessionStore 的所有方法均为并发安全，应在所有连接间共享
+type SessionStore struct {
+	// mu 互斥锁，保护sessions
+	mu sync.Mutex
+	// ttl 会话有效期
+	ttl time.Duration
+	// sessions 令牌到会话的映射
+	sessions map[string]*sessionEntry
+	// nextPrune 签发时下一次清理过期会话的时间
+	nextPrune time.Time
+	// now 时钟，便于测试替换
+	now func() time.Time
+}
+
+// NewSessionStore 创建会话存储，ttl<=0时使用DefaultSessionTTL
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &SessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]*sessionEntry),
+		now:      time.Now,
+	}
+}
+
+// Issue 为主体签发新的会话令牌
+func (s *SessionStore) Issue(p *Principal) (string, error) {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if !now.Before(s.nextPrune) {
+		s.pruneLocked(now)
+		s.nextPrune = now.Add(s.ttl)
+	}
+	s.sessions[token] = &sessionEntry{principal: p, expires: now.Add(s.ttl)}
+	return token, nil
+}
+
+// Resume 凭令牌恢复会话，成功时顺延有效期
+// 令牌不存在或已过期时返回false
+func (s *SessionStore) Resume(token string) (*Principal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.sessions[token]
+	if !ok {
+		return nil, false
+	}
+	now := s.now()
+	if !now.Before(entry.expires) {
+		delete(s.sessions, token)
+		return nil, false
+	}
+	entry.expires = now.Add(s.ttl)
+	return entry.principal, true
+}
+
+// Revoke 吊销会话令牌
+func (s *SessionStore) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// Prune 清理所有过期的会话，返回清理的数量
+func (s *SessionStore) Prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pruneLocked(s.now())
+}
+
+// pruneLocked 删除在now之前过期的会话，返回删除的数量，调用方需持有锁
+func (s *SessionStore) pruneLocked(now time.Time) int {
+	n := 0
+	for token, entry := range s.sessions {
+		if !now.Before(entry.expires) {
+			delete(s.sessions, token)
+			n++
+		}
+	}
+	return n
+}
+
+// SessionAuthenticator 会话令牌认证器，由ServerHandshake.SetSessionStore自动注册
+type SessionAuthenticator struct {
+	// Store 会话存储
+	Store *SessionStore
+}
+
+// Method 实现Authenticator接口
+func (a *SessionAuthenticator) Method() string {
+	return AuthMethodSession
+}
+
+// Begin 实现Authenticator接口
+func (a *SessionAuthenticator) Begin(ctx context.Context, req *AuthMessage) (*Principal, []byte, error) {
+	principal, ok := a.Store.Resume(req.Session)
+	if !ok {
+		return nil, nil, NewAuthFailedError("session expired or unknown")
+	}
+	return principal, nil, nil
+}
+
+// Continue 实现Authenticator接口，会话恢复不使用挑战
+func (a *SessionAuthenticator) Continue(ctx context.Context, req *AuthMessage, challenge []byte, resp *AuthMessage) (*Principal, error) {
+	return nil, NewAuthFailedError("unexpected challenge response for session resumption")
+}
+
+// SetSessionStore 启用会话：认证成功时签发会话令牌，并接受AuthMethodSession方式的恢复请求
+func (h *ServerHandshake) SetSessionStore(store *SessionStore) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sessions = store
+	h.authenticators[AuthMethodSession] = &SessionAuthenticator{Store: store}
+}
+
+// issueSession 返回认证成功消息中携带的会话令牌
+// 会话恢复时沿用原令牌，其他方式签发新令牌
+// 调用方必须持有锁
+func (h *ServerHandshake) issueSession(msg *AuthMessage, principal *Principal) (string, error) {
+	if h.sessions == nil {
+		return "", nil
+	}
+	if msg.Kind == AuthKindRequest && msg.Method == AuthMethodSession {
+		return msg.Session, nil
+	}
+	return h.sessions.Issue(principal)
+}
+
+// SessionCredentials 会话令牌凭据，用于断线重连时恢复会话
+type SessionCredentials struct {
+	Token string
+}
+
+// Request 实现Credentials接口
+func (c *SessionCredentials) Request() *AuthMessage {
+	return &AuthMessage{Kind: AuthKindRequest, Method: AuthMethodSession, Session: c.Token}
+}
+
+// Respond 实现Credentials接口，会话恢复不应收到挑战
+func (c *SessionCredentials) Respond(challenge []byte) (*AuthMessage, error) {
+	return nil, NewAuthFailedError("unexpected challenge for session resumption")
+}
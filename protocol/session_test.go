@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSessionStore tests issuing, resuming, expiring and revoking sessions
+func TestSessionStore(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	store := NewSessionStore(time.Minute)
+	store.now = clock.Now
+
+	principal := &Principal{ID: "alice"}
+	token, err := store.Issue(principal)
+	if err != nil {
+		t.Fatalf("Failed to issue session: %v", err)
+	}
+
+	// Resuming extends the expiry
+	clock.Advance(50 * time.Second)
+	if p, ok := store.Resume(token); !ok || p != principal {
+		t.Fatalf("Expected session to resume, got %v, %v", p, ok)
+	}
+	clock.Advance(50 * time.Second)
+	if _, ok := store.Resume(token); !ok {
+		t.Fatal("Expected resumed session to be extended")
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok := store.Resume(token); ok {
+		t.Error("Expected expired session to be rejected")
+	}
+
+	token, _ = store.Issue(principal)
+	store.Revoke(token)
+	if _, ok := store.Resume(token); ok {
+		t.Error("Expected revoked session to be rejected")
+	}
+
+	store.Issue(principal)
+	clock.Advance(time.Minute)
+	if n := store.Prune(); n != 1 {
+		t.Errorf("Expected 1 pruned session, got %d", n)
+	}
+}
+
+// TestSessionStoreExpiresOnIssue tests that issuing sessions sweeps expired ones without calling Prune
+func TestSessionStoreExpiresOnIssue(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	store := NewSessionStore(time.Minute)
+	store.now = clock.Now
+
+	for i := 0; i < 100; i++ {
+		if _, err := store.Issue(&Principal{ID: "alice"}); err != nil {
+			t.Fatalf("Failed to issue session: %v", err)
+		}
+		clock.Advance(10 * time.Second)
+	}
+	// Sessions live for a minute and are swept at least once a minute
+	if n := len(store.sessions); n > 12 {
+		t.Errorf("Expected expired sessions to be swept, %d remain", n)
+	}
+}
+
+// TestServerHandshakeSession tests that handshakes issue sessions and accept resumption
+func TestServerHandshakeSession(t *testing.T) {
+	ctx := context.Background()
+	store := NewSessionStore(time.Hour)
+	newHandshake := func() *ServerHandshake {
+		hs := NewServerHandshake(&TokenAuthenticator{Validate: func(ctx context.Context, token string) (*Principal, error) {
+			return &Principal{ID: "alice"}, nil
+		}})
+		hs.SetSessionStore(store)
+		return hs
+	}
+
+	handle := func(hs *ServerHandshake, creds Credentials) (*AuthMessage, error) {
+		req, err := creds.Request().Frame()
+		if err != nil {
+			t.Fatalf("Failed to encode request: %v", err)
+		}
+		reply, err := hs.HandleFrame(ctx, req)
+		if reply == nil {
+			return nil, err
+		}
+		msg, parseErr := ParseAuthMessage(reply)
+		if parseErr != nil {
+			t.Fatalf("Failed to parse reply: %v", parseErr)
+		}
+		return msg, err
+	}
+
+	reply, err := handle(newHandshake(), &TokenCredentials{Token: "t"})
+	if err != nil || reply.Session == "" {
+		t.Fatalf("Expected session in success reply, got %+v, %v", reply, err)
+	}
+	session := reply.Session
+
+	hs := newHandshake()
+	reply, err = handle(hs, &SessionCredentials{Token: session})
+	if err != nil || reply.Kind != AuthKindSuccess {
+		t.Fatalf("Expected session resumption to succeed, got %+v, %v", reply, err)
+	}
+	if reply.Session != session || hs.Principal().ID != "alice" {
+		t.Errorf("Expected resumed session %s for alice, got %s for %v", session, reply.Session, hs.Principal())
+	}
+
+	reply, err = handle(newHandshake(), &SessionCredentials{Token: "unknown"})
+	if !IsAuthFailedError(err) || reply.Kind != AuthKindFailure {
+		t.Errorf("Expected unknown session to fail, got %+v, %v", reply, err)
+	}
+}
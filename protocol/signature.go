@@ -0,0 +1,184 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// 签名相关常量
+const (
+	// SignatureTagLength HMAC-SHA256签名标签长度
+	SignatureTagLength = sha256.Size
+	// SignatureTrailerLength 签名尾部长度：密钥ID(1字节) + HMAC标签(32字节)
+	SignatureTrailerLength = 1 + SignatureTagLength
+)
+
+// FrameSigner HMAC-SHA256帧签名器
+// 用于合规审计等需要防篡改但不需要加密的场景，帧内容保持明文可读
+//
+// 签名帧格式：
+// +--------+--------+--------+--------+--------+--------+--------+--------+--------+
+// | 版本号 | 子版本号 | 消息类型|FrameFlagSigned |     消息体长度（含签名尾部）      |
+// +--------+--------+--------+--------+--------+--------+--------+--------+--------+
+// |        消息体 (可变长度)         | 密钥ID (1字节) |   HMAC-SHA256 (32字节)   |
+// +--------+--------+--------+--------+--------+--------+--------+--------+--------+
+//
+// 签名范围：
+//   - HMAC覆盖帧头、序列号（如有）、消息体和密钥ID，任何字段被篡改都会导致校验失败
+//
+// 密钥轮换：
+//   - 签名器可同时持有多个密钥，以密钥ID区分
+//   - 编码时使用当前激活的密钥，并将其ID写入帧中
+//   - 解码时根据帧中携带的密钥ID选择密钥校验
+//   - 轮换流程：AddKey新密钥 → SetActiveKey切换 → 待旧帧消化完毕后RemoveKey旧密钥
+//
+// 并发安全说明：
+// FrameSigner 的所有方法均为并发安全
+type FrameSigner struct {
+	// mu 读写锁，保护密钥表
+	mu sync.RWMutex
+	// keys 密钥ID到密钥的映射
+	keys map[uint8][]byte
+	// activeKeyID 当前用于签名的密钥ID
+	activeKeyID uint8
+}
+
+// NewFrameSigner 创建帧签名器，并将给定密钥设为激活密钥
+//
+// 参数：
+//
+//	keyID - 密钥ID，随签名写入帧中
+//	key - HMAC密钥，不能为空
+func NewFrameSigner(keyID uint8, key []byte) (*FrameSigner, error) {
+	s := &FrameSigner{keys: make(map[uint8][]byte)}
+	if err := s.AddKey(keyID, key); err != nil {
+		return nil, err
+	}
+	s.activeKeyID = keyID
+	return s, nil
+}
+
+// AddKey 添加密钥（不改变当前激活的密钥）
+// 密钥会被深拷贝；已存在的同ID密钥会被替换
+func (s *FrameSigner) AddKey(keyID uint8, key []byte) error {
+	if len(key) == 0 {
+		return NewInvalidSignatureError(fmt.Sprintf("key %d is empty", keyID))
+	}
+
+	keyCopy := make([]byte, len(key))
+	copy(keyCopy, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = keyCopy
+	return nil
+}
+
+// SetActiveKey 切换用于签名的密钥
+func (s *FrameSigner) SetActiveKey(keyID uint8) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[keyID]; !ok {
+		return NewInvalidSignatureError(fmt.Sprintf("unknown key id %d", keyID))
+	}
+	s.activeKeyID = keyID
+	return nil
+}
+
+// RemoveKey 移除密钥，之后用该密钥签名的帧将无法通过校验
+// 当前激活的密钥不能被移除
+func (s *FrameSigner) RemoveKey(keyID uint8) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if keyID == s.activeKeyID {
+		return NewInvalidSignatureError(fmt.Sprintf("cannot remove active key %d", keyID))
+	}
+	delete(s.keys, keyID)
+	return nil
+}
+
+// ActiveKeyID 返回当前用于签名的密钥ID
+func (s *FrameSigner) ActiveKeyID() uint8 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.activeKeyID
+}
+
+// activeMAC 返回激活密钥ID及对应的HMAC实例
+func (s *FrameSigner) activeMAC() (uint8, hash.Hash) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.activeKeyID, hmac.New(sha256.New, s.keys[s.activeKeyID])
+}
+
+// signTo 对已写入的帧头和消息体签名，将签名尾部写入dst，返回写入长度
+// dst 长度必须不小于 SignatureTrailerLength
+func (s *FrameSigner) signTo(signed []byte, dst []byte) int {
+	keyID, mac := s.activeMAC()
+	dst[0] = keyID
+	mac.Write(signed)
+	mac.Write(dst[:1])
+	mac.Sum(dst[1:1])
+	return SignatureTrailerLength
+}
+
+// trailer 计算帧头和消息体的签名尾部，用于流式写入
+func (s *FrameSigner) trailer(header, body []byte) []byte {
+	keyID, mac := s.activeMAC()
+	trailer := make([]byte, 1, SignatureTrailerLength)
+	trailer[0] = keyID
+	mac.Write(header)
+	mac.Write(body)
+	mac.Write(trailer)
+	return mac.Sum(trailer)
+}
+
+// verify 校验完整帧数据（帧头 + 消息体 + 签名尾部）的签名，返回签名所用的密钥ID
+func (s *FrameSigner) verify(raw []byte) (uint8, error) {
+	tagStart := len(raw) - SignatureTagLength
+	keyID := raw[tagStart-1]
+
+	s.mu.RLock()
+	key, ok := s.keys[keyID]
+	s.mu.RUnlock()
+	if !ok {
+		return 0, NewInvalidSignatureError(fmt.Sprintf("unknown key id %d", keyID))
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw[:tagStart])
+	if !hmac.Equal(mac.Sum(nil), raw[tagStart:]) {
+		return 0, NewInvalidSignatureError(fmt.Sprintf("HMAC mismatch for key id %d", keyID))
+	}
+	return keyID, nil
+}
+
+// 签名选项实现
+type signerOption struct {
+	signer *FrameSigner
+}
+
+func (o *signerOption) isEncodeOption() {}
+
+// WithSigner 使用签名器对帧签名
+// 编码期选项，用于Encode、EncodeTo和EncodeToBytes
+func WithSigner(signer *FrameSigner) EncodeOption {
+	return &signerOption{signer: signer}
+}
+
+// 签名校验选项实现
+type verifierOption struct {
+	signer *FrameSigner
+}
+
+func (o *verifierOption) isDecodeOption() {}
+
+// WithSignatureVerifier 使用签名器校验帧签名
+// 解码期选项，用于Decode和StreamDecoder.SetDecodeOptions
+// 配置后未签名或签名错误的帧都会被拒绝，返回ErrCodeInvalidSignature错误
+func WithSignatureVerifier(signer *FrameSigner) DecodeOption {
+	return &verifierOption{signer: signer}
+}
@@ -0,0 +1,237 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSignedFrameEncodeDecode tests signing at encode time and verifying at decode time
+func TestSignedFrameEncodeDecode(t *testing.T) {
+	signer, err := NewFrameSigner(1, []byte("secret-key-1"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	body := []byte(`{"message":"hello"}`)
+	frame, err := NewFrame(FrameTypeJSON, body)
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+
+	data, err := frame.Encode(WithSigner(signer))
+	if err != nil {
+		t.Fatalf("Failed to encode signed frame: %v", err)
+	}
+
+	if len(data) != FrameHeaderLength+len(body)+SignatureTrailerLength {
+		t.Errorf("Unexpected signed frame length %d", len(data))
+	}
+
+	if data[2] != FrameTypeJSON|FrameFlagSigned {
+		t.Errorf("Expected signed flag in type byte, got %#x", data[2])
+	}
+
+	// Body stays readable on the wire
+	if !bytes.Equal(data[FrameHeaderLength:FrameHeaderLength+len(body)], body) {
+		t.Error("Expected plaintext body in signed frame")
+	}
+
+	decoded, err := Decode(data, WithSignatureVerifier(signer))
+	if err != nil {
+		t.Fatalf("Failed to decode signed frame: %v", err)
+	}
+
+	if !decoded.Signed || decoded.KeyID != 1 {
+		t.Errorf("Expected signed frame with key id 1, got Signed=%v KeyID=%d", decoded.Signed, decoded.KeyID)
+	}
+
+	if decoded.Type != FrameTypeJSON {
+		t.Errorf("Expected frame type %d, got %d", FrameTypeJSON, decoded.Type)
+	}
+
+	if !bytes.Equal(decoded.Body, body) || decoded.GetBodyLength() != uint32(len(body)) {
+		t.Errorf("Expected body %q, got %q", body, decoded.Body)
+	}
+
+	// EncodeTo and EncodeToBytes must produce identical bytes
+	var buf bytes.Buffer
+	if _, err := frame.EncodeTo(&buf, WithSigner(signer)); err != nil {
+		t.Fatalf("Failed to encode signed frame to writer: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("EncodeTo output differs from Encode output")
+	}
+
+	raw := make([]byte, len(data))
+	if _, err := frame.EncodeToBytes(raw, WithSigner(signer)); err != nil {
+		t.Fatalf("Failed to encode signed frame to bytes: %v", err)
+	}
+	if !bytes.Equal(raw, data) {
+		t.Error("EncodeToBytes output differs from Encode output")
+	}
+}
+
+// TestSignedFrameTampering tests that tampered, stripped and unverifiable frames are rejected
+func TestSignedFrameTampering(t *testing.T) {
+	signer, err := NewFrameSigner(1, []byte("secret-key-1"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	frame, err := NewFrame(FrameTypeJSON, []byte("audit me"))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+
+	data, err := frame.Encode(WithSigner(signer))
+	if err != nil {
+		t.Fatalf("Failed to encode signed frame: %v", err)
+	}
+
+	// Tampered body
+	tampered := append([]byte(nil), data...)
+	tampered[FrameHeaderLength] ^= 0xFF
+	if _, err := Decode(tampered, WithSignatureVerifier(signer)); !IsSignatureError(err) {
+		t.Errorf("Expected signature error for tampered body, got %v", err)
+	}
+
+	// Tampered header
+	tampered = append([]byte(nil), data...)
+	tampered[1] = 9
+	if _, err := Decode(tampered, WithSignatureVerifier(signer)); !IsSignatureError(err) {
+		t.Errorf("Expected signature error for tampered header, got %v", err)
+	}
+
+	// Unsigned frame while a verifier is configured
+	plain, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+	if _, err := Decode(plain, WithSignatureVerifier(signer)); !IsSignatureError(err) {
+		t.Errorf("Expected signature error for unsigned frame, got %v", err)
+	}
+
+	// Signed frame without a verifier
+	if _, err := Decode(data); !IsSignatureError(err) {
+		t.Errorf("Expected signature error without verifier, got %v", err)
+	}
+
+	// Wrong key
+	other, err := NewFrameSigner(1, []byte("another-key"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	if _, err := Decode(data, WithSignatureVerifier(other)); !IsSignatureError(err) {
+		t.Errorf("Expected signature error for wrong key, got %v", err)
+	}
+}
+
+// TestFrameSignerKeyRotation tests verifying frames signed with old and new keys
+func TestFrameSignerKeyRotation(t *testing.T) {
+	signer, err := NewFrameSigner(1, []byte("old-key"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	frame, err := NewFrame(FrameTypeMsgPack, []byte("payload"))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+
+	oldData, err := frame.Encode(WithSigner(signer))
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	if err := signer.SetActiveKey(2); !IsSignatureError(err) {
+		t.Errorf("Expected error activating unknown key, got %v", err)
+	}
+
+	if err := signer.AddKey(2, []byte("new-key")); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if err := signer.SetActiveKey(2); err != nil {
+		t.Fatalf("Failed to activate key: %v", err)
+	}
+
+	newData, err := frame.Encode(WithSigner(signer))
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	for _, tc := range []struct {
+		data  []byte
+		keyID uint8
+	}{{oldData, 1}, {newData, 2}} {
+		decoded, err := Decode(tc.data, WithSignatureVerifier(signer))
+		if err != nil {
+			t.Fatalf("Failed to decode frame signed with key %d: %v", tc.keyID, err)
+		}
+		if decoded.KeyID != tc.keyID {
+			t.Errorf("Expected key id %d, got %d", tc.keyID, decoded.KeyID)
+		}
+	}
+
+	if err := signer.RemoveKey(2); !IsSignatureError(err) {
+		t.Errorf("Expected error removing active key, got %v", err)
+	}
+
+	if err := signer.RemoveKey(1); err != nil {
+		t.Fatalf("Failed to remove key: %v", err)
+	}
+	if _, err := Decode(oldData, WithSignatureVerifier(signer)); !IsSignatureError(err) {
+		t.Errorf("Expected signature error for removed key, got %v", err)
+	}
+}
+
+// TestStreamDecoderSignedFrames tests signature verification in StreamDecoder.TryDecode
+func TestStreamDecoderSignedFrames(t *testing.T) {
+	signer, err := NewFrameSigner(7, []byte("stream-key"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	frame, err := NewFrame(FrameTypeJSON, []byte("message1"))
+	if err != nil {
+		t.Fatalf("Failed to create frame: %v", err)
+	}
+
+	data, err := frame.Encode(WithSigner(signer))
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+
+	decoder := NewStreamDecoder()
+	decoder.SetDecodeOptions(WithSignatureVerifier(signer))
+
+	// Feed in two chunks to exercise partial frames
+	if err := decoder.Feed(data[:10]); err != nil {
+		t.Fatalf("Failed to feed data: %v", err)
+	}
+	if decoded, err := decoder.TryDecode(); err != nil || decoded != nil {
+		t.Fatalf("Expected no frame yet, got %v, %v", decoded, err)
+	}
+	if err := decoder.Feed(data[10:]); err != nil {
+		t.Fatalf("Failed to feed data: %v", err)
+	}
+
+	decoded, err := decoder.TryDecode()
+	if err != nil {
+		t.Fatalf("Failed to decode signed frame: %v", err)
+	}
+	if decoded == nil || !decoded.Signed || !bytes.Equal(decoded.Body, frame.Body) {
+		t.Errorf("Unexpected decoded frame: %v", decoded)
+	}
+
+	// Unsigned frames are rejected
+	plain, err := frame.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+	if err := decoder.Feed(plain); err != nil {
+		t.Fatalf("Failed to feed data: %v", err)
+	}
+	if _, err := decoder.TryDecode(); !IsSignatureError(err) {
+		t.Errorf("Expected signature error for unsigned frame, got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 离线消息存储默认配额
+const (
+	// DefaultStoreMaxMessages 每个接收者默认最多保存的消息数
+	DefaultStoreMaxMessages = 1000
+	// DefaultStoreMaxBytes 每个接收者默认最多保存的字节数
+	DefaultStoreMaxBytes = 8 * 1024 * 1024
+	// DefaultStoreMaxAge 消息默认的最长保存时间
+	DefaultStoreMaxAge = 7 * 24 * time.Hour
+	// MaxStoredMessageLength 单条离线消息的最大字节数，即最大的帧编码长度（序列号和签名尾部计入MaxMessageLength）
+	// 与配额无关，FileStore重新加载时同样以此拒绝过大的记录
+	MaxStoredMessageLength = FrameHeaderLength + MaxMessageLength
+)
+
+// ErrStoreClosed 存储已关闭
+var ErrStoreClosed = errors.New("protocol: message store closed")
+
+// StoreQuota 每个接收者的离线消息配额，零值字段使用默认值，负值表示不限制
+// 超出条数或字节数配额时丢弃最旧的消息；超过保存时间的消息在下次访问该接收者或Prune时删除
+type StoreQuota struct {
+	// MaxMessages 最多保存的消息数，默认DefaultStoreMaxMessages
+	MaxMessages int
+	// MaxBytes 最多保存的帧编码字节数，默认DefaultStoreMaxBytes，单条消息超过该值时拒绝保存
+	MaxBytes int64
+	// MaxAge 消息的最长保存时间，默认DefaultStoreMaxAge
+	MaxAge time.Duration
+}
+
+// withDefaults 返回填充默认值后的配额
+func (q StoreQuota) withDefaults() StoreQuota {
+	if q.MaxMessages == 0 {
+		q.MaxMessages = DefaultStoreMaxMessages
+	}
+	if q.MaxBytes == 0 {
+		q.MaxBytes = DefaultStoreMaxBytes
+	}
+	if q.MaxAge == 0 {
+		q.MaxAge = DefaultStoreMaxAge
+	}
+	return q
+}
+
+// StoredMessage 一条离线消息
+type StoredMessage struct {
+	// Seq 接收者内递增的序号，Pending按序号返回；接收者的消息全部删除后序号可能重新开始
+	Seq uint64
+	// ID 消息ID，接收者确认后按ID删除，为空时只能因配额或过期删除
+	ID string
+	// Stored 保存时间
+	Stored time.Time
+	// Data 完整的帧编码，可直接通过Conn.WriteEncoded发送
+	Data []byte
+}
+
+// MessageStore 离线消息存储，按接收者保存已编码的帧，接收者上线后按保存顺序投递，确认后删除
+//
+// 内置实现：
+//   - MemoryStore：保存在内存中，进程重启后丢失
+//   - FileStore：每个接收者一个追加写文件，进程重启后可恢复
+//
+// 实现需要支持并发调用
+type MessageStore interface {
+	// Put 为接收者保存一条帧编码，id为消息ID，可为空
+	Put(recipient, id string, data []byte) error
+	// Pending 按保存顺序返回接收者尚未确认的消息
+	Pending(recipient string) ([]StoredMessage, error)
+	// Ack 删除接收者已确认的消息，未知的ID被忽略
+	Ack(recipient string, ids ...string) error
+	// Close 关闭存储
+	Close() error
+}
+
+// StoreFrame 编码帧并为接收者保存，消息ID取自帧中的信封消息（见EnvelopeMessageID）
+func StoreFrame(store MessageStore, recipient string, f *Frame, options ...EncodeOption) error {
+	data, err := f.Encode(options...)
+	if err != nil {
+		return err
+	}
+	id, _ := EnvelopeMessageID(f)
+	return store.Put(recipient, id, data)
+}
+
+// DeliverStored 按保存顺序将接收者的离线消息写入连接，返回写入的消息数
+// 消息在收到接收者的Ack之前不会删除（见AckStored），投递中断后下次会重新投递，接收方应去重
+//
+// 使用示例：
+//
+//	srv := NewServer(ServerConfig{
+//		OnConnect: func(c *Conn) error {
+//			_, err := DeliverStored(store, c.Principal().ID, c)
+//			return err
+//		},
+//	})
+//	srv.Use(AckStored(store))
+func DeliverStored(store MessageStore, recipient string, c *Conn) (int, error) {
+	messages, err := store.Pending(recipient)
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range messages {
+		if err := c.WriteEncoded(msg.Data); err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
+// AckStored 返回在收到Ack消息时删除对应离线消息的中间件，Ack帧随后仍交给后续处理器
+// 接收者取自context中的认证主体，未认证连接上的Ack不做处理
+func AckStored(store MessageStore) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+			if op, ok := envelopeOpcode(f); !ok || op != OpAck {
+				return next.ServeFrame(ctx, w, f)
+			}
+			p := PrincipalFromContext(ctx)
+			if p == nil {
+				if conn := ConnFromContext(ctx); conn != nil {
+					p = conn.Principal()
+				}
+			}
+			if p != nil {
+				msg, err := ParseMessage(f)
+				if err != nil {
+					return err
+				}
+				ack, ok := msg.(*Ack)
+				if !ok {
+					return NewInvalidMessageError(fmt.Sprintf("ack opcode parsed as %T", msg))
+				}
+				if err := store.Ack(p.ID, ack.MessageID); err != nil {
+					return err
+				}
+			}
+			return next.ServeFrame(ctx, w, f)
+		})
+	}
+}
+
+// mailboxEntry 信箱中的一条消息
+type mailboxEntry struct {
+	StoredMessage
+	// size 帧编码的字节数
+	size int64
+	// offset 消息记录在文件中的偏移，仅FileStore使用
+	offset int64
+}
+
+// mailbox 一个接收者的离线消息，按序号排列，负责配额计算
+type mailbox struct {
+	// entries 按序号排列的消息
+	entries []mailboxEntry
+	// bytes 所有消息的帧编码字节数
+	bytes int64
+	// nextSeq 下一条消息的序号
+	nextSeq uint64
+}
+
+// add 追加一条消息，返回因超出配额被丢弃的最旧消息
+func (m *mailbox) add(e mailboxEntry, quota StoreQuota) []mailboxEntry {
+	m.entries = append(m.entries, e)
+	m.bytes += e.size
+	if e.Seq >= m.nextSeq {
+		m.nextSeq = e.Seq + 1
+	}
+
+	var n int
+	for n < len(m.entries)-1 &&
+		((quota.MaxMessages > 0 && len(m.entries)-n > quota.MaxMessages) ||
+			(quota.MaxBytes > 0 && m.bytes > quota.MaxBytes)) {
+		m.bytes -= m.entries[n].size
+		n++
+	}
+	return m.drop(n)
+}
+
+// expire 删除保存时间早于before的消息并返回
+func (m *mailbox) expire(before time.Time) []mailboxEntry {
+	n := sort.Search(len(m.entries), func(i int) bool { return !m.entries[i].Stored.Before(before) })
+	for _, e := range m.entries[:n] {
+		m.bytes -= e.size
+	}
+	return m.drop(n)
+}
+
+// drop 移除最前面的n条消息并返回
+func (m *mailbox) drop(n int) []mailboxEntry {
+	if n == 0 {
+		return nil
+	}
+	dropped := append([]mailboxEntry(nil), m.entries[:n]...)
+	m.entries = append(m.entries[:0], m.entries[n:]...)
+	return dropped
+}
+
+// remove 删除指定ID的消息并返回
+func (m *mailbox) remove(ids []string) []mailboxEntry {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if id != "" {
+			set[id] = struct{}{}
+		}
+	}
+
+	var removed []mailboxEntry
+	kept := m.entries[:0]
+	for _, e := range m.entries {
+		if _, ok := set[e.ID]; ok {
+			removed = append(removed, e)
+			m.bytes -= e.size
+			continue
+		}
+		kept = append(kept, e)
+	}
+	m.entries = kept
+	return removed
+}
+
+// checkStoreSize 检查单条消息是否超过最大长度或字节数配额
+func checkStoreSize(data []byte, quota StoreQuota) error {
+	if len(data) > MaxStoredMessageLength {
+		return NewMessageTooLongError(len(data), MaxStoredMessageLength)
+	}
+	if quota.MaxBytes > 0 && int64(len(data)) > quota.MaxBytes {
+		return NewMessageTooLongError(len(data), int(quota.MaxBytes))
+	}
+	return nil
+}
+
+// MemoryStore 内存离线消息存储
+//
+// 并发安全说明：
+// 所有方法都可以并发调用
+type MemoryStore struct {
+	quota StoreQuota
+
+	mu sync.Mutex
+	// mailboxes 接收者到信箱的映射
+	mailboxes map[string]*mailbox
+	// closed 是否已关闭
+	closed bool
+
+	// now 返回当前时间，测试时可替换
+	now func() time.Time
+}
+
+// NewMemoryStore 创建内存离线消息存储
+func NewMemoryStore(quota StoreQuota) *MemoryStore {
+	return &MemoryStore{
+		quota:     quota.withDefaults(),
+		mailboxes: make(map[string]*mailbox),
+		now:       time.Now,
+	}
+}
+
+// Put 实现MessageStore接口
+func (s *MemoryStore) Put(recipient, id string, data []byte) error {
+	if err := checkStoreSize(data, s.quota); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	m := s.mailbox(recipient)
+	e := mailboxEntry{
+		StoredMessage: StoredMessage{Seq: m.nextSeq, ID: id, Stored: s.now(), Data: append([]byte(nil), data...)},
+		size:          int64(len(data)),
+	}
+	m.add(e, s.quota)
+	return nil
+}
+
+// Pending 实现MessageStore接口，返回的Data不能修改
+func (s *MemoryStore) Pending(recipient string) ([]StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	m, ok := s.mailboxes[recipient]
+	if !ok {
+		return nil, nil
+	}
+	s.expire(recipient, m)
+	messages := make([]StoredMessage, len(m.entries))
+	for i, e := range m.entries {
+		messages[i] = e.StoredMessage
+	}
+	return messages, nil
+}
+
+// Ack 实现MessageStore接口
+func (s *MemoryStore) Ack(recipient string, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	if m, ok := s.mailboxes[recipient]; ok {
+		m.remove(ids)
+		if len(m.entries) == 0 {
+			delete(s.mailboxes, recipient)
+		}
+	}
+	return nil
+}
+
+// Prune 删除所有接收者的过期消息，返回删除的消息数，可定期调用以回收长期不上线用户的内存
+func (s *MemoryStore) Prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for recipient, m := range s.mailboxes {
+		n += s.expire(recipient, m)
+	}
+	return n
+}
+
+// Close 实现MessageStore接口，释放所有消息
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.mailboxes = nil
+	return nil
+}
+
+// mailbox 返回接收者的信箱，不存在时创建，调用方需持有锁
+func (s *MemoryStore) mailbox(recipient string) *mailbox {
+	if m, ok := s.mailboxes[recipient]; ok {
+		s.expire(recipient, m)
+	}
+	m, ok := s.mailboxes[recipient]
+	if !ok {
+		m = &mailbox{}
+		s.mailboxes[recipient] = m
+	}
+	return m
+}
+
+// expire 删除信箱中的过期消息，信箱为空时移除，返回删除的消息数，调用方需持有锁
+func (s *MemoryStore) expire(recipient string, m *mailbox) int {
+	var n int
+	if s.quota.MaxAge > 0 {
+		n = len(m.expire(s.now().Add(-s.quota.MaxAge)))
+	}
+	if len(m.entries) == 0 {
+		delete(s.mailboxes, recipient)
+	}
+	return n
+}
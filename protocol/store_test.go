@@ -0,0 +1,156 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// storeFactories creates each MessageStore implementation driven by a fake clock
+var storeFactories = map[string]func(t *testing.T, quota StoreQuota, clock *fakeClock) MessageStore{
+	"memory": func(t *testing.T, quota StoreQuota, clock *fakeClock) MessageStore {
+		store := NewMemoryStore(quota)
+		store.now = clock.Now
+		return store
+	},
+	"file": func(t *testing.T, quota StoreQuota, clock *fakeClock) MessageStore {
+		store, err := NewFileStore(t.TempDir(), FileStoreConfig{Quota: quota})
+		if err != nil {
+			t.Fatalf("Failed to create file store: %v", err)
+		}
+		store.now = clock.Now
+		return store
+	},
+}
+
+// expectPending checks the IDs of the pending messages in order
+func expectPending(t *testing.T, store MessageStore, recipient string, ids ...string) []StoredMessage {
+	t.Helper()
+	messages, err := store.Pending(recipient)
+	if err != nil {
+		t.Fatalf("Failed to list pending messages: %v", err)
+	}
+	got := make([]string, len(messages))
+	for i, msg := range messages {
+		got[i] = msg.ID
+	}
+	if fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Fatalf("Expected pending %v, got %v", ids, got)
+	}
+	return messages
+}
+
+// TestMessageStore tests ordering, acks and quotas for every implementation
+func TestMessageStore(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(1700000000, 0)}
+			store := newStore(t, StoreQuota{MaxMessages: 3, MaxBytes: 100, MaxAge: time.Hour}, clock)
+			defer store.Close()
+
+			for i := 1; i <= 3; i++ {
+				if err := store.Put("bob", fmt.Sprintf("m%d", i), []byte(fmt.Sprintf("frame-%d", i))); err != nil {
+					t.Fatalf("Failed to store message: %v", err)
+				}
+				clock.Advance(time.Minute)
+			}
+			store.Put("carol", "c1", []byte("frame-c"))
+
+			messages := expectPending(t, store, "bob", "m1", "m2", "m3")
+			if string(messages[1].Data) != "frame-2" || messages[1].Seq <= messages[0].Seq {
+				t.Errorf("Unexpected message: %+v", messages[1])
+			}
+
+			if err := store.Ack("bob", "m2", "unknown"); err != nil {
+				t.Fatalf("Failed to ack: %v", err)
+			}
+			expectPending(t, store, "bob", "m1", "m3")
+
+			// Exceeding the message quota drops the oldest
+			store.Put("bob", "m4", []byte("frame-4"))
+			store.Put("bob", "m5", []byte("frame-5"))
+			expectPending(t, store, "bob", "m3", "m4", "m5")
+
+			// Exceeding the byte quota drops the oldest; a single oversized message is rejected
+			store.Put("bob", "m6", make([]byte, 90))
+			expectPending(t, store, "bob", "m5", "m6")
+			if err := store.Put("bob", "huge", make([]byte, 101)); !IsMessageTooLongError(err) {
+				t.Errorf("Expected message too long error, got %v", err)
+			}
+
+			// Messages older than MaxAge expire
+			clock.Advance(2 * time.Hour)
+			expectPending(t, store, "bob")
+			expectPending(t, store, "carol")
+
+			store.Put("bob", "m7", []byte("frame-7"))
+			store.Ack("bob", "m7")
+			expectPending(t, store, "bob")
+
+			store.Close()
+			if err := store.Put("bob", "m8", nil); err != ErrStoreClosed {
+				t.Errorf("Expected ErrStoreClosed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestDeliverAndAckStored tests delivering stored frames on connect and deleting them on ack
+func TestDeliverAndAckStored(t *testing.T) {
+	store := NewMemoryStore(StoreQuota{})
+	for i := 1; i <= 2; i++ {
+		frame, err := EncodeMessage(FrameTypeJSON, &ChatMessage{EnvelopeHeader: EnvelopeHeader{ID: fmt.Sprintf("m%d", i)}, From: "alice", To: "bob", Content: "hi"})
+		if err != nil {
+			t.Fatalf("Failed to encode message: %v", err)
+		}
+		if err := StoreFrame(store, "bob", frame); err != nil {
+			t.Fatalf("Failed to store frame: %v", err)
+		}
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	peer := NewConn(client)
+	delivered := make(chan error, 1)
+	go func() {
+		_, err := DeliverStored(store, "bob", NewConn(server))
+		delivered <- err
+	}()
+	for i := 1; i <= 2; i++ {
+		f, err := peer.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read stored frame: %v", err)
+		}
+		if id, _ := EnvelopeMessageID(f); id != fmt.Sprintf("m%d", i) {
+			t.Errorf("Expected m%d, got %s", i, id)
+		}
+	}
+	if err := <-delivered; err != nil {
+		t.Fatalf("Failed to deliver: %v", err)
+	}
+
+	var reached int
+	handler := AckStored(store)(HandlerFunc(func(ctx context.Context, w FrameWriter, f *Frame) error {
+		reached++
+		return nil
+	}))
+	ack, _ := EncodeMessage(FrameTypeJSON, &Ack{MessageID: "m1"})
+	ctx := ContextWithPrincipal(context.Background(), &Principal{ID: "bob"})
+	if err := handler.ServeFrame(ctx, &recordingWriter{}, ack); err != nil {
+		t.Fatalf("Failed to handle ack: %v", err)
+	}
+	if reached != 1 {
+		t.Error("Expected ack to reach the next handler")
+	}
+	expectPending(t, store, "bob", "m2")
+
+	// A custom message registered for the ack opcode is rejected instead of panicking
+	RegisterMessage(OpAck, func() Message { return &customMessage{} })
+	defer RegisterMessage(OpAck, func() Message { return &Ack{} })
+	if err := handler.ServeFrame(ctx, &recordingWriter{}, ack); !IsInvalidMessageError(err) {
+		t.Errorf("Expected invalid message error, got %v", err)
+	}
+}